@@ -1,9 +1,30 @@
 package access
 
-import "net/http"
+import (
+	"net/http"
 
-func init() {
-	// wip
-	http.HandleFunc("/admin/staff", func(w http.ResponseWriter, r *http.Request) {})
-	http.HandleFunc("/admin/verify", func(w http.ResponseWriter, r *http.Request) {})
+	"service/config"
+	"service/log"
+	"service/router"
+)
+
+func (h *Handler) registerAdmin(rt *router.Router) {
+	rt.With(router.JSON, h.RequireAdmin).Doc(router.Operation{
+		Summary:  "Reload the runtime configuration",
+		Auth:     router.Admin,
+		Response: config.Reload{},
+	}).Post("/admin/config/reload", func(w http.ResponseWriter, r *http.Request) {
+		u := User(r)
+
+		res, err := h.store.Reload()
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to reload configuration: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidConfig, "Failed to reload configuration", router.Details{"error": err.Error()})
+			return
+		}
+
+		log.Ctx(r.Context()).Print("Configuration reloaded by %s: %s", u.Username, res)
+
+		router.WriteJSON(w, http.StatusOK, res)
+	})
 }
@@ -1,105 +1,96 @@
 package access
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"time"
 
 	"service/log"
+	"service/metrics"
 	"service/utils"
 
 	"github.com/patrickmn/go-cache"
 )
 
-var argonCache = cache.New(15*time.Minute, 10*time.Minute)
-var invalids = cache.New(5*time.Minute, 10*time.Minute)
+// Calls to the Argon server, failing with "rejected" for bad tokens and
+// "error" when the server could not answer
+var (
+	argonDuration = metrics.NewHistogram("argon_validation_duration_seconds", "Time taken by the Argon server to validate a token.", metrics.DefaultBuckets)
+	argonFailures = metrics.NewCounter("argon_validation_failures_total", "Failed Argon validations by reason.", "reason")
+)
 
-func getToken() (string, error) {
-	token := os.Getenv("ARGON")
-	if token == "" {
-		return "", fmt.Errorf("env for argon token is not defined!")
-	} else {
-		return token, nil
-	}
-}
+// ErrArgonInvalid is wrapped by validation errors caused by the player's
+// account or token rather than by the Argon server
+var ErrArgonInvalid = errors.New("argon user invalid")
 
-func ReportBanArgonUser(report *utils.Report, banned bool) error {
-	stmt, err := utils.PrepareStmt(utils.Db(), "UPDATE argon SET report_banned = ? WHERE account_id = ?")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+func (h *Handler) ReportBanArgonUser(ctx context.Context, report *utils.Report, banned bool) error {
+	return h.argon.SetReportBanned(ctx, report.AccountID, banned)
+}
 
-	_, err = stmt.Exec(banned, report.AccountID)
-	return err
+func (h *Handler) GetArgonUser(ctx context.Context, id int) (*utils.ArgonUser, error) {
+	return h.argon.Get(ctx, id)
 }
 
-func GetArgonUser(id int) (*utils.ArgonUser, error) {
-	stmt, err := utils.PrepareStmt(utils.Db(), "SELECT * FROM argon WHERE account_id = ?")
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
+func (h *Handler) UpsertArgonUser(ctx context.Context, user *utils.ArgonUser) error {
+	user.Token = ""
 
-	user := new(utils.ArgonUser)
-	err = stmt.QueryRow(id).Scan(&user.Account, &user.Token, &user.ReportBanned, &user.ValidAt)
-	if err != nil {
-		return nil, err
-	}
+	h.argonCache.Set(fmt.Sprintf("%d", user.Account), user, cache.DefaultExpiration)
+	log.Ctx(ctx).Debug("Argon cache entry added for account %d, total entries: %d", user.Account, h.argonCache.ItemCount())
 
-	return user, nil
+	return h.argon.Upsert(ctx, user)
 }
 
-func UpsertArgonUser(user *utils.ArgonUser) error {
-	user.Token = ""
+func (h *Handler) ValidateArgonUser(ctx context.Context, user *utils.ArgonUser) (bool, error) {
+	log.Annotate(ctx, "argon", strconv.Itoa(user.Account))
+	log.Redact(ctx, user.Token)
 
-	argonCache.Set(fmt.Sprintf("%d", user.Account), user, cache.DefaultExpiration)
-	log.Debug("Argon cache entry added for account %d, total entries: %d", user.Account, argonCache.ItemCount())
+	_, found := h.invalids.Get(fmt.Sprintf("%d", user.Account))
+	metrics.Cache("argon_invalids", found)
 
-	stmt, err := utils.PrepareStmt(utils.Db(), "INSERT INTO argon (account_id, authtoken) VALUES (?, ?) ON DUPLICATE KEY UPDATE authtoken = VALUES (authtoken), valid_at = CURRENT_TIMESTAMP")
-	if err != nil {
-		return err
+	if found {
+		return false, fmt.Errorf("%w: token was rejected before", ErrArgonInvalid)
 	}
 
-	_, err = stmt.Exec(user.Account, user.Token)
-	return err
-}
-
-func ValidateArgonUser(user *utils.ArgonUser) (bool, error) {
-	if val, found := invalids.Get(fmt.Sprintf("%d", user.Account)); found {
-		return false, fmt.Errorf("Argon token %s is invalid", val.(string))
-	}
+	_, found = h.argonCache.Get(fmt.Sprintf("%d", user.Account))
+	metrics.Cache("argon", found)
 
-	if _, found := argonCache.Get(fmt.Sprintf("%d", user.Account)); found {
+	if found {
 		return found, nil
 	}
 
-	stmt, err := utils.PrepareStmt(utils.Db(), "SELECT * FROM argon WHERE account_id = ?")
-	if err != nil {
-		return false, err
-	} else {
-		log.Debug("Prepared argon database statement for account of ID %v", user.Account)
+	if dbUser, err := h.argon.Get(ctx, user.Account); err != nil {
+		log.Ctx(ctx).Error(err.Error())
+	} else if time.Since(dbUser.ValidAt) < 24*time.Hour && dbUser.Account == user.Account && dbUser.Token == user.Token {
+		return true, nil
 	}
-	defer stmt.Close()
-
-	dbUser := new(utils.ArgonUser)
-	if row := stmt.QueryRow(user.Account); row != nil {
-		if err := row.Scan(&dbUser.Account, &dbUser.Token, &dbUser.ReportBanned, &dbUser.ValidAt); err != nil {
-			log.Error(err.Error())
-		} else if time.Since(dbUser.ValidAt) < 24*time.Hour && dbUser.Account == user.Account && dbUser.Token == user.Token {
-			return true, nil
-		}
+
+	start := time.Now()
+	valid, err := h.checkArgon(ctx, user)
+	argonDuration.Since(start)
+
+	switch {
+	case errors.Is(err, ErrArgonInvalid):
+		argonFailures.Inc("rejected")
+	case err != nil:
+		argonFailures.Inc("error")
 	}
 
-	u, err := url.Parse("https://argon.globed.dev/v1/validation/check")
+	return valid, err
+}
+
+// checkArgon asks the Argon server whether the token belongs to the account
+func (h *Handler) checkArgon(ctx context.Context, user *utils.ArgonUser) (bool, error) {
+	u, err := url.Parse(h.cfg.Endpoints.Argon + "/v1/validation/check")
 	if err != nil {
 		return false, err
 	} else {
-		log.Debug("Argon URL parsed for account of ID %v", user.Account)
+		log.Ctx(ctx).Debug("Argon URL parsed for account of ID %v", user.Account)
 	}
 
 	q := u.Query()
@@ -107,26 +98,24 @@ func ValidateArgonUser(user *utils.ArgonUser) (bool, error) {
 	q.Set("authtoken", user.Token)
 	u.RawQuery = q.Encode()
 
-	log.Debug("Argon validation parameters: account_id=%d (type check: %T), authtoken length=%d", user.Account, user.Account, len(user.Token))
-	log.Debug("Full Argon URL being requested: %s", u.String())
+	log.Ctx(ctx).Debug("Argon validation parameters: account_id=%d (type check: %T), authtoken length=%d", user.Account, user.Account, len(user.Token))
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return false, err
 	} else {
-		log.Debug("Argon request object constructed for account of ID %v", user.Account)
+		log.Ctx(ctx).Debug("Argon request object constructed for account of ID %v", user.Account)
 	}
 
 	req.Header.Set("User-Agent", "PlayerAdvertisements/1.0")
 
-	argon, err := getToken()
-	if err != nil {
-		log.Warn("Failed to get Argon API token: %s", err.Error())
+	if argon := h.cfg.Argon.Token; argon == "" {
+		log.Ctx(ctx).Warn("Argon API token is not configured")
 	} else {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", argon))
 	}
 
-	log.Debug("Sending request to Argon server: %s", u.String())
+	log.Ctx(ctx).Debug("Sending request to Argon server at %s", u.Host)
 	client := &http.Client{Timeout: 15 * time.Second}
 
 	resp, reqErr := client.Do(req)
@@ -135,13 +124,13 @@ func ValidateArgonUser(user *utils.ArgonUser) (bool, error) {
 	}
 	defer resp.Body.Close()
 
-	log.Debug("Argon status code received: %d, for account of ID %v", resp.StatusCode, user.Account)
+	log.Ctx(ctx).Debug("Argon status code received: %d, for account of ID %v", resp.StatusCode, user.Account)
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return false, err
 	}
-	log.Debug("Argon response body: %s", string(bodyBytes))
+	log.Ctx(ctx).Debug("Argon response body: %s", string(bodyBytes))
 
 	if resp.StatusCode != http.StatusOK {
 		return false, fmt.Errorf("argon server returned status code %d: %s", resp.StatusCode, string(bodyBytes))
@@ -151,16 +140,16 @@ func ValidateArgonUser(user *utils.ArgonUser) (bool, error) {
 	if err := json.Unmarshal(bodyBytes, &valid); err != nil {
 		return false, fmt.Errorf("failed to parse argon response: %v, body: %s", err, string(bodyBytes))
 	} else {
-		log.Debug("Argon status of account of ID %v retrieved", user.Account)
+		log.Ctx(ctx).Debug("Argon status of account of ID %v retrieved", user.Account)
 	}
 
 	if valid.Valid {
-		log.Info("Argon status of account of ID %v is valid", user.Account)
-		UpsertArgonUser(user)
+		log.Ctx(ctx).Info("Argon status of account of ID %v is valid", user.Account)
+		h.UpsertArgonUser(ctx, user)
 
 		return true, nil
 	}
 
-	invalids.Set(fmt.Sprintf("%d", user.Account), user.Token, cache.DefaultExpiration)
-	return false, fmt.Errorf("cause: %s", valid.Cause)
+	h.invalids.Set(fmt.Sprintf("%d", user.Account), user.Token, cache.DefaultExpiration)
+	return false, fmt.Errorf("%w: cause: %s", ErrArgonInvalid, valid.Cause)
 }
@@ -0,0 +1,107 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"service/config"
+	"service/log"
+	"service/utils"
+)
+
+// Prefix of the CDN paths of pending ad images
+const pendingImages = "/cdn/" + utils.PendingFolder + "/"
+
+// imageKey is CDN_SIGNING_KEY, or else derived from the client secret so
+// that links outlive a restart and work on every instance
+func imageKey(cfg *config.Config) []byte {
+	if cfg.CDN.SigningKey != "" {
+		return []byte(cfg.CDN.SigningKey)
+	}
+
+	key := sha256.Sum256([]byte("cdn images\n" + cfg.Discord.ClientSecret))
+	return key[:]
+}
+
+// imageSignature authenticates the path of an image until expires
+func (h *Handler) imageSignature(path string, expires string) string {
+	mac := hmac.New(sha256.New, h.imageKey)
+	mac.Write([]byte(path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// SignImage lets anyone holding the URL of a pending ad image fetch it until
+// CDN_SIGNED_FOR passes. Other URLs are public already and left alone.
+func (h *Handler) SignImage(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil || !strings.HasPrefix(u.Path, pendingImages) {
+		return imageURL
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(h.cfg.CDN.SignedFor.Duration).Unix(), 10)
+
+	query := u.Query()
+	query.Set("expires", expires)
+	query.Set("signature", h.imageSignature(u.Path, expires))
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// SignedImages copies the ads with the images of the pending ones signed
+func (h *Handler) SignedImages(ads []*utils.Ad) []*utils.Ad {
+	signed := make([]*utils.Ad, 0, len(ads))
+	for _, ad := range ads {
+		a := *ad
+		a.ImageURL = h.SignImage(a.ImageURL)
+		signed = append(signed, &a)
+	}
+
+	return signed
+}
+
+// CanViewImage tells whether the request may fetch the image at name below
+// /cdn/. Pending images need a signed URL, or the session of their owner or
+// of staff.
+func (h *Handler) CanViewImage(r *http.Request, name string) bool {
+	rest, pending := strings.CutPrefix(name, utils.PendingFolder+"/")
+	if !pending {
+		return true
+	}
+
+	query := r.URL.Query()
+	if expires := query.Get("expires"); expires != "" {
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		signature := h.imageSignature("/cdn/"+name, expires)
+		if err == nil && time.Now().Unix() < unix && hmac.Equal([]byte(query.Get("signature")), []byte(signature)) {
+			return true
+		}
+
+		log.Ctx(r.Context()).Warn("Rejected an expired or forged link to %s", name)
+	}
+
+	uid, err := h.GetSessionUserID(r)
+	if err != nil || uid == "" {
+		return false
+	}
+
+	// images are named <user>-<id>.webp
+	_, file, _ := strings.Cut(rest, "/")
+	if strings.HasPrefix(file, uid+"-") {
+		return true
+	}
+
+	u, err := h.users.Get(r.Context(), uid)
+	if err != nil {
+		log.Ctx(r.Context()).Error("Failed to get user: %s", err.Error())
+		return false
+	}
+
+	return u.IsAdmin || u.IsStaff
+}
@@ -1,21 +1,61 @@
 package access
 
 import (
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
+	"service/config"
 	"service/database"
 	"service/log"
+	"service/router"
+	"service/utils"
+
+	"github.com/patrickmn/go-cache"
 )
 
+// Login sessions, Argon validation and user administration
+type Handler struct {
+	cfg      *config.Config // Startup settings, none of them reloadable
+	store    *config.Store
+	users    database.UserRepository
+	ads      database.AdRepository
+	sessions database.SessionRepository
+	argon    database.ArgonRepository
+	origins  *router.Origins // Trusted origins of cookie-authenticated requests
+	imageKey []byte          // Signs links to pending ad images
+
+	sessionCache *cache.Cache
+	argonCache   *cache.Cache
+	invalids     *cache.Cache
+}
+
+func New(store *config.Store, repos *database.Repositories, origins *router.Origins) *Handler {
+	return &Handler{
+		cfg:          store.Current(),
+		store:        store,
+		origins:      origins,
+		users:        repos.Users,
+		ads:          repos.Ads,
+		sessions:     repos.Sessions,
+		argon:        repos.Argon,
+		imageKey:     imageKey(store.Current()),
+		sessionCache: cache.New(2*time.Hour, 10*time.Minute),
+		argonCache:   cache.New(15*time.Minute, 10*time.Minute),
+		invalids:     cache.New(5*time.Minute, 10*time.Minute),
+	}
+}
+
+func (h *Handler) Register(rt *router.Router) {
+	h.registerAuth(rt)
+	h.registerUsers(rt.With(router.ReadOnly(utils.Degraded)))
+	h.registerAdmin(rt)
+}
+
 func HashString(b []byte) (string, string) {
 	raw := base64.RawURLEncoding.EncodeToString(b)
-	h := sha256.Sum256([]byte(raw))
-	return raw, base64.RawURLEncoding.EncodeToString(h[:])
+	return raw, utils.HashSessionID(raw)
 }
 
 func GetDomain(r *http.Request) string {
@@ -32,360 +72,218 @@ func FullURL(r *http.Request) string {
 	return fmt.Sprintf("%s%s", base, r.RequestURI)
 }
 
-func init() {
-	http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
+// A user looked up by an administrator or the mod
+type userDetails struct {
+	User *utils.User `json:"user"`
+	Ads  []*utils.Ad `json:"ads"`
+}
 
-			if !u.IsAdmin {
-				log.Error("User of ID %s is not admin", u.ID)
-				http.Error(w, "User is not admin", http.StatusUnauthorized)
-				return
-			}
+func (h *Handler) registerUsers(rt *router.Router) {
+	rt.With(router.JSON, h.RequireAdmin).Doc(router.Operation{
+		Summary:  "List every user",
+		Auth:     router.Admin,
+		Response: []*utils.User{},
+	}).Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		users, err := h.users.List(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get all users: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get all users")
+			return
+		}
 
-			users, err := database.GetAllUsers()
-			if err != nil {
-				log.Error("Failed to get all users: %s", err.Error())
-				http.Error(w, "Failed to get all users", http.StatusInternalServerError)
-				return
-			}
+		router.WriteJSON(w, http.StatusOK, users)
+	})
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(users); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
+	rt.With(router.JSON, h.RequireAdmin).Doc(router.Operation{
+		Summary: "Ban a user",
+		Auth:    router.Admin,
+		Query: []router.Param{
+			{Name: "id", Type: "string", Required: true, Description: "Discord user ID"},
+		},
+		Response: utils.User{},
+	}).Post("/ban", func(w http.ResponseWriter, r *http.Request) {
+		u := User(r)
+
+		query := r.URL.Query()
+		idStr := query.Get("id")
+
+		banned, err := h.users.Ban(r.Context(), idStr)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to ban user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to ban user")
+			return
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Info("Banned user %s (%s)", banned.Username, banned.ID)
 		}
-	})
-
-	http.HandleFunc("/ban", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
 
-			// require login
-			uid, err := GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			} else {
-				log.Info("Received user of ID %s", uid)
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			} else {
-				log.Info("Fetched user %s (%s)", u.Username, u.ID)
-			}
-
-			if !u.IsAdmin {
-				log.Error("User of ID %s is not admin", u.ID)
-				http.Error(w, "User is not admin", http.StatusUnauthorized)
-				return
-			} else {
-				log.Info("User %s (%s) is an admin", u.Username, u.ID)
-			}
-
-			query := r.URL.Query()
-			idStr := query.Get("id")
-
-			banned, err := database.BanUser(idStr)
-			if err != nil {
-				log.Error("Failed to ban user: %s", err.Error())
-				http.Error(w, "Failed to ban user", http.StatusInternalServerError)
-				return
-			} else {
-				log.Info("Banned user %s (%s)", banned.Username, banned.ID)
-			}
+		router.WriteJSON(w, http.StatusOK, banned)
+		log.Ctx(r.Context()).Info("Finished ban request to %s by admin %s (%s)", banned.Username, u.Username, u.ID)
+	})
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(banned); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			} else {
-				log.Info("Finished ban request to %s by admin %s (%s)", banned.Username, u.Username, u.ID)
-			}
+	rt.With(router.JSON, h.RequireAdmin).Doc(router.Operation{
+		Summary: "Lift the ban of a user",
+		Auth:    router.Admin,
+		Query: []router.Param{
+			{Name: "id", Type: "string", Required: true, Description: "Discord user ID"},
+		},
+		Response: utils.User{},
+	}).Post("/unban", func(w http.ResponseWriter, r *http.Request) {
+		u := User(r)
+
+		query := r.URL.Query()
+		idStr := query.Get("id")
+
+		unbanned, err := h.users.Unban(r.Context(), idStr)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to unban user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to unban user")
+			return
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Info("Unbanned user %s (%s)", unbanned.Username, unbanned.ID)
 		}
-	})
 
-	http.HandleFunc("/unban", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+		router.WriteJSON(w, http.StatusOK, unbanned)
+		log.Ctx(r.Context()).Info("Finished unban request to %s by admin %s (%s)", unbanned.Username, u.Username, u.ID)
+	})
 
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
+	rt.With(router.JSON, h.RequireAdmin).Doc(router.Operation{
+		Summary: "Look up a user and their ads",
+		Auth:    router.Admin,
+		Path: []router.Param{
+			{Name: "query", Type: "string", Required: true, Description: "Discord user ID or username"},
+		},
+		Response: userDetails{},
+	}).Get("/users/{query}", func(w http.ResponseWriter, r *http.Request) {
+		u := User(r)
+
+		// User ID or username from the URL path
+		searchQuery := r.PathValue("query")
+		if searchQuery == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing user ID or username", router.Details{"parameter": "query"})
+			return
+		}
 
-			// require login
-			uid, err := GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			} else {
-				log.Info("Received user of ID %s", uid)
-			}
+		log.Ctx(r.Context()).Debug("Searching for user: %s", searchQuery)
 
-			u, err := database.GetUser(uid)
+		// Try to get user by ID first
+		targetUser, err := h.users.Get(r.Context(), searchQuery)
+		if err != nil {
+			// If not found by ID, try to find by username
+			allUsers, err := h.users.List(r.Context())
 			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to get all users: %s", err.Error())
+				router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "User not found", router.Details{"resource": "user"})
 				return
-			} else {
-				log.Info("Fetched user %s (%s)", u.Username, u.ID)
 			}
 
-			if !u.IsAdmin {
-				log.Error("User of ID %s is not admin", u.ID)
-				http.Error(w, "User is not admin", http.StatusUnauthorized)
-				return
-			} else {
-				log.Info("User %s (%s) is an admin", u.Username, u.ID)
-			}
-
-			query := r.URL.Query()
-			idStr := query.Get("id")
-
-			unbanned, err := database.UnbanUser(idStr)
-			if err != nil {
-				log.Error("Failed to unban user: %s", err.Error())
-				http.Error(w, "Failed to unban user", http.StatusInternalServerError)
-				return
-			} else {
-				log.Info("Unbanned user %s (%s)", unbanned.Username, unbanned.ID)
+			found := false
+			for _, user := range allUsers {
+				if user.Username == searchQuery {
+					targetUser = user
+					found = true
+					break
+				}
 			}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(unbanned); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			if !found {
+				log.Ctx(r.Context()).Error("User not found by ID or username: %s", searchQuery)
+				router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "User not found", router.Details{"resource": "user"})
 				return
-			} else {
-				log.Info("Finished unban request to %s by admin %s (%s)", unbanned.Username, u.Username, u.ID)
 			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
-	})
-
-	http.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
 
-			// require login and admin status
-			uid, err := GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
+		// Get all ads and filter by user
+		allAds, err := h.ads.List(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list ads")
+			return
+		}
 
-			if !u.IsAdmin {
-				log.Error("User of ID %s is not admin", u.ID)
-				http.Error(w, "User is not admin", http.StatusUnauthorized)
-				return
-			}
+		userAds, err := database.FilterAdsByUser(allAds, targetUser.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to filter ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to filter ads")
+			return
+		}
 
-			// Extract user ID or username from URL path
-			searchQuery := strings.TrimPrefix(r.URL.Path, "/users/")
-			if searchQuery == "" {
-				http.Error(w, "Missing user ID or username", http.StatusBadRequest)
-				return
-			}
+		response := userDetails{User: targetUser, Ads: userAds}
 
-			log.Debug("Searching for user: %s", searchQuery)
+		router.WriteJSON(w, http.StatusOK, response)
 
-			// Try to get user by ID first
-			targetUser, err := database.GetUser(searchQuery)
-			if err != nil {
-				// If not found by ID, try to find by username
-				allUsers, err := database.GetAllUsers()
-				if err != nil {
-					log.Error("Failed to get all users: %s", err.Error())
-					http.Error(w, "User not found", http.StatusNotFound)
-					return
-				}
+		log.Ctx(r.Context()).Info("Admin %s fetched user %s info", u.Username, targetUser.ID)
+	})
 
-				found := false
-				for _, user := range allUsers {
-					if user.Username == searchQuery {
-						targetUser = user
-						found = true
-						break
-					}
-				}
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary: "Look up a user and their ads from the mod",
+		Query: []router.Param{
+			{Name: "id", Type: "string", Required: true, Description: "Discord user ID or username"},
+		},
+		Response: userDetails{},
+	}).Get("/users/fetch", func(w http.ResponseWriter, r *http.Request) {
+		// Check User-Agent header
+		userAgent := r.Header.Get("User-Agent")
+		if userAgent != "PlayerAdvertisements/1.0" {
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Unauthorized")
+			return
+		}
 
-				if !found {
-					log.Error("User not found by ID or username: %s", searchQuery)
-					http.Error(w, "User not found", http.StatusNotFound)
-					return
-				}
-			}
+		// Get user ID from query parameter
+		query := r.URL.Query()
+		searchQuery := query.Get("id")
+		if searchQuery == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing user ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			// Get all ads and filter by user
-			allAds, err := database.ListAllAdvertisements()
-			if err != nil {
-				log.Error("Failed to list ads: %s", err.Error())
-				http.Error(w, "Failed to list ads", http.StatusInternalServerError)
-				return
-			}
+		log.Ctx(r.Context()).Debug("Fetching user: %s", searchQuery)
 
-			userAds, err := database.FilterAdsByUser(allAds, targetUser.ID)
+		// Try to get user by ID first
+		targetUser, err := h.users.Get(r.Context(), searchQuery)
+		if err != nil {
+			// If not found by ID, try to find by username
+			allUsers, err := h.users.List(r.Context())
 			if err != nil {
-				log.Error("Failed to filter ads: %s", err.Error())
-				http.Error(w, "Failed to filter ads", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to get all users: %s", err.Error())
+				router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "User not found", router.Details{"resource": "user"})
 				return
 			}
 
-			response := map[string]interface{}{
-				"user": targetUser,
-				"ads":  userAds,
+			found := false
+			for _, user := range allUsers {
+				if user.Username == searchQuery {
+					targetUser = user
+					found = true
+					break
+				}
 			}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			if !found {
+				log.Ctx(r.Context()).Error("User not found by ID or username: %s", searchQuery)
+				router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "User not found", router.Details{"resource": "user"})
 				return
 			}
-
-			log.Info("Admin %s fetched user %s info", u.Username, targetUser.ID)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
-	})
 
-	http.HandleFunc("/users/fetch", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type, User-Agent")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// Check User-Agent header
-			userAgent := r.Header.Get("User-Agent")
-			if userAgent != "PlayerAdvertisements/1.0" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			// Get user ID from query parameter
-			query := r.URL.Query()
-			searchQuery := query.Get("id")
-			if searchQuery == "" {
-				http.Error(w, "Missing user ID parameter", http.StatusBadRequest)
-				return
-			}
-
-			log.Debug("Fetching user: %s", searchQuery)
-
-			// Try to get user by ID first
-			targetUser, err := database.GetUser(searchQuery)
-			if err != nil {
-				// If not found by ID, try to find by username
-				allUsers, err := database.GetAllUsers()
-				if err != nil {
-					log.Error("Failed to get all users: %s", err.Error())
-					http.Error(w, "User not found", http.StatusNotFound)
-					return
-				}
-
-				found := false
-				for _, user := range allUsers {
-					if user.Username == searchQuery {
-						targetUser = user
-						found = true
-						break
-					}
-				}
-
-				if !found {
-					log.Error("User not found by ID or username: %s", searchQuery)
-					http.Error(w, "User not found", http.StatusNotFound)
-					return
-				}
-			}
-
-			// Get all ads and filter by user
-			allAds, err := database.ListAllAdvertisements()
-			if err != nil {
-				log.Error("Failed to list ads: %s", err.Error())
-				http.Error(w, "Failed to list ads", http.StatusInternalServerError)
-				return
-			}
+		// Get all ads and filter by user
+		allAds, err := h.ads.List(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list ads")
+			return
+		}
 
-			userAds, err := database.FilterAdsByUser(allAds, targetUser.ID)
-			if err != nil {
-				log.Error("Failed to filter ads: %s", err.Error())
-				http.Error(w, "Failed to filter ads", http.StatusInternalServerError)
-				return
-			}
+		userAds, err := database.FilterAdsByUser(allAds, targetUser.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to filter ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to filter ads")
+			return
+		}
 
-			response := map[string]interface{}{
-				"user": targetUser,
-				"ads":  userAds,
-			}
+		response := userDetails{User: targetUser, Ads: userAds}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
+		router.WriteJSON(w, http.StatusOK, response)
 
-			log.Info("Fetched user %s info via /users/fetch", targetUser.ID)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
+		log.Ctx(r.Context()).Info("Fetched user %s info via /users/fetch", targetUser.ID)
 	})
 }
@@ -0,0 +1,236 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"service/config"
+	"service/database/memory"
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+// Registered routes of a handler backed by an in-memory store
+type testServer struct {
+	*routertest.Server
+	h   *Handler
+	mem *memory.Store
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Discord.ClientID = "client"
+	cfg.Discord.ClientSecret = "secret"
+	cfg.Discord.RedirectURI = "http://localhost/callback"
+
+	mem := memory.New(cfg.Limits)
+	store := config.NewStore("", cfg)
+
+	h := New(store, mem.Repositories(), router.NewOrigins())
+	mux := http.NewServeMux()
+	h.Register(router.New(mux))
+
+	return &testServer{Server: routertest.NewServer(t, mux), h: h, mem: mem}
+}
+
+func TestSession(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.mem.AddUser(utils.User{ID: "10", Username: "player"})
+	cookie := ts.mem.Login(user)
+
+	t.Run("without a cookie", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/session", nil, nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+
+	t.Run("with a forged cookie", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/session", &http.Cookie{Name: "session_id", Value: "forged"}, nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+
+	t.Run("with a session", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/session", cookie, nil)
+		if got := routertest.Decode[DiscordUser](t, resp, body); got.ID != user.ID {
+			t.Errorf("got session of %q, expected %q", got.ID, user.ID)
+		}
+	})
+
+	t.Run("account", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/account/me", cookie, nil)
+		if got := routertest.Decode[utils.User](t, resp, body); got.Username != user.Username {
+			t.Errorf("got account %q, expected %q", got.Username, user.Username)
+		}
+	})
+
+	t.Run("logout ends it", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/logout", cookie, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+		}
+
+		resp, body = ts.Do(t, http.MethodGet, "/account/me", cookie, nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+}
+
+func TestBan(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "1", Username: "admin", IsAdmin: true}))
+	staff := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "2", Username: "staff", IsStaff: true}))
+	player := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "3", Username: "player"}))
+
+	t.Run("admins only", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ban?id=3", staff, nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeNotAdmin)
+
+		resp, body = ts.Do(t, http.MethodPost, "/ban?id=3", nil, nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ban?id=3", admin, nil)
+		if got := routertest.Decode[utils.User](t, resp, body); !got.Banned {
+			t.Errorf("got %+v, expected the user banned", got)
+		}
+
+		resp, body = ts.Do(t, http.MethodGet, "/account/me", player, nil)
+		routertest.ExpectError(t, resp, body, http.StatusForbidden, router.CodeBanned)
+	})
+
+	t.Run("unban", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/unban?id=3", admin, nil)
+		if got := routertest.Decode[utils.User](t, resp, body); got.Banned {
+			t.Errorf("got %+v, expected the ban lifted", got)
+		}
+
+		resp, body = ts.Do(t, http.MethodGet, "/account/me", player, nil)
+		routertest.Decode[utils.User](t, resp, body)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ban?id=404", admin, nil)
+		routertest.ExpectError(t, resp, body, http.StatusInternalServerError, router.CodeInternal)
+	})
+}
+
+func TestUserLookup(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "1", Username: "admin", IsAdmin: true}))
+	player := ts.mem.AddUser(utils.User{ID: "3", Username: "player"})
+	ts.mem.AddAd(utils.Ad{UserID: player.ID, Type: 1})
+	ts.mem.AddAd(utils.Ad{UserID: player.ID, Type: 2, Pending: true})
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 1})
+
+	mod := http.Header{"User-Agent": {"PlayerAdvertisements/1.0"}}
+
+	tests := []struct {
+		name   string
+		path   string
+		cookie *http.Cookie
+		header http.Header
+		status int
+		code   router.Code
+	}{
+		{name: "by id", path: "/users/3", cookie: admin, status: http.StatusOK},
+		{name: "by username", path: "/users/player", cookie: admin, status: http.StatusOK},
+		{name: "unknown", path: "/users/nobody", cookie: admin, status: http.StatusNotFound, code: router.CodeNotFound},
+		{name: "without a session", path: "/users/3", status: http.StatusUnauthorized, code: router.CodeUnauthorized},
+		{name: "from the mod", path: "/users/fetch?id=player", header: mod, status: http.StatusOK},
+		{name: "from the mod without an id", path: "/users/fetch", header: mod, status: http.StatusBadRequest, code: router.CodeMissingParameter},
+		{name: "from elsewhere", path: "/users/fetch?id=player", status: http.StatusUnauthorized, code: router.CodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.Do(t, http.MethodGet, tt.path, tt.cookie, tt.header)
+			if tt.status != http.StatusOK {
+				routertest.ExpectError(t, resp, body, tt.status, tt.code)
+				return
+			}
+
+			got := routertest.Decode[userDetails](t, resp, body)
+			if got.User == nil || got.User.ID != player.ID || len(got.Ads) != 2 {
+				t.Errorf("got %s, expected the player and their 2 ads", body)
+			}
+		})
+	}
+
+	t.Run("listing is for admins", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/users", admin, nil)
+		if got := routertest.Decode[[]*utils.User](t, resp, body); len(got) != 2 {
+			t.Errorf("got %d users, expected 2", len(got))
+		}
+
+		resp, body = ts.Do(t, http.MethodGet, "/users", ts.mem.Login(player), nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeNotAdmin)
+	})
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+
+	t.Run("starts a PKCE login", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/login?redirect=/admin", nil, nil)
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("got %d %s, expected 302", resp.StatusCode, body)
+		}
+
+		u, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query := u.Query()
+		if u.Path != "/oauth2/authorize" || query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" || query.Get("state") == "" {
+			t.Errorf("redirected to %s", u)
+		}
+
+		var cookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == loginCookie {
+				cookie = c
+			}
+		}
+
+		if cookie == nil || !cookie.HttpOnly {
+			t.Fatalf("login started without an HttpOnly %s cookie", loginCookie)
+		}
+
+		// the callback gets the verifier of the challenge and the asked target back
+		req := httptest.NewRequest(http.MethodGet, "/callback", nil)
+		req.AddCookie(cookie)
+
+		verifier, target, err := ts.h.finishLogin(httptest.NewRecorder(), req, query.Get("state"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if codeChallenge(verifier) != query.Get("code_challenge") || target != "/admin" {
+			t.Errorf("state carried verifier %q and target %q", verifier, target)
+		}
+	})
+
+	t.Run("refuses foreign targets", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/login?redirect="+url.QueryEscape("https://evil.example/"), nil, nil)
+		routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeInvalidParameter)
+	})
+
+	t.Run("skips Discord with a session", func(t *testing.T) {
+		cookie := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "10", Username: "player"}))
+
+		resp, body := ts.Do(t, http.MethodGet, "/login", cookie, nil)
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/dashboard" {
+			t.Errorf("got %d to %q %s, expected 302 to /dashboard", resp.StatusCode, resp.Header.Get("Location"), body)
+		}
+	})
+
+	t.Run("callback without a login", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodGet, "/callback?code=abc&state=forged", nil, nil)
+		routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeInvalidState)
+	})
+}
@@ -0,0 +1,70 @@
+package access
+
+import (
+	"context"
+	"net/http"
+
+	"service/log"
+	"service/router"
+	"service/utils"
+)
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// RequireUser rejects requests without a valid session and hands the logged-in
+// account to the handler through User. As the session lives in a cookie,
+// state-changing requests must also come from a trusted origin.
+func (h *Handler) RequireUser(next http.Handler) http.Handler {
+	return h.origins.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := h.GetSessionUserID(r)
+		if err != nil || uid == "" {
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		u, err := h.users.Get(r.Context(), uid)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get user")
+			return
+		}
+
+		log.Annotate(r.Context(), "user", u.ID)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+	}))
+}
+
+// RequireAdmin only lets administrators through
+func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
+	return h.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := User(r); !u.IsAdmin {
+			log.Ctx(r.Context()).Error("User of ID %s is not admin", u.ID)
+			router.Error(w, r, http.StatusUnauthorized, router.CodeNotAdmin, "User is not admin")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireStaff only lets staff and administrators through
+func (h *Handler) RequireStaff(next http.Handler) http.Handler {
+	return h.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := User(r); !u.IsAdmin && !u.IsStaff {
+			log.Ctx(r.Context()).Error("User of ID %s is not admin or staff", u.ID)
+			router.Error(w, r, http.StatusUnauthorized, router.CodeNotStaff, "User is not admin or staff")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// User is the account loaded by RequireUser, RequireAdmin or RequireStaff
+func User(r *http.Request) *utils.User {
+	u, _ := r.Context().Value(userKey).(*utils.User)
+	return u
+}
@@ -0,0 +1,174 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Time a login may take between /login and /callback
+const loginLifetime = 10 * time.Minute
+
+// Where logins end up when they ask for nowhere in particular
+const defaultRedirect = "/dashboard"
+
+// Pre-auth cookie binding the state of a login to the browser that started it
+const loginCookie = "oauth_login"
+
+// What the state of a login carries through Discord, signed so that it comes
+// back unchanged
+type loginState struct {
+	Nonce    string `json:"n"` // Also held by the pre-auth cookie
+	Redirect string `json:"r"` // Validated target after the login
+	Expires  int64  `json:"e"` // Unix time
+}
+
+// A login in progress
+type login struct {
+	state    string // Sent to Discord and back to /callback
+	verifier string // PKCE code verifier, kept in the pre-auth cookie
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// stateKey is derived from the client secret, which every instance shares
+func (h *Handler) stateKey() []byte {
+	key := sha256.Sum256([]byte("oauth state\n" + h.cfg.Discord.ClientSecret))
+	return key[:]
+}
+
+func (h *Handler) signState(payload string) string {
+	mac := hmac.New(sha256.New, h.stateKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// startLogin sets the pre-auth cookie of a login that ends at redirect
+func (h *Handler) startLogin(w http.ResponseWriter, r *http.Request, redirect string) (*login, error) {
+	nonce, verifier := randomString(16), randomString(32)
+
+	b, err := json.Marshal(loginState{Nonce: nonce, Redirect: redirect, Expires: time.Now().Add(loginLifetime).Unix()})
+	if err != nil {
+		return nil, err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	// Lax, as Discord sends the browser back with a cross-site navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    nonce + "." + verifier,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(loginLifetime.Seconds()),
+	})
+
+	return &login{state: payload + "." + h.signState(payload), verifier: verifier}, nil
+}
+
+// finishLogin checks that the state came from a login this browser started,
+// returning the PKCE verifier and the redirect target. The pre-auth cookie is
+// cleared either way, a state is good for one attempt.
+func (h *Handler) finishLogin(w http.ResponseWriter, r *http.Request, state string) (string, string, error) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	c, err := r.Cookie(loginCookie)
+	if err != nil {
+		return "", "", fmt.Errorf("no login was started from this browser")
+	}
+
+	nonce, verifier, _ := strings.Cut(c.Value, ".")
+
+	payload, signature, _ := strings.Cut(state, ".")
+	if !hmac.Equal([]byte(signature), []byte(h.signState(payload))) {
+		return "", "", fmt.Errorf("state signature mismatch")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", err
+	}
+
+	var s loginState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return "", "", err
+	}
+
+	if time.Now().Unix() > s.Expires {
+		return "", "", fmt.Errorf("login started over %s ago", loginLifetime)
+	}
+
+	if !hmac.Equal([]byte(s.Nonce), []byte(nonce)) || verifier == "" {
+		return "", "", fmt.Errorf("state belongs to another browser")
+	}
+
+	return verifier, s.Redirect, nil
+}
+
+// codeChallenge is the S256 PKCE challenge of a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// loginRedirect validates where a login asks to end up, see
+// config.Discord.LoginRedirects. Empty targets go to the dashboard.
+func (h *Handler) loginRedirect(target string) (string, error) {
+	if target == "" {
+		return defaultRedirect, nil
+	}
+
+	// browsers read backslashes as slashes, turning /\evil.com into //evil.com
+	if strings.Contains(target, `\`) {
+		return "", fmt.Errorf("invalid redirect %q", target)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Opaque != "" || u.User != nil || (u.Host == "") != (u.Scheme == "") {
+		return "", fmt.Errorf("invalid redirect %q", target)
+	}
+
+	for _, allowed := range h.cfg.Discord.LoginRedirects {
+		a, err := url.Parse(allowed)
+		if err != nil || a.Scheme != u.Scheme || a.Host != u.Host {
+			continue
+		}
+
+		if within(u.Path, a.Path) {
+			return u.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("redirect %q is not allowed", target)
+}
+
+// within tells whether p is base or lies below it
+func within(p string, base string) bool {
+	if p == "" || !strings.HasPrefix(p, "/") {
+		return false
+	}
+
+	p, base = path.Clean(p), path.Clean("/"+base)
+	return p == base || strings.HasPrefix(p, strings.TrimSuffix(base, "/")+"/")
+}
@@ -0,0 +1,84 @@
+package access
+
+import (
+	"testing"
+
+	"service/config"
+)
+
+func TestLoginRedirect(t *testing.T) {
+	h := &Handler{cfg: &config.Config{Discord: config.Discord{
+		LoginRedirects: []string{"/dashboard", "/admin", "https://app.example.com/welcome"},
+	}}}
+
+	tests := []struct {
+		target string
+		want   string // Empty when the target is refused
+	}{
+		{"", "/dashboard"},
+		{"/dashboard", "/dashboard"},
+		{"/dashboard/ads?tab=pending", "/dashboard/ads?tab=pending"},
+		{"/admin", "/admin"},
+		{"https://app.example.com/welcome", "https://app.example.com/welcome"},
+		{"https://app.example.com/welcome/back#top", "https://app.example.com/welcome/back#top"},
+		{"/", ""},
+		{"/dashboardx", ""},
+		{"/dashboard/../etc", ""},
+		{"/admin/%2e%2e/etc", ""},
+		{"dashboard", ""},
+		{"//evil.example", ""},
+		{`/\evil.example`, ""},
+		{"https://evil.example/dashboard", ""},
+		{"http://app.example.com/welcome", ""},
+		{"https://app.example.com/welcomex", ""},
+		{"https://user@app.example.com/welcome", ""},
+		{"javascript:alert(1)", ""},
+		{"https:/dashboard", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := h.loginRedirect(tt.target)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("loginRedirect(%q) = %q, expected it refused", tt.target, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("loginRedirect(%q) failed: %v", tt.target, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("loginRedirect(%q) = %q, expected %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		p, base string
+		want    bool
+	}{
+		{"/a", "/a", true},
+		{"/a/", "/a", true},
+		{"/a/b", "/a", true},
+		{"/a/b", "/a/", true},
+		{"/anything", "", true},
+		{"/anything", "/", true},
+		{"/ab", "/a", false},
+		{"/a/../b", "/a", false},
+		{"/b", "/a", false},
+		{"a/b", "/a", false},
+		{"", "/a", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := within(tt.p, tt.base); got != tt.want {
+			t.Errorf("within(%q, %q) = %v, expected %v", tt.p, tt.base, got, tt.want)
+		}
+	}
+}
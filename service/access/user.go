@@ -1,40 +1,32 @@
 package access
 
 import (
+	"cmp"
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
+
 	"strings"
 	"time"
 
-	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 
 	"github.com/patrickmn/go-cache"
 )
 
-type DiscordUser struct {
-	ID            string `json:"id"`
-	Username      string `json:"username"`
-	Discriminator string `json:"discriminator"`
-	Avatar        string `json:"avatar"`
-}
+type DiscordUser = utils.DiscordUser
 
 type Token struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
 }
 
-var sessionCache = cache.New(2*time.Hour, 10*time.Minute)
-
 func generateSessionID() (string, string, error) {
 	b := make([]byte, 64)
 	if _, err := rand.Read(b); err != nil {
@@ -45,25 +37,22 @@ func generateSessionID() (string, string, error) {
 	return raw, hash, nil
 }
 
-func hashSessionID(raw string) string {
-	h := sha256.Sum256([]byte(raw))
-	return base64.RawURLEncoding.EncodeToString(h[:])
-}
-
-func isSecure(r *http.Request) bool {
-	if r.TLS != nil || os.Getenv("ENV") == "production" {
+func (h *Handler) isSecure(r *http.Request) bool {
+	if r.TLS != nil || h.cfg.Production() {
 		return true
 	}
 
 	return false
 }
 
-func SetSession(w http.ResponseWriter, user DiscordUser, secure bool) (string, error) {
+func (h *Handler) SetSession(ctx context.Context, w http.ResponseWriter, user DiscordUser, secure bool) (string, error) {
 	sessionId, sessionIdHash, err := generateSessionID()
 	if err != nil {
 		return "", err
 	}
 
+	log.Redact(ctx, sessionId)
+
 	session := &http.Cookie{
 		Name:     "session_id",
 		Value:    sessionId,
@@ -79,65 +68,40 @@ func SetSession(w http.ResponseWriter, user DiscordUser, secure bool) (string, e
 		session.SameSite = http.SameSiteLaxMode
 	}
 
-	stmt, err := utils.PrepareStmt(utils.Db(), "INSERT INTO sessions (session_id, user_id, username, discriminator, avatar) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), username = VALUES(username), discriminator = VALUES(discriminator), avatar = VALUES(avatar);")
+	err = h.sessions.Save(ctx, sessionIdHash, &user)
 	if err != nil {
 		return "", err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(sessionIdHash, user.ID, user.Username, user.Discriminator, user.Avatar)
-	if err != nil {
-		return "", err
-	}
-
-	log.Debug("Setting session cookie...")
+	log.Ctx(ctx).Debug("Setting session cookie...")
 	http.SetCookie(w, session)
 
-	sessionCache.Set(sessionIdHash, user, cache.DefaultExpiration)
+	h.sessionCache.Set(sessionIdHash, user, cache.DefaultExpiration)
 
 	return sessionIdHash, nil
 }
 
-func GetSessionFromId(id string) (*DiscordUser, error) {
-	sessionId := hashSessionID(id)
+func (h *Handler) GetSessionFromId(ctx context.Context, id string) (*DiscordUser, error) {
+	log.Redact(ctx, id)
+	sessionId := utils.HashSessionID(id)
 
-	var user DiscordUser
-	if val, found := sessionCache.Get(sessionId); found {
-		user = val.(DiscordUser)
-	}
-
-	stmt, err := utils.PrepareStmt(utils.Db(), "SELECT user_id, username, discriminator, avatar FROM sessions WHERE session_id = ?")
+	user, err := h.sessions.Get(ctx, sessionId)
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(sessionId).Scan(&user.ID, &user.Username, &user.Discriminator, &user.Avatar)
-	if err != nil {
-		return nil, err
-	}
-
-	updStmt, err := utils.PrepareStmt(utils.Db(), "UPDATE sessions SET last_seen = CURRENT_TIMESTAMP WHERE session_id = ?")
-	if err != nil {
-		return nil, err
-	}
-	defer updStmt.Close()
+	h.sessionCache.Set(sessionId, *user, cache.DefaultExpiration)
 
-	_, err = updStmt.Exec(sessionId)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	return user, nil
 }
 
-func GetSessionUserID(r *http.Request) (string, error) {
+func (h *Handler) GetSessionUserID(r *http.Request) (string, error) {
 	c, err := r.Cookie("session_id")
 	if err != nil {
 		return "", err
 	}
 
-	u, err := GetSessionFromId(c.Value)
+	u, err := h.GetSessionFromId(r.Context(), c.Value)
 	if err != nil || u == nil {
 		if err == nil {
 			err = fmt.Errorf("no user in session")
@@ -149,13 +113,13 @@ func GetSessionUserID(r *http.Request) (string, error) {
 	return u.ID, nil
 }
 
-func GetSession(r *http.Request) (*DiscordUser, error) {
+func (h *Handler) GetSession(r *http.Request) (*DiscordUser, error) {
 	c, err := r.Cookie("session_id")
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := GetSessionFromId(c.Value)
+	user, err := h.GetSessionFromId(r.Context(), c.Value)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +127,7 @@ func GetSession(r *http.Request) (*DiscordUser, error) {
 	return user, nil
 }
 
-func getAvatarURL(userID, avatarHash string) string {
+func (h *Handler) avatarURL(userID, avatarHash string) string {
 	if avatarHash == "" {
 		var avId int
 		if len(userID) > 0 {
@@ -173,251 +137,264 @@ func getAvatarURL(userID, avatarHash string) string {
 			avId = 0
 		}
 
-		return fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.png", avId)
+		return fmt.Sprintf("%s/embed/avatars/%d.png", h.cfg.Endpoints.DiscordCDN, avId)
 	}
 
-	return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.webp", userID, avatarHash)
+	return fmt.Sprintf("%s/avatars/%s/%s.webp", h.cfg.Endpoints.DiscordCDN, userID, avatarHash)
 }
 
-func CleanupExpiredSessions() error {
-	stmt, err := utils.PrepareStmt(utils.Db(), "DELETE FROM sessions WHERE last_seen < NOW() - INTERVAL 30 DAY")
+func (h *Handler) CleanupExpiredSessions(ctx context.Context) error {
+	rowsAffected, err := h.sessions.DeleteExpired(ctx, 30*24*time.Hour)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.Exec()
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, _ := res.RowsAffected()
-	log.Info("Expired sessions cleaned: %d", rowsAffected)
+	log.Ctx(ctx).Info("Expired sessions cleaned: %d", rowsAffected)
 
 	return nil
 }
 
-var sessionCancel context.CancelFunc
-
-func StopSessionCleanup() {
-	if sessionCancel != nil {
-		sessionCancel()
-	}
-}
-
-func init() {
+func (h *Handler) registerAuth(rt *router.Router) {
 	log.Info("Starting authorization handlers...")
 
-	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
+	rt.Doc(router.Operation{
+		Summary: "Redirect to the Discord login, or to the redirect target with a session",
+		Query: []router.Param{
+			{Name: "redirect", Type: "string", Required: false, Description: "Where to go once logged in, one of DISCORD_LOGIN_REDIRECTS, /dashboard by default"},
+		},
+		Status: http.StatusFound,
+	}).Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		target, err := h.loginRedirect(r.URL.Query().Get("redirect"))
+		if err != nil {
+			log.Ctx(r.Context()).Warn("Refused login: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Redirect target not allowed", router.Details{"parameter": "redirect"})
+			return
+		}
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			redirectURL := "https://discord.com/oauth2/authorize?client_id=" + os.Getenv("DISCORD_CLIENT_ID") +
-				"&redirect_uri=" + os.Getenv("DISCORD_REDIRECT_URI") +
-				"&response_type=code" +
-				"&scope=identify"
-
-			user, err := GetSessionUserID(r)
-			if err != nil {
-				log.Error(err.Error())
-				http.Redirect(w, r, redirectURL, http.StatusFound)
-			} else if user != "" {
-				log.Info("Redirecting from login to dashboard")
-				http.Redirect(w, r, "/dashboard", http.StatusFound)
-			} else {
-				log.Debug("panic time ig")
-				http.Redirect(w, r, redirectURL, http.StatusFound)
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if user, err := h.GetSessionUserID(r); err == nil && user != "" {
+			log.Ctx(r.Context()).Info("Redirecting from login to %s", target)
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+
+		login, err := h.startLogin(w, r, target)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to start login: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to start login")
+			return
 		}
+
+		query := url.Values{}
+		query.Set("client_id", h.cfg.Discord.ClientID)
+		query.Set("redirect_uri", h.cfg.Discord.RedirectURI)
+		query.Set("response_type", "code")
+		query.Set("scope", "identify")
+		query.Set("state", login.state)
+		query.Set("code_challenge", codeChallenge(login.verifier))
+		query.Set("code_challenge_method", "S256")
+
+		http.Redirect(w, r, h.cfg.Endpoints.Discord+"/oauth2/authorize?"+query.Encode(), http.StatusFound)
 	})
 
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
+	rt.Doc(router.Operation{
+		Summary: "Finish the Discord login and start a session",
+		Query: []router.Param{
+			{Name: "code", Type: "string", Required: true, Description: "OAuth authorization code"},
+			{Name: "state", Type: "string", Required: true, Description: "State of the login started at /login"},
+		},
+		Status: http.StatusFound,
+	}).Get("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// Discord sends the user back with an error when they cancel
+		if reason := query.Get("error"); reason != "" {
+			log.Ctx(r.Context()).Info("Discord login not completed: %s", reason)
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+		code := query.Get("code")
+		if code == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing code", router.Details{"parameter": "code"})
+			return
+		}
 
-		if r.Method == http.MethodGet {
-			code := r.URL.Query().Get("code")
-			if code == "" {
-				http.Error(w, "Missing code", http.StatusBadRequest)
-				return
-			}
+		verifier, target, err := h.finishLogin(w, r, query.Get("state"))
+		if err != nil {
+			log.Ctx(r.Context()).Warn("Rejected login callback: %s", err.Error())
+			router.Error(w, r, http.StatusBadRequest, router.CodeInvalidState, "Login expired or was not started here, try again")
+			return
+		}
 
-			log.Info("Received Discord auth code %s", code)
+		log.Redact(r.Context(), code)
+		log.Ctx(r.Context()).Info("Received Discord auth code")
 
-			data := url.Values{}
-			data.Set("client_id", os.Getenv("DISCORD_CLIENT_ID"))
-			data.Set("client_secret", os.Getenv("DISCORD_CLIENT_SECRET"))
-			data.Set("grant_type", "authorization_code")
-			data.Set("code", code)
-			data.Set("redirect_uri", os.Getenv("DISCORD_REDIRECT_URI"))
+		data := url.Values{}
+		data.Set("client_id", h.cfg.Discord.ClientID)
+		data.Set("client_secret", h.cfg.Discord.ClientSecret)
+		data.Set("grant_type", "authorization_code")
+		data.Set("code", code)
+		data.Set("redirect_uri", h.cfg.Discord.RedirectURI)
+		data.Set("code_verifier", verifier)
 
-			encoded := data.Encode()
+		encoded := data.Encode()
 
-			req, _ := http.NewRequest(http.MethodPost, "https://discord.com/api/oauth2/token", strings.NewReader(encoded))
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, h.cfg.Endpoints.Discord+"/api/oauth2/token", strings.NewReader(encoded))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-			log.Debug("Sending data request to Discord...")
+		log.Ctx(r.Context()).Debug("Sending data request to Discord...")
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Error(err.Error())
-				http.Error(w, "Token exchange failed", http.StatusInternalServerError)
-				return
-			}
-			defer resp.Body.Close()
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Token exchange failed")
+			return
+		}
+		defer resp.Body.Close()
 
-			tokenResp := Token{}
+		tokenResp := Token{}
 
-			tokenBody, _ := io.ReadAll(resp.Body)
-			log.Debug("Token endpoint status: %s", resp.Status)
+		tokenBody, _ := io.ReadAll(resp.Body)
+		log.Ctx(r.Context()).Debug("Token endpoint status: %s", resp.Status)
 
-			if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
-				log.Error("Discord returned non-JSON: %s", string(tokenBody))
-				http.Error(w, "Discord returned unexpected response", http.StatusInternalServerError)
-				return
-			}
+		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+			log.Ctx(r.Context()).Error("Discord returned non-JSON: %s", string(tokenBody))
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Discord returned unexpected response")
+			return
+		}
 
-			if resp.Request != nil {
-				log.Debug("Token endpoint final URL: %s", resp.Request.URL.String())
-			}
+		if resp.Request != nil {
+			log.Ctx(r.Context()).Debug("Token endpoint final URL: %s", resp.Request.URL.String())
+		}
 
-			if err := json.Unmarshal(tokenBody, &tokenResp); err != nil {
-				log.Error("Failed to decode token response: %s", err.Error())
-				http.Error(w, "Token decode failed", http.StatusInternalServerError)
-				return
-			}
+		if err := json.Unmarshal(tokenBody, &tokenResp); err != nil {
+			log.Ctx(r.Context()).Error("Failed to decode token response: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Token decode failed")
+			return
+		}
 
-			if tokenResp.AccessToken == "" {
-				log.Error("No access token returned from Discord")
-				http.Error(w, "No access token", http.StatusInternalServerError)
-				return
-			}
+		log.Redact(r.Context(), tokenResp.AccessToken)
 
-			// Fetch user info from Discord
-			req, _ = http.NewRequest(http.MethodGet, "https://discord.com/api/users/@me", nil)
-			req.Header.Set("Authorization", tokenResp.TokenType+" "+tokenResp.AccessToken)
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-			log.Debug("Getting user info...")
-			resp, err = client.Do(req)
-			if err != nil {
-				log.Error(err.Error())
-				http.Error(w, "Failed to fetch user info", http.StatusInternalServerError)
-				return
-			}
+		if tokenResp.AccessToken == "" {
+			log.Ctx(r.Context()).Error("No access token returned from Discord")
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "No access token")
+			return
+		}
 
-			defer resp.Body.Close()
+		// Fetch user info from Discord
+		req, _ = http.NewRequestWithContext(r.Context(), http.MethodGet, h.cfg.Endpoints.Discord+"/api/users/@me", nil)
+		req.Header.Set("Authorization", tokenResp.TokenType+" "+tokenResp.AccessToken)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		log.Ctx(r.Context()).Debug("Getting user info...")
+		resp, err = client.Do(req)
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Failed to fetch user info")
+			return
+		}
 
-			user := DiscordUser{}
+		defer resp.Body.Close()
 
-			userBody, _ := io.ReadAll(resp.Body)
-			log.Debug("User endpoint status: %s", resp.Status)
-			log.Debug("User endpoint body: %s", string(userBody))
-			if err := json.Unmarshal(userBody, &user); err != nil {
-				log.Error("Failed to decode user info: %s", err.Error())
-				http.Error(w, "Failed to decode user info", http.StatusInternalServerError)
-				return
-			}
+		user := DiscordUser{}
 
-			if user.ID == "" {
-				log.Error("Discord returned empty user id")
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+		userBody, _ := io.ReadAll(resp.Body)
+		log.Ctx(r.Context()).Debug("User endpoint status: %s", resp.Status)
+		log.Ctx(r.Context()).Debug("User endpoint body: %s", string(userBody))
+		if err := json.Unmarshal(userBody, &user); err != nil {
+			log.Ctx(r.Context()).Error("Failed to decode user info: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Failed to decode user info")
+			return
+		}
 
-			u, err := database.GetUser(user.ID)
-			if err != nil {
-				log.Error(err.Error())
-			} else if u.Banned {
-				log.Warn("User %s is banned", u.Username)
-				http.Error(w, "User is banned", http.StatusForbidden)
-				return
-			}
+		if user.ID == "" {
+			log.Ctx(r.Context()).Error("Discord returned empty user id")
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Unauthorized")
+			return
+		}
 
-			if err := database.UpsertUser(user.ID, user.Username, getAvatarURL(user.ID, user.Avatar)); err != nil {
-				log.Error("Failed to upsert user: %s", err.Error())
-				http.Error(w, "Failed to upsert user", http.StatusInternalServerError)
-				return
-			}
+		u, err := h.users.Get(r.Context(), user.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+		} else if u.Banned {
+			log.Ctx(r.Context()).Warn("User %s is banned", u.Username)
+			router.Error(w, r, http.StatusForbidden, router.CodeBanned, "User is banned")
+			return
+		}
 
-			log.Debug("Setting session...")
-			sessionId, err := SetSession(w, user, isSecure(r))
-			if err != nil {
-				log.Error("Failed to set the user's session: %s", err.Error())
-				http.Error(w, "Failed to set the user's session", http.StatusInternalServerError)
-				return
-			}
+		if err := h.users.Upsert(r.Context(), user.ID, user.Username, h.avatarURL(user.ID, user.Avatar)); err != nil {
+			log.Ctx(r.Context()).Error("Failed to upsert user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to upsert user")
+			return
+		}
 
-			if jb, err := json.Marshal(user); err != nil {
-				log.Debug("Creating session: id=%s (failed to marshal user)", sessionId)
-			} else {
-				log.Debug("Creating session: id=%s user=%s", sessionId, string(jb))
-			}
+		log.Ctx(r.Context()).Debug("Setting session...")
+		sessionId, err := h.SetSession(r.Context(), w, user, h.isSecure(r))
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to set the user's session: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to set the user's session")
+			return
+		}
 
-			log.Info("Redirecting to dashboard")
-			http.Redirect(w, r, "/dashboard", http.StatusFound)
+		if jb, err := json.Marshal(user); err != nil {
+			log.Ctx(r.Context()).Debug("Creating session: id=%s (failed to marshal user)", sessionId)
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Debug("Creating session: id=%s user=%s", sessionId, string(jb))
 		}
-	})
 
-	http.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			if c, err := r.Cookie("session_id"); err == nil {
-				log.Debug("/session request cookie: %s", c.Value)
-			} else {
-				log.Debug("/session request no cookie: %s", err.Error())
-			}
+		log.Ctx(r.Context()).Info("Redirecting to %s", target)
+		http.Redirect(w, r, target, http.StatusFound)
+	})
 
-			user, err := GetSession(r)
-			if err != nil {
-				log.Error(err.Error())
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+	rt.Doc(router.Operation{
+		Summary:  "Discord account of the current session",
+		Auth:     router.Session,
+		Response: DiscordUser{},
+	}).Get("/session", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session_id"); err == nil {
+			log.Ctx(r.Context()).Debug("/session request with cookie")
+		} else {
+			log.Ctx(r.Context()).Debug("/session request no cookie: %s", err.Error())
+		}
 
-			header := w.Header()
+		user, err := h.GetSession(r)
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Unauthorized")
+			return
+		}
 
-			header.Set("Content-Type", "application/json")
-			if jb, err := json.Marshal(user); err == nil {
-				log.Debug("/session returning user: %s", string(jb))
-			} else {
-				log.Debug("/session returning user: (failed to marshal)")
-			}
+		header := w.Header()
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(user); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
+		header.Set("Content-Type", "application/json")
+		if jb, err := json.Marshal(user); err == nil {
+			log.Ctx(r.Context()).Debug("/session returning user: %s", string(jb))
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Debug("/session returning user: (failed to marshal)")
 		}
+
+		router.WriteJSON(w, http.StatusOK, user)
 	})
 
-	http.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+	rt.With(h.origins.Protect).Doc(router.Operation{
+		Summary: "End the current session",
+		Text:    "Logged out successfully",
+	}).Post("/logout", func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("session_id")
 		if err == nil {
-			sessionCache.Delete(hashSessionID(cookie.Value))
-			log.Info("User %s logged out", cookie.Value)
-		}
+			uid, _ := h.GetSessionUserID(r)
+			sessionId := utils.HashSessionID(cookie.Value)
+			h.sessionCache.Delete(sessionId)
+			if err := h.sessions.Delete(r.Context(), sessionId); err != nil {
+				log.Ctx(r.Context()).Error("Failed to delete session: %s", err.Error())
+			}
 
-		secure := false
-		if r.TLS != nil || os.Getenv("ENV") == "production" {
-			secure = true
+			log.Ctx(r.Context()).Info("User %s logged out", cmp.Or(uid, "(unknown)"))
 		}
 
+		secure := h.isSecure(r)
+
 		clearCookie := &http.Cookie{
 			Name:     "session_id",
 			Value:    "",
@@ -434,63 +411,19 @@ func init() {
 		fmt.Fprint(w, "Logged out successfully")
 	})
 
-	http.HandleFunc("/account/me", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
-
-			if u.Banned {
-				log.Warn("User %s is banned", u.Username)
-				http.Error(w, "User is banned", http.StatusForbidden)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(u); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	rt.With(router.JSON, h.RequireUser).Doc(router.Operation{
+		Summary:  "Account of the current session",
+		Auth:     router.Session,
+		Response: utils.User{},
+	}).Get("/account/me", func(w http.ResponseWriter, r *http.Request) {
+		u := User(r)
+
+		if u.Banned {
+			log.Ctx(r.Context()).Warn("User %s is banned", u.Username)
+			router.Error(w, r, http.StatusForbidden, router.CodeBanned, "User is banned")
+			return
 		}
-	})
 
-	ctx, cancel := context.WithCancel(context.Background())
-	sessionCancel = cancel
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Info("Session sweeper stopped.")
-				return
-			default:
-				if err := CleanupExpiredSessions(); err != nil {
-					log.Error("Failed to clean up sessions: %s", err.Error())
-				}
-
-				time.Sleep(3 * time.Hour)
-			}
-		}
-	}()
+		router.WriteJSON(w, http.StatusOK, u)
+	})
 }
@@ -6,83 +6,73 @@ import (
 	"strconv"
 
 	"service/access"
-	"service/database"
-	"service/discord"
 	"service/log"
+	"service/router"
 )
 
-func init() {
-	http.HandleFunc("/ads/boost", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			query := r.URL.Query()
-
-			idStr := query.Get("id")
-			if idStr == "" {
-				http.Error(w, "Missing ad ID parameter", http.StatusBadRequest)
-				return
-			}
-
-			boostsStr := query.Get("boosts")
-			if boostsStr == "" {
-				http.Error(w, "Missing boosts parameter", http.StatusBadRequest)
-				return
-			}
+func (h *Handler) registerBoost(rt *router.Router) {
+	rt.With(h.auth.RequireUser).Doc(router.Operation{
+		Summary: "Spend boosts on an ad",
+		Auth:    router.Session,
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Advertisement ID"},
+			{Name: "boosts", Type: "integer", Required: true, Description: "Boosts to spend"},
+		},
+		Text: "Successfully boosted ad",
+	}).Post("/ads/boost", func(w http.ResponseWriter, r *http.Request) {
+		u := access.User(r)
+
+		query := r.URL.Query()
+
+		idStr := query.Get("id")
+		if idStr == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				http.Error(w, "Invalid ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		boostsStr := query.Get("boosts")
+		if boostsStr == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing boosts parameter", router.Details{"parameter": "boosts"})
+			return
+		}
 
-			boosts, err := strconv.ParseUint(boostsStr, 10, 32)
-			if err != nil {
-				http.Error(w, "Invalid boosts parameter", http.StatusBadRequest)
-				return
-			}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			user, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
+		boosts, err := strconv.ParseUint(boostsStr, 10, 32)
+		if err != nil {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid boosts parameter", router.Details{"parameter": "boosts"})
+			return
+		}
 
-			if int(user.BoostCount) < int(boosts) {
-				http.Error(w, "Insufficient boosts", http.StatusBadRequest)
-				return
-			}
+		user, err := h.users.Get(r.Context(), u.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get user")
+			return
+		}
 
-			ad, err := database.BoostAd(id, uint(boosts), user.ID)
-			if err != nil {
-				log.Error("Failed to boost advertisement: %s", err.Error())
-				http.Error(w, "Failed to boost advertisement", http.StatusInternalServerError)
-				return
-			}
+		if int(user.BoostCount) < int(boosts) {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInsufficientBoosts, "Insufficient boosts", router.Details{"available": user.BoostCount})
+			return
+		}
 
-			err = discord.WebhookBoost(ad)
-			if err != nil {
-				log.Warn(err.Error())
-			}
+		ad, err := h.ads.Boost(r.Context(), id, uint(boosts), user.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to boost advertisement: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to boost advertisement")
+			return
+		}
 
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "Successfully boosted ad")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		err = h.webhooks.Boost(r.Context(), ad)
+		if err != nil {
+			log.Ctx(r.Context()).Warn(err.Error())
 		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Successfully boosted ad")
 	})
 }
@@ -6,98 +6,85 @@ import (
 	"strconv"
 
 	"service/access"
-	"service/database"
-	"service/discord"
 	"service/log"
+	"service/router"
 )
 
-func init() {
-	http.HandleFunc("/ads/delete", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Attempting to delete ad(s)...")
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "DELETE")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodDelete {
-			header.Set("Content-Type", "application/json")
-
-			uid, err := access.GetSessionUserID(r)
-			if err != nil {
-				log.Error("Unauthorized access to /ads/delete: %s", err.Error())
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			query := r.URL.Query()
+func (h *Handler) registerDelete(rt *router.Router) {
+	rt.With(h.auth.RequireUser).Doc(router.Operation{
+		Summary: "Delete an ad",
+		Auth:    router.Session,
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Advertisement ID"},
+			{Name: "reject", Type: "boolean", Required: false, Description: "Staff only, tells the owner their pending ad was rejected"},
+		},
+		Text: "Advertisement deleted successfully",
+	}).HandleFunc(http.MethodDelete, "/ads/delete", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Attempting to delete ad(s)...")
+
+		user := access.User(r)
+
+		query := r.URL.Query()
+
+		idStr := query.Get("id")
+		if idStr == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			idStr := query.Get("id")
-			if idStr == "" {
-				http.Error(w, "Missing ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				http.Error(w, "Invalid ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		permission := false // does the user meet the criteria
 
-			permission := false // does the user meet the criteria
+		ownerid, err := h.ads.OwnerID(r.Context(), id)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get advertisement owner: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get advertisement owner")
+			return
+		}
 
-			ownerid, err := database.GetAdvertisementOwnerId(id)
-			if err != nil {
-				log.Error("Failed to get advertisement owner: %s", err.Error())
-				http.Error(w, "Failed to get advertisement owner", http.StatusInternalServerError)
-				return
-			}
+		if user.IsAdmin || user.IsStaff || ownerid == user.ID {
+			permission = true
+		}
 
-			user, err := database.GetUser(uid)
+		if permission {
+			ad, err := h.ads.Delete(r.Context(), id)
 			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user:", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to delete advertisement: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to delete advertisement")
 				return
 			}
 
-			if user.IsAdmin || user.IsStaff || ownerid == user.ID {
-				permission = true
-			}
-
-			if permission {
-				ad, err := database.DeleteAdvertisement(id)
-				if err != nil {
-					log.Error("Failed to delete advertisement: %s", err.Error())
-					http.Error(w, "Failed to delete advertisement", http.StatusInternalServerError)
-					return
-				}
-
-				if user.IsAdmin || user.IsStaff {
-					rejectStr := query.Get("reject")
-					if ad.Pending && rejectStr != "" {
-						reject, err := strconv.ParseBool(rejectStr)
+			if user.IsAdmin || user.IsStaff {
+				rejectStr := query.Get("reject")
+				if ad.Pending && rejectStr != "" {
+					reject, err := strconv.ParseBool(rejectStr)
+					if err != nil {
+						log.Ctx(r.Context()).Error("Invalid boolean value for reject: %s", err.Error())
+					} else if reject {
+						signed := *ad
+						signed.ImageURL = h.auth.SignImage(ad.ImageURL)
+
+						err = h.webhooks.StaffReject(r.Context(), &signed, user)
 						if err != nil {
-							log.Error("Invalid boolean value for reject: %s", err.Error())
-						} else if reject {
-							err = discord.WebhookStaffReject(ad, user)
-							if err != nil {
-								log.Warn(err.Error())
-							}
+							log.Ctx(r.Context()).Warn(err.Error())
 						}
 					}
 				}
+			}
 
-				log.Info("Deleted advertisement of ID %d", ad.AdID)
+			log.Ctx(r.Context()).Info("Deleted advertisement of ID %d", ad.AdID)
 
-				w.WriteHeader(http.StatusOK)
-				fmt.Fprint(w, "Advertisement deleted successfully")
-			} else {
-				log.Error("Unauthorized deletion attempt for ad ID %d by user %s", id, uid)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "Advertisement deleted successfully")
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Error("Unauthorized deletion attempt for ad ID %d by user %s", id, user.ID)
+			router.Error(w, r, http.StatusUnauthorized, router.CodeNotOwner, "Not the owner of the advertisement")
+			return
 		}
 	})
 }
@@ -1,55 +1,38 @@
 package ads
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"service/access"
 	"service/database"
 	"service/log"
+	"service/router"
+	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/ads/get", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			// Default behavior: get user's own ads
-			rows, err := database.ListAllAdvertisements()
-			if err != nil {
-				log.Error("Failed to list ads: %s", err.Error())
-				http.Error(w, "Failed to list ads", http.StatusInternalServerError)
-				return
-			}
-
-			filtered, err := database.FilterAdsByUser(rows, uid)
-			if err != nil {
-				log.Error("List ads failed: %s", err.Error())
-				http.Error(w, "Failed to fetch ads", http.StatusInternalServerError)
-				return
-			}
+func (h *Handler) registerGet(rt *router.Router) {
+	rt.With(router.JSON, h.auth.RequireUser).Doc(router.Operation{
+		Summary:  "Ads of the current session",
+		Auth:     router.Session,
+		Response: []*utils.Ad{},
+	}).Get("/ads/get", func(w http.ResponseWriter, r *http.Request) {
+		u := access.User(r)
+
+		// Default behavior: get user's own ads
+		rows, err := h.ads.List(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list ads")
+			return
+		}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(filtered); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		filtered, err := database.FilterAdsByUser(rows, u.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("List ads failed: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to fetch ads")
+			return
 		}
+
+		router.WriteJSON(w, http.StatusOK, filtered)
 	})
 }
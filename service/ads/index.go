@@ -3,21 +3,58 @@ package ads
 import (
 	"fmt"
 	"net/http"
+	"sync/atomic"
 
+	"service/access"
+	"service/config"
+	"service/database"
+	"service/discord"
 	"service/log"
+	"service/router"
 )
 
-func init() {
-	http.HandleFunc("/ads", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Ads management API service pinged")
+// Dashboard endpoints for managing advertisements
+type Handler struct {
+	cfg      atomic.Pointer[config.Config] // Latest reloadable settings
+	ads      database.AdRepository
+	users    database.UserRepository
+	reports  database.ReportRepository
+	auth     *access.Handler
+	webhooks *discord.Webhooks
+}
+
+func New(store *config.Store, repos *database.Repositories, auth *access.Handler, webhooks *discord.Webhooks) *Handler {
+	h := &Handler{
+		ads:      repos.Ads,
+		users:    repos.Users,
+		reports:  repos.Reports,
+		auth:     auth,
+		webhooks: webhooks,
+	}
+
+	store.Subscribe(func(cfg *config.Config) { h.cfg.Store(cfg) })
+	return h
+}
+
+func (h *Handler) Register(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Check the ads service",
+		Text:    "pong!",
+	}).Get("/ads", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Ads management API service pinged")
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
 		header.Set("Content-Type", "text/plain")
 
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "pong!")
 	})
+
+	h.registerBoost(rt)
+	h.registerDelete(rt)
+	h.registerGet(rt)
+	h.registerLeaderboard(rt)
+	h.registerPending(rt)
+	h.registerReports(rt)
+	h.registerSubmit(rt)
 }
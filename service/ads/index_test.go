@@ -0,0 +1,192 @@
+package ads
+
+import (
+	"net/http"
+	"testing"
+
+	"service/access"
+	"service/config"
+	"service/database"
+	"service/database/memory"
+	"service/discord"
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+// Ads routes backed by an in-memory store, without Discord webhooks
+type testServer struct {
+	*routertest.Server
+	mem   *memory.Store
+	repos *database.Repositories
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	cfg := config.Default()
+	mem := memory.New(cfg.Limits)
+	store := config.NewStore("", cfg)
+	repos := mem.Repositories()
+
+	mux := http.NewServeMux()
+	New(store, repos, access.New(store, repos, router.NewOrigins()), discord.New(store, repos.Users)).Register(router.New(mux))
+
+	return &testServer{Server: routertest.NewServer(t, mux), mem: mem, repos: repos}
+}
+
+func TestOwnAds(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.AddUser(utils.User{ID: "1", Username: "owner"})
+	ts.mem.AddUser(utils.User{ID: "2", Username: "other"})
+
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 1})
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 2, Pending: true})
+	ts.mem.AddAd(utils.Ad{UserID: "2", Type: 1})
+
+	t.Run("needs a session", func(t *testing.T) {
+		resp, body := ts.Get(t, "/ads/get", nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+
+	t.Run("of the session", func(t *testing.T) {
+		resp, body := ts.Get(t, "/ads/get", ts.mem.Login(owner))
+		got := routertest.Decode[[]*utils.Ad](t, resp, body)
+		if len(got) != 2 {
+			t.Fatalf("got %d ads, expected 2", len(got))
+		}
+
+		for _, a := range got {
+			if a.UserID != owner.ID {
+				t.Errorf("got ad %d of %s", a.AdID, a.UserID)
+			}
+		}
+	})
+}
+
+func TestPending(t *testing.T) {
+	ts := newTestServer(t)
+	staff := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "1", Username: "staff", IsStaff: true}))
+	player := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "2", Username: "player"}))
+
+	ts.mem.AddAd(utils.Ad{UserID: "2", Type: 1})
+	pending := ts.mem.AddAd(utils.Ad{UserID: "2", Type: 2, Pending: true, ImageURL: "/cdn/pending/square/2-2.webp"})
+
+	t.Run("staff only", func(t *testing.T) {
+		resp, body := ts.Get(t, "/ads/pending", player)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeNotStaff)
+	})
+
+	t.Run("lists with signed images", func(t *testing.T) {
+		resp, body := ts.Get(t, "/ads/pending", staff)
+		got := routertest.Decode[[]*utils.Ad](t, resp, body)
+		if len(got) != 1 || got[0].AdID != pending.AdID {
+			t.Fatalf("got %s, expected only ad %d", body, pending.AdID)
+		}
+
+		if got[0].ImageURL == pending.ImageURL {
+			t.Errorf("image URL %q left unsigned", got[0].ImageURL)
+		}
+	})
+
+	t.Run("filters by owner", func(t *testing.T) {
+		resp, body := ts.Get(t, "/ads/pending?user=1", staff)
+		if got := routertest.Decode[[]*utils.Ad](t, resp, body); len(got) != 0 {
+			t.Errorf("got %d ads of a user without any", len(got))
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ads/pending/accept?id=first", staff, nil)
+		routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeInvalidParameter)
+
+		resp, body = ts.Do(t, http.MethodPost, "/ads/pending/accept?id=2", staff, nil)
+		if got := routertest.Decode[utils.Ad](t, resp, body); got.Pending {
+			t.Errorf("got %+v, expected it approved", got)
+		}
+
+		resp, body = ts.Get(t, "/ads/pending", staff)
+		if got := routertest.Decode[[]*utils.Ad](t, resp, body); len(got) != 0 {
+			t.Errorf("got %d pending ads after approving the last", len(got))
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "1", Username: "owner"}))
+	other := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "2", Username: "other"}))
+	staff := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "3", Username: "staff", IsStaff: true}))
+
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 1})
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 2})
+
+	tests := []struct {
+		name   string
+		path   string
+		cookie *http.Cookie
+		status int
+		code   router.Code
+	}{
+		{name: "without an id", path: "/ads/delete", cookie: owner, status: http.StatusBadRequest, code: router.CodeMissingParameter},
+		{name: "malformed id", path: "/ads/delete?id=first", cookie: owner, status: http.StatusBadRequest, code: router.CodeInvalidParameter},
+		{name: "of someone else", path: "/ads/delete?id=1", cookie: other, status: http.StatusUnauthorized, code: router.CodeNotOwner},
+		{name: "by the owner", path: "/ads/delete?id=1", cookie: owner, status: http.StatusOK},
+		{name: "by staff", path: "/ads/delete?id=2", cookie: staff, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.Do(t, http.MethodDelete, tt.path, tt.cookie, nil)
+			if tt.status != http.StatusOK {
+				routertest.ExpectError(t, resp, body, tt.status, tt.code)
+				return
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+			}
+		})
+	}
+
+	ads, err := ts.repos.Ads.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ads) != 0 {
+		t.Errorf("got %d ads left, expected both deleted", len(ads))
+	}
+}
+
+func TestBoost(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.Login(ts.mem.AddUser(utils.User{ID: "1", Username: "owner", BoostCount: 3}))
+	ts.mem.AddAd(utils.Ad{UserID: "1", Type: 1})
+
+	t.Run("more than available", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ads/boost?id=1&boosts=4", owner, nil)
+		routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeInsufficientBoosts)
+	})
+
+	t.Run("spends the boosts", func(t *testing.T) {
+		resp, body := ts.Do(t, http.MethodPost, "/ads/boost?id=1&boosts=2", owner, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+		}
+
+		ad, err := ts.repos.Ads.Get(t.Context(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := ts.repos.Users.Get(t.Context(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ad.BoostCount != 2 || user.BoostCount != 1 {
+			t.Errorf("ad holds %d boosts and the owner %d, expected 2 and 1", ad.BoostCount, user.BoostCount)
+		}
+	})
+}
@@ -1,119 +1,98 @@
 package ads
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 
-	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/ads/leaderboard", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Ads leaderboard API service pinged")
+func (h *Handler) registerLeaderboard(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Check the leaderboard service",
+		Text:    "pong!",
+	}).Get("/ads/leaderboard", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Ads leaderboard API service pinged")
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
 		header.Set("Content-Type", "text/plain")
 
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "pong!")
 	})
 
-	http.HandleFunc("/ads/leaderboard/views", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			query := r.URL.Query()
-			pageStr := query.Get("page")
-			maxStr := query.Get("max")
-
-			page, err := strconv.ParseUint(pageStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get starting position: %s", err.Error())
-				http.Error(w, "Failed to get starting position", http.StatusBadRequest)
-				return
-			}
-
-			max, err := strconv.ParseUint(maxStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get ending position: %s", err.Error())
-				http.Error(w, "Failed to get ending position", http.StatusBadRequest)
-				return
-			}
-
-			users, err := database.UserLeaderboard(utils.StatByViews, page, max)
-			if err != nil {
-				log.Error("Failed to get views leaderboard: %s", err.Error())
-				http.Error(w, "Failed to get views leaderboard", http.StatusInternalServerError)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(users); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary: "Users ranked by views",
+		Query: []router.Param{
+			{Name: "page", Type: "integer", Required: true, Description: "Page, starting at 0"},
+			{Name: "max", Type: "integer", Required: true, Description: "Users per page"},
+		},
+		Response: []*utils.User{},
+	}).Get("/ads/leaderboard/views", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pageStr := query.Get("page")
+		maxStr := query.Get("max")
+
+		page, err := strconv.ParseUint(pageStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get starting position: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Failed to get starting position", router.Details{"parameter": "page"})
+			return
 		}
-	})
 
-	http.HandleFunc("/ads/leaderboard/clicks", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
+		max, err := strconv.ParseUint(maxStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ending position: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Failed to get ending position", router.Details{"parameter": "max"})
+			return
+		}
 
-			query := r.URL.Query()
-			pageStr := query.Get("page")
-			maxStr := query.Get("max")
+		users, err := h.users.Leaderboard(r.Context(), utils.StatByViews, page, max)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get views leaderboard: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get views leaderboard")
+			return
+		}
 
-			page, err := strconv.ParseUint(pageStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get starting position: %s", err.Error())
-				http.Error(w, "Failed to get starting position", http.StatusBadRequest)
-				return
-			}
+		router.WriteJSON(w, http.StatusOK, users)
+	})
 
-			max, err := strconv.ParseUint(maxStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get ending position: %s", err.Error())
-				http.Error(w, "Failed to get ending position", http.StatusBadRequest)
-				return
-			}
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary: "Users ranked by clicks",
+		Query: []router.Param{
+			{Name: "page", Type: "integer", Required: true, Description: "Page, starting at 0"},
+			{Name: "max", Type: "integer", Required: true, Description: "Users per page"},
+		},
+		Response: []*utils.User{},
+	}).Get("/ads/leaderboard/clicks", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pageStr := query.Get("page")
+		maxStr := query.Get("max")
+
+		page, err := strconv.ParseUint(pageStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get starting position: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Failed to get starting position", router.Details{"parameter": "page"})
+			return
+		}
 
-			users, err := database.UserLeaderboard(utils.StatByClicks, page, max)
-			if err != nil {
-				log.Error("Failed to get clicks leaderboard: %s", err.Error())
-				http.Error(w, "Failed to get clicks leaderboard", http.StatusInternalServerError)
-				return
-			}
+		max, err := strconv.ParseUint(maxStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ending position: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Failed to get ending position", router.Details{"parameter": "max"})
+			return
+		}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(users); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		users, err := h.users.Leaderboard(r.Context(), utils.StatByClicks, page, max)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get clicks leaderboard: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get clicks leaderboard")
+			return
 		}
+
+		router.WriteJSON(w, http.StatusOK, users)
 	})
 }
@@ -1,140 +1,83 @@
 package ads
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"service/access"
 	"service/database"
-	"service/discord"
 	"service/log"
+	"service/router"
+	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/ads/pending", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
-
-			if !u.IsAdmin && !u.IsStaff {
-				log.Error("User of ID %s is not admin or staff", u.ID)
-				http.Error(w, "User is not admin or staff", http.StatusUnauthorized)
-				return
-			}
-
-			// Get pending ads directly from database with WHERE pending != 0
-			adList, err := database.ListPendingAdvertisements()
-			if err != nil {
-				log.Error("Failed to list pending ads: %s", err.Error())
-				http.Error(w, "Failed to list pending ads", http.StatusInternalServerError)
-				return
-			}
-
-			query := r.URL.Query()
-			user := query.Get("user")
-
-			if user != "" {
-				adList, err = database.FilterAdsByUser(adList, user)
-				if err != nil {
-					log.Error("Failed to filter ads by user: %s", err.Error())
-					http.Error(w, "Failed to filter ads", http.StatusInternalServerError)
-					return
-				}
-			}
-
-			log.Debug("Returning %d pending advertisements", len(adList))
-
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(adList); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *Handler) registerPending(rt *router.Router) {
+	rt.With(router.JSON, h.auth.RequireStaff).Doc(router.Operation{
+		Summary: "Ads waiting for review",
+		Auth:    router.Staff,
+		Query: []router.Param{
+			{Name: "user", Type: "string", Required: false, Description: "Only ads of this Discord user ID"},
+		},
+		Response: []*utils.Ad{},
+	}).Get("/ads/pending", func(w http.ResponseWriter, r *http.Request) {
+		// Get pending ads directly from database with WHERE pending != 0
+		adList, err := h.ads.ListPending(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list pending ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list pending ads")
+			return
 		}
-	})
 
-	http.HandleFunc("/ads/pending/accept", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
+		query := r.URL.Query()
+		user := query.Get("user")
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
+		if user != "" {
+			adList, err = database.FilterAdsByUser(adList, user)
 			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
-
-			if !u.IsAdmin && !u.IsStaff {
-				log.Error("User of ID %s is not admin or staff", u.ID)
-				http.Error(w, "User is not admin or staff", http.StatusUnauthorized)
+				log.Ctx(r.Context()).Error("Failed to filter ads by user: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to filter ads")
 				return
 			}
+		}
 
-			query := r.URL.Query()
-			idStr := query.Get("id")
+		log.Ctx(r.Context()).Debug("Returning %d pending advertisements", len(adList))
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get ad ID: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// reviewers may open the images outside the dashboard
+		router.WriteJSON(w, http.StatusOK, h.auth.SignedImages(adList))
+	})
 
-			ad, err := database.ApproveAd(id)
-			if err != nil {
-				log.Error("Failed to approve ad: %s", err.Error())
-				http.Error(w, "Failed to approve ad", http.StatusInternalServerError)
-				return
-			}
+	rt.With(router.JSON, h.auth.RequireStaff).Doc(router.Operation{
+		Summary: "Approve a pending ad",
+		Auth:    router.Staff,
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Advertisement ID"},
+		},
+		Response: utils.Ad{},
+	}).Post("/ads/pending/accept", func(w http.ResponseWriter, r *http.Request) {
+		u := access.User(r)
+
+		query := r.URL.Query()
+		idStr := query.Get("id")
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad ID: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			err = discord.WebhookAccept(ad, u)
-			if err != nil {
-				log.Warn(err.Error())
-			}
+		ad, err := h.ads.Approve(r.Context(), id)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to approve ad: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to approve ad")
+			return
+		}
 
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(ad); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		err = h.webhooks.Accept(r.Context(), ad, u)
+		if err != nil {
+			log.Ctx(r.Context()).Warn(err.Error())
 		}
+
+		router.WriteJSON(w, http.StatusOK, ad)
 	})
 }
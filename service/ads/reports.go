@@ -1,254 +1,174 @@
 package ads
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"service/access"
-	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 	"strconv"
 )
 
-func init() {
-	http.HandleFunc("/ads/reports", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
-
-			if !u.IsAdmin && !u.IsStaff {
-				log.Error("User of ID %s is not admin or staff", u.ID)
-				http.Error(w, "User is not admin or staff", http.StatusUnauthorized)
-				return
-			}
-
-			// Default behavior: get user's own ads
-			rows, err := database.ListAllReports()
-			if err != nil {
-				log.Error("Failed to list reports: %s", err.Error())
-				http.Error(w, "Failed to list reports", http.StatusInternalServerError)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(rows); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *Handler) registerReports(rt *router.Router) {
+	rt.With(router.JSON, h.auth.RequireStaff).Doc(router.Operation{
+		Summary:  "Open reports",
+		Auth:     router.Staff,
+		Response: []*utils.Report{},
+	}).Get("/ads/reports", func(w http.ResponseWriter, r *http.Request) {
+		// Default behavior: get user's own ads
+		rows, err := h.reports.List(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list reports: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list reports")
+			return
 		}
-	})
-
-	http.HandleFunc("/ads/reports/action", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
 
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
-
-			if !u.IsAdmin && !u.IsStaff {
-				log.Error("User of ID %s is not admin or staff", u.ID)
-				http.Error(w, "User is not admin or staff", http.StatusUnauthorized)
-				return
-			}
+		router.WriteJSON(w, http.StatusOK, rows)
+	})
 
-			query := r.URL.Query()
+	rt.With(h.auth.RequireStaff).Doc(router.Operation{
+		Summary: "Act on a report",
+		Auth:    router.Staff,
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Report ID"},
+			{Name: "action", Type: "integer", Required: true, Description: "1 deletes the ad, 2 also bans its owner"},
+		},
+		Text: "Took action with report successfully",
+	}).Post("/ads/reports/action", func(w http.ResponseWriter, r *http.Request) {
+		u := access.User(r)
+
+		query := r.URL.Query()
+
+		idStr := query.Get("id")
+		if idStr == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			idStr := query.Get("id")
-			if idStr == "" {
-				http.Error(w, "Missing ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		actionStr := query.Get("action")
+		if actionStr == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing action parameter", router.Details{"parameter": "action"})
+			return
+		}
 
-			actionStr := query.Get("action")
-			if actionStr == "" {
-				http.Error(w, "Missing action parameter", http.StatusBadRequest)
-				return
-			}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Invalid ad ID parameter: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				log.Error("Invalid ad ID parameter: %s", err.Error())
-				http.Error(w, "Invalid ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		action, err := strconv.Atoi(actionStr)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Invalid ad ID parameter: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			action, err := strconv.Atoi(actionStr)
-			if err != nil {
-				log.Error("Invalid ad ID parameter: %s", err.Error())
-				http.Error(w, "Invalid ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		report, err := h.reports.Get(r.Context(), id)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get report: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get report")
+			return
+		}
 
-			report, err := database.GetReport(id)
+		if action == int(utils.ReportActionDelete) {
+			ad, err := h.ads.Delete(r.Context(), report.Ad.AdID)
 			if err != nil {
-				log.Error("Failed to get report: %s", err.Error())
-				http.Error(w, "Failed to get report", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to delete reported advertisement: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to delete reported advertisement")
 				return
 			}
 
-			if action == int(utils.ReportActionDelete) {
-				ad, err := database.DeleteAdvertisement(report.Ad.AdID)
+			log.Ctx(r.Context()).Info("Deleted reported advertisement of ID %d", ad.AdID)
+		} else if action == int(utils.ReportActionBan) {
+			if u.IsAdmin {
+				user, err := h.users.Ban(r.Context(), report.Ad.UserID)
 				if err != nil {
-					log.Error("Failed to delete reported advertisement: %s", err.Error())
-					http.Error(w, "Failed to delete reported advertisement", http.StatusInternalServerError)
+					log.Ctx(r.Context()).Error("Failed to ban owner of reported advertisement: %s", err.Error())
+					router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to ban owner of reported advertisement")
 					return
 				}
 
-				log.Info("Deleted reported advertisement of ID %d", ad.AdID)
-			} else if action == int(utils.ReportActionBan) {
-				if u.IsAdmin {
-					user, err := database.BanUser(report.Ad.UserID)
-					if err != nil {
-						log.Error("Failed to ban owner of reported advertisement: %s", err.Error())
-						http.Error(w, "Failed to ban owner of reported advertisement", http.StatusInternalServerError)
-						return
-					}
-
-					log.Info("Banned owner of ID %s of reported advertisement", user.ID)
-				} else {
-					log.Error("Staff user of ID %s does not have permission to ban through reports", u.ID)
-					http.Error(w, "Staff does not have permission to ban through reports", http.StatusUnauthorized)
-					return
-				}
+				log.Ctx(r.Context()).Info("Banned owner of ID %s of reported advertisement", user.ID)
 			} else {
-				log.Error("Invalid report action")
-				http.Error(w, "Invalid report action", http.StatusBadRequest)
+				log.Ctx(r.Context()).Error("Staff user of ID %s does not have permission to ban through reports", u.ID)
+				router.Error(w, r, http.StatusUnauthorized, router.CodeNotAdmin, "Staff does not have permission to ban through reports")
 				return
 			}
-
-			err = database.FinishReport(report)
-			if err != nil {
-				log.Error("Failed to finalize report action: %s", err.Error())
-				http.Error(w, "Failed to finalize report action", http.StatusInternalServerError)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "Took action with report successfully")
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Ctx(r.Context()).Error("Invalid report action")
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid report action", router.Details{"parameter": "action"})
+			return
 		}
-	})
 
-	http.HandleFunc("/ads/reports/reject", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
-
-			// require login
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			u, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get user: %s", err.Error())
-				http.Error(w, "Failed to get user", http.StatusInternalServerError)
-				return
-			}
+		err = h.reports.Finish(r.Context(), report)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to finalize report action: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to finalize report action")
+			return
+		}
 
-			if !u.IsAdmin && !u.IsStaff {
-				log.Error("User of ID %s is not admin or staff", u.ID)
-				http.Error(w, "User is not admin or staff", http.StatusUnauthorized)
-				return
-			}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Took action with report successfully")
+	})
 
-			query := r.URL.Query()
+	rt.With(h.auth.RequireStaff).Doc(router.Operation{
+		Summary: "Dismiss a report",
+		Auth:    router.Staff,
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Report ID"},
+			{Name: "bl", Type: "boolean", Required: false, Description: "Also stop the reporter from reporting"},
+		},
+		Text: "Rejected report successfully",
+	}).Post("/ads/reports/reject", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		idStr := query.Get("id")
+		if idStr == "" {
+			log.Ctx(r.Context()).Error("Missing ad ID parameter")
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			idStr := query.Get("id")
-			if idStr == "" {
-				log.Error("Missing ad ID parameter")
-				http.Error(w, "Missing ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Invalid ad ID parameter: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				log.Error("Invalid ad ID parameter: %s", err.Error())
-				http.Error(w, "Invalid ad ID parameter", http.StatusBadRequest)
-				return
-			}
+		report, err := h.reports.Get(r.Context(), id)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get report: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get report")
+			return
+		}
 
-			report, err := database.GetReport(id)
+		blacklistStr := query.Get("bl")
+		if blacklistStr != "" {
+			blacklist, err := strconv.ParseBool(blacklistStr)
 			if err != nil {
-				log.Error("Failed to get report: %s", err.Error())
-				http.Error(w, "Failed to get report", http.StatusInternalServerError)
-				return
+				log.Ctx(r.Context()).Error("Invalid boolean value for blacklist: %s", err.Error())
 			}
 
-			blacklistStr := query.Get("bl")
-			if blacklistStr != "" {
-				blacklist, err := strconv.ParseBool(blacklistStr)
-				if err != nil {
-					log.Error("Invalid boolean value for blacklist: %s", err.Error())
-				}
-
-				err = access.ReportBanArgonUser(report, blacklist)
-				if err != nil {
-					log.Error("Failed to blacklist user from reporting: %s", err.Error())
-					http.Error(w, "Failed to blacklist user from reporting", http.StatusInternalServerError)
-					return
-				}
-			}
-
-			err = database.FinishReport(report)
+			err = h.auth.ReportBanArgonUser(r.Context(), report, blacklist)
 			if err != nil {
-				log.Error("Failed to finish report: %s", err.Error())
-				http.Error(w, "Failed to finish report", http.StatusBadRequest)
+				log.Ctx(r.Context()).Error("Failed to blacklist user from reporting: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to blacklist user from reporting")
 				return
 			}
+		}
 
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "Rejected report successfully")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		err = h.reports.Finish(r.Context(), report)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to finish report: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to finish report")
+			return
 		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Rejected report successfully")
 	})
 }
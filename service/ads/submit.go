@@ -9,197 +9,206 @@ import (
 	"time"
 
 	"service/access"
-	"service/database"
-	"service/discord"
 	"service/log"
+	"service/router"
 	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/ads/submit", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
+// Reply to a successful submission
+type submitResponse struct {
+	Status   string `json:"status"`
+	AdID     int64  `json:"ad_id"`
+	ImageURL string `json:"image_url"`
+}
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+func (h *Handler) registerSubmit(rt *router.Router) {
+	rt.With(router.JSON, h.auth.RequireUser).Doc(router.Operation{
+		Summary: "Submit an ad for review",
+		Auth:    router.Session,
+		Form: []router.Param{
+			{Name: "type", Type: "string", Required: true, Description: "banner, square or skyscraper"},
+			{Name: "level-id", Type: "integer", Required: true, Description: "Geometry Dash level ID"},
+			{Name: "image-upload", Type: "file", Required: true, Description: "WebP image of the ad"},
+		},
+		Response: submitResponse{},
+	}).Post("/ads/submit", func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg.Load()
+		if !cfg.Features.Submissions {
+			router.Error(w, r, http.StatusServiceUnavailable, router.CodeSubmissionsDisabled, "Submissions are currently disabled")
+			return
+		}
 
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
+		user := access.User(r)
 
-			// Require logged-in session
-			uid, err := access.GetSessionUserID(r)
-			if err != nil || uid == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+		if user.Banned {
+			log.Ctx(r.Context()).Warn("User %s is banned", user.Username)
+			router.Error(w, r, http.StatusForbidden, router.CodeBanned, "User is banned")
+			return
+		}
 
-			user, err := database.GetUser(uid)
-			if err != nil {
-				log.Error("Failed to get ad owner: %s", err.Error())
-				http.Error(w, "Failed to get ad owner", http.StatusInternalServerError)
-				return
-			}
+		activeAdCount, err := h.ads.CountActiveByUser(r.Context(), user.ID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to count active advertisements: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to check advertisement limit")
+			return
+		}
 
-			if user.Banned {
-				log.Warn("User %s is banned", user.Username)
-				http.Error(w, "User is banned", http.StatusForbidden)
-				return
-			}
+		tier := cfg.Limits.Tier(user.IsAdmin || user.IsStaff, user.Verified)
+		if activeAdCount >= tier.MaxActiveAds {
+			log.Ctx(r.Context()).Error("User %s attempted to submit ad but has reached maximum %d active advertisements", user.Username, activeAdCount)
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeAdLimitReached, "User reached the maximum number of active advertisements", router.Details{"limit": tier.MaxActiveAds})
+			return
+		}
 
-			activeAdCount, err := database.CountActiveAdvertisementsByUser(uid)
-			if err != nil {
-				log.Error("Failed to count active advertisements: %s", err.Error())
-				http.Error(w, "Failed to check advertisement limit", http.StatusInternalServerError)
-				return
-			}
+		// Parse form within the upload limit, leaving room for the other fields
+		r.Body = http.MaxBytesReader(w, r.Body, tier.MaxUploadBytes+(1<<20))
+		r.ParseMultipartForm(tier.MaxUploadBytes)
 
-			maxAllowed := 8
-			if user.IsAdmin || user.IsStaff || user.Verified {
-				maxAllowed = 20
-			}
+		// Get image file
+		file, fileHeader, err := r.FormFile("image-upload")
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Image not found", router.Details{"parameter": "image-upload"})
+			return
+		}
 
-			if activeAdCount >= maxAllowed {
-				log.Error("User %s attempted to submit ad but has reached maximum %d active advertisements", user.Username, activeAdCount)
-				http.Error(w, "User reached the maximum number of active advertisements", http.StatusBadRequest)
-				return
-			}
+		defer file.Close()
 
-			// Parse form with 10MB limit
-			r.ParseMultipartForm(10 << 20)
+		if fileHeader.Size > tier.MaxUploadBytes {
+			log.Ctx(r.Context()).Warn("User %s uploaded a %d B image over the %d B limit", user.Username, fileHeader.Size, tier.MaxUploadBytes)
+			router.ErrorDetails(w, r, http.StatusRequestEntityTooLarge, router.CodeImageTooLarge, "Image is too large", router.Details{"max_bytes": tier.MaxUploadBytes})
+			return
+		}
 
-			// Get image file
-			file, _, err := r.FormFile("image-upload")
-			if err != nil {
-				log.Error(err.Error())
-				http.Error(w, "Image not found", http.StatusBadRequest)
-				return
-			}
+		adFolder := r.Form.Get("type")
+		levelID := r.Form.Get("level-id")
+		if adFolder == "" || levelID == "" {
+			router.Error(w, r, http.StatusBadRequest, router.CodeMissingParameter, "Missing type or levelID")
+			return
+		}
 
-			defer file.Close()
+		// Map type to number
+		typeNum, err := utils.AdTypeToInt(utils.AdType(adFolder))
+		if err != nil {
+			log.Ctx(r.Context()).Error("Invalid ad type: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad type", router.Details{"parameter": "type"})
+			return
+		}
 
-			adFolder := r.Form.Get("type")
-			levelID := r.Form.Get("level-id")
-			if adFolder == "" || levelID == "" {
-				http.Error(w, "Missing type or levelID", http.StatusBadRequest)
-				return
-			}
+		// Create target folder, kept from the public until the ad is approved
+		targetDir := filepath.Join(utils.StorageDir, utils.PendingFolder, adFolder)
+		err = os.MkdirAll(targetDir, os.ModePerm)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get directory %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get directory")
+			return
+		}
 
-			// Map type to number
-			typeNum, err := utils.AdTypeToInt(utils.AdType(adFolder))
-			if err != nil {
-				log.Error("Invalid ad type: %s", err.Error())
-				http.Error(w, "Invalid ad type", http.StatusBadRequest)
-				return
-			}
+		fileName := fmt.Sprintf("%s.webp", user.ID)
+		dstPath := filepath.Join(targetDir, fileName)
 
-			// Create target folder
-			targetDir := filepath.Join("..", "ad_storage", adFolder)
-			err = os.MkdirAll(targetDir, os.ModePerm)
-			if err != nil {
-				log.Error("Failed to get directory %s", err.Error())
-				http.Error(w, "Failed to get directory", http.StatusInternalServerError)
-				return
+		dst, err := os.Create(dstPath)
+		if err != nil {
+			log.Ctx(r.Context()).Error(err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to save image")
+			return
+		}
+
+		if _, err := io.Copy(dst, file); err != nil {
+			dst.Close()
+			log.Ctx(r.Context()).Error(err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to save image")
+			return
+		}
+
+		// Close the file before renaming
+		dst.Close()
+
+		adID, err := h.ads.Create(r.Context(), user.ID, levelID, typeNum)
+		if err != nil {
+			e := os.Remove(dstPath)
+			if e != nil {
+				log.Ctx(r.Context()).Error("Failed to delete advertisement image: %s", e.Error())
 			}
 
-			fileName := fmt.Sprintf("%s.webp", uid)
-			dstPath := filepath.Join(targetDir, fileName)
+			log.Ctx(r.Context()).Error("Failed to create advertisement row: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to create advertisement")
+			return
+		}
 
-			dst, err := os.Create(dstPath)
-			if err != nil {
-				log.Error(err.Error())
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
-				return
+		// Now rename the file to include the ad ID
+		newFileName := fmt.Sprintf("%s-%d.webp", user.ID, adID)
+		newDstPath := filepath.Join(targetDir, newFileName)
+		err = os.Rename(dstPath, newDstPath)
+		if err != nil {
+			_, e := h.ads.Delete(r.Context(), adID)
+			if e != nil {
+				log.Ctx(r.Context()).Error("Failed to delete advertisement row: %s", e.Error())
 			}
 
-			if _, err := io.Copy(dst, file); err != nil {
-				dst.Close()
-				log.Error(err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			e = os.Remove(dstPath)
+			if e != nil {
+				log.Ctx(r.Context()).Error("Failed to delete advertisement image: %s", e.Error())
 			}
 
-			// Close the file before renaming
-			dst.Close()
+			log.Ctx(r.Context()).Error("Failed to rename advertisement image: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to rename advertisement image")
+			return
+		}
 
-			adID, err := database.CreateAdvertisement(uid, levelID, typeNum)
-			if err != nil {
-				e := os.Remove(dstPath)
-				if e != nil {
-					log.Error("Failed to delete advertisement image: %s", e.Error())
-				}
-
-				log.Error("Failed to create advertisement row: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+		// Update the image URL with the correct filename
+		imageURL := fmt.Sprintf("%s/cdn/%s/%s/%s?v=%d", access.GetDomain(r), utils.PendingFolder, adFolder, newFileName, time.Now().Unix())
+		err = h.ads.SetImageURL(r.Context(), adID, imageURL)
+		if err != nil {
+			_, e := h.ads.Delete(r.Context(), adID)
+			if e != nil {
+				log.Ctx(r.Context()).Error("Failed to delete advertisement row: %s", e.Error())
 			}
 
-			// Now rename the file to include the ad ID
-			newFileName := fmt.Sprintf("%s-%d.webp", uid, adID)
-			newDstPath := filepath.Join(targetDir, newFileName)
-			err = os.Rename(dstPath, newDstPath)
-			if err != nil {
-				_, e := database.DeleteAdvertisement(adID)
-				if e != nil {
-					log.Error("Failed to delete advertisement row: %s", e.Error())
-				}
-
-				e = os.Remove(dstPath)
-				if e != nil {
-					log.Error("Failed to delete advertisement image: %s", e.Error())
-				}
-
-				log.Error("Failed to rename advertisement image: %s", err.Error())
-				http.Error(w, "Failed to rename advertisement image", http.StatusInternalServerError)
-				return
+			e = os.Remove(newDstPath)
+			if e != nil {
+				log.Ctx(r.Context()).Error("Failed to delete advertisement image: %s", e.Error())
 			}
 
-			// Update the image URL with the correct filename
-			imageURL := fmt.Sprintf("%s/cdn/%s/%s?v=%d", access.GetDomain(r), adFolder, newFileName, time.Now().Unix())
-			err = database.UpdateAdvertisementImageURL(adID, imageURL)
-			if err != nil {
-				_, e := database.DeleteAdvertisement(adID)
-				if e != nil {
-					log.Error("Failed to delete advertisement row: %s", e.Error())
-				}
-
-				e = os.Remove(newDstPath)
-				if e != nil {
-					log.Error("Failed to delete advertisement image: %s", e.Error())
-				}
-
-				log.Error("Failed to update advertisement image URL: %s", err.Error())
-				http.Error(w, "Failed to update advertisement image URL", http.StatusInternalServerError)
-				return
-			}
+			log.Ctx(r.Context()).Error("Failed to update advertisement image URL: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to update advertisement image URL")
+			return
+		}
 
-			log.Info("Saved ad to %s, ad_id=%v, user_id=%s", newDstPath, adID, uid)
+		log.Ctx(r.Context()).Info("Saved ad to %s, ad_id=%v, user_id=%s", newDstPath, adID, user.ID)
 
-			ad, err := database.GetAdvertisement(adID)
+		// approved before telling staff, as approving moves the image
+		var approved *utils.Ad
+		if user.IsAdmin || user.IsStaff || user.Verified {
+			approved, err = h.ads.Approve(r.Context(), adID)
 			if err != nil {
-				log.Warn(err.Error())
+				log.Ctx(r.Context()).Error("Failed to auto-approve new ad by verified user: %s", err.Error())
 			} else {
-				err = discord.WebhookStaffSubmit(ad)
-				if err != nil {
-					log.Warn(err.Error())
-				}
+				log.Ctx(r.Context()).Info("Auto-approved ad %s (%v) by verified user %s (%s)", approved.ImageURL, approved.AdID, user.Username, user.ID)
+				imageURL = approved.ImageURL
 			}
+		}
+
+		ad, err := h.ads.Get(r.Context(), adID)
+		if err != nil {
+			log.Ctx(r.Context()).Warn(err.Error())
+		} else {
+			signed := *ad
+			signed.ImageURL = h.auth.SignImage(ad.ImageURL)
 
-			if user.IsAdmin || user.IsStaff || user.Verified {
-				newAd, err := database.ApproveAd(adID)
-				if err != nil {
-					log.Error("Failed to auto-approve new ad by verified user: %s", err.Error())
-				} else {
-					log.Info("Auto-approved ad %s (%v) by verified user %s (%s)", newAd.ImageURL, newAd.AdID, user.Username, user.ID)
-					err = discord.WebhookAccept(newAd, nil)
-					if err != nil {
-						log.Warn(err.Error())
-					}
-				}
+			err = h.webhooks.StaffSubmit(r.Context(), &signed)
+			if err != nil {
+				log.Ctx(r.Context()).Warn(err.Error())
 			}
+		}
 
-			w.Write(fmt.Appendf(nil, `{"status":"ok","ad_id":%d,"image_url":"%s"}`, adID, imageURL))
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if approved != nil {
+			err = h.webhooks.Accept(r.Context(), approved, nil)
+			if err != nil {
+				log.Ctx(r.Context()).Warn(err.Error())
+			}
 		}
+
+		router.WriteJSON(w, http.StatusOK, submitResponse{Status: "ok", AdID: adID, ImageURL: imageURL})
 	})
 }
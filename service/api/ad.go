@@ -1,7 +1,8 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -13,287 +14,302 @@ import (
 	"service/access"
 	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 
 	"github.com/patrickmn/go-cache"
 )
 
-var globalStats = cache.New(10*time.Minute, 15*time.Minute)
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
-
-	http.HandleFunc("/api/ad", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Getting random ad...")
+func (h *Handler) registerAd(rt *router.Router) {
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary: "Pick an ad to show in the game",
+		Query: []router.Param{
+			{Name: "type", Type: "integer", Required: true, Description: "1 banner, 2 square, 3 skyscraper"},
+		},
+		Response: utils.Ad{},
+	}).Get("/api/ad", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Getting random ad...")
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+		header.Set("Cache-Control", "no-store")
 
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-			header.Set("Cache-Control", "no-store")
+		var adFolder utils.AdType
 
-			var adFolder utils.AdType
+		query := r.URL.Query()
+		adTypeStr := query.Get("type")
 
-			query := r.URL.Query()
-			adTypeStr := query.Get("type")
+		typeNum, err := strconv.Atoi(adTypeStr)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad type ID: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad type", router.Details{"parameter": "type"})
+			return
+		}
 
-			typeNum, err := strconv.Atoi(adTypeStr)
-			if err != nil {
-				log.Error("Failed to get ad type ID: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		adFolder, err = utils.AdTypeFromInt(typeNum)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad folder: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad type", router.Details{"parameter": "type"})
+			return
+		}
 
-			adFolder, err = utils.AdTypeFromInt(typeNum)
-			if err != nil {
-				log.Error("Failed to get ad folder: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+		start := time.Now()
+		liveAds, err := h.servable(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to list servable ads: %s", err.Error())
+			if utils.Degraded() {
+				router.Error(w, r, http.StatusServiceUnavailable, router.CodeDegraded, "No ads available while the database is unavailable")
+			} else {
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to list ads")
 			}
 
-			rows, err := database.ListAllAdvertisements()
-			if err != nil {
-				log.Error("Failed to list ads: %s", err.Error())
-				http.Error(w, "Failed to list ads", http.StatusInternalServerError)
-				return
-			}
+			return
+		}
 
-			safeAds, err := database.FilterAdsFromBannedUsers(rows)
-			if err != nil {
-				log.Error("Failed to filter safe ads: %s", err.Error())
-				http.Error(w, "Failed to filter safe ads", http.StatusInternalServerError)
-				return
-			}
+		log.Ctx(r.Context()).Debug("Filtering for %s type ads...", adFolder)
+		ads, err := database.FilterAdsByType(liveAds, adFolder)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to filter through ads: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to filter through ads")
+			return
+		}
 
-			liveAds, err := database.FilterAdsByPending(safeAds, false)
-			if err != nil {
-				log.Error("Failed to filter pending ads: %s", err.Error())
-				http.Error(w, "Failed to filter pending ads", http.StatusInternalServerError)
-				return
-			}
+		if len(ads) <= 0 {
+			log.Ctx(r.Context()).Info("No ads found for type %s", adFolder)
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No ads found", router.Details{"resource": "ad"})
+			return
+		}
 
-			log.Debug("Filtering for %s type ads...", adFolder)
-			ads, err := database.FilterAdsByType(liveAds, adFolder)
-			if err != nil {
-				log.Error("Failed to filter through ads: %s", err.Error())
-				http.Error(w, "Failed to filter through ads", http.StatusInternalServerError)
-				return
-			}
+		log.Ctx(r.Context()).Debug("Getting random %s type ad...", adFolder)
+		cfg := h.cfg.Load()
+		sel := cfg.Selection
 
-			if len(ads) <= 0 {
-				log.Info("No ads found for type %s", adFolder)
-				http.Error(w, "No ads found", http.StatusNotFound)
-				return
-			}
+		// owner and click bonuses need the database, outages weigh ads by what is in memory
+		degraded := utils.Degraded()
 
-			log.Debug("Getting random %s type ad...", adFolder)
-			totalWeight := 0.0
-			weights := make([]float64, len(ads))
-			for idx, a := range ads {
-				w := 1.0
-				globalClicks := uint64(1)
+		totalWeight := 0.0
+		weights := make([]float64, len(ads))
+		for idx, a := range ads {
+			w := sel.Base
+			globalClicks := uint64(1)
 
-				if val, found := globalStats.Get("global_clicks"); found {
-					globalClicks = val.(uint64)
+			if val, found := h.globalStats.Get("global_clicks"); found {
+				globalClicks = val.(uint64)
+			} else if !degraded {
+				stats, err := h.ads.GlobalStats(r.Context())
+				if err != nil {
+					log.Ctx(r.Context()).Error("Failed to get global ad stats: %s", err.Error())
 				} else {
-					stats, err := database.GetGlobalStats()
-					if err != nil {
-						log.Error("Failed to get global ad stats: %s", err.Error())
-					} else {
-						globalClicks = uint64(stats.TotalClicks)
-						globalStats.Set("global_clicks", globalClicks, cache.DefaultExpiration)
-					}
+					globalClicks = uint64(stats.TotalClicks)
+					h.globalStats.Set("global_clicks", globalClicks, cache.DefaultExpiration)
 				}
+			}
 
-				if a.BoostCount > 0 {
-					w += float64(a.BoostCount)
-				}
+			if a.BoostCount > 0 {
+				w += sel.PerBoost * float64(a.BoostCount)
+			}
 
-				u, err := database.GetUser(a.UserID)
+			var u *utils.User
+			if !degraded {
+				u, err = h.users.Get(r.Context(), a.UserID)
 				if err != nil {
-					log.Error("Failed to get ad owner for boosting: %s", err.Error())
-				} else {
-					if u.Verified {
-						w += 3
-					}
+					log.Ctx(r.Context()).Error("Failed to get ad owner for boosting: %s", err.Error())
 				}
+			}
 
-				if a.BoostCount > 15 {
-					a.Glow = 3
-				} else if u.Verified {
-					a.Glow = 2
-				} else if a.BoostCount > 0 {
-					a.Glow = 1
-				} else {
-					a.Glow = 0
-				}
+			if u != nil && u.Verified {
+				w += sel.Verified
+			}
 
-				if time.Since(a.Created).Hours() < 60 {
-					denom := 0.025 * float64(globalClicks)
-					if denom <= 1 {
-						denom = 1
-					}
+			a.Glow = cfg.Limits.GlowLevel(a.BoostCount, u != nil && u.Verified)
 
-					w += 3 * math.Exp(-float64(a.Clicks)/denom)
+			if time.Since(a.Created) < sel.FreshWindow.Duration {
+				denom := sel.FreshDecay * float64(globalClicks)
+				if denom <= 1 {
+					denom = 1
 				}
 
-				if time.Since(a.Created).Hours() >= 24 {
-					p := float64(a.Clicks+1) / float64(a.Views+2)
-					if p <= 0 {
-						p = 0
-					} else if p >= 2 {
-						p = 2
-					}
-
-					w += p
-				}
+				w += sel.Fresh * math.Exp(-float64(a.Clicks)/denom)
+			}
 
-				if a.Clicks > 0 && a.Views > 0 {
-					w += (float64(a.Clicks) / float64(a.Views)) * 10
-				}
-				if u.TotalClicks > 0 && u.TotalViews > 0 {
-					w += float64(u.TotalClicks) / float64(u.TotalViews)
+			if time.Since(a.Created) >= sel.MatureAfter.Duration {
+				p := float64(a.Clicks+1) / float64(a.Views+2)
+				if p <= 0 {
+					p = 0
+				} else if p >= sel.MatureCap {
+					p = sel.MatureCap
 				}
 
-				weights[idx] = w
-				totalWeight += w
+				w += p
 			}
 
-			maxWeight := slices.Max(weights)
-			if maxWeight > 0 {
-				for i := range weights {
-					weights[i] /= maxWeight
-				}
+			if a.Clicks > 0 && a.Views > 0 {
+				w += (float64(a.Clicks) / float64(a.Views)) * sel.ClickRate
 			}
+			if u != nil && u.TotalClicks > 0 && u.TotalViews > 0 {
+				w += sel.OwnerClickRate * float64(u.TotalClicks) / float64(u.TotalViews)
+			}
+
+			weights[idx] = w
+			totalWeight += w
+		}
 
-			totalWeight = 0
-			for _, w := range weights {
-				totalWeight += w
+		maxWeight := slices.Max(weights)
+		if maxWeight > 0 {
+			for i := range weights {
+				weights[i] /= maxWeight
 			}
+		}
 
-			var chosenIdx int
-			if totalWeight <= 0 {
-				chosenIdx = rand.Intn(len(ads))
-			} else {
-				rn := rand.Float64() * totalWeight
-				cn := 0.0
-				for idx, w := range weights {
-					cn += w
-					if rn < cn {
-						chosenIdx = idx
-						break
-					}
+		totalWeight = 0
+		for _, w := range weights {
+			totalWeight += w
+		}
+
+		var chosenIdx int
+		if totalWeight <= 0 {
+			chosenIdx = rand.Intn(len(ads))
+		} else {
+			rn := rand.Float64() * totalWeight
+			cn := 0.0
+			for idx, w := range weights {
+				cn += w
+				if rn < cn {
+					chosenIdx = idx
+					break
 				}
 			}
-			ad := ads[chosenIdx]
+		}
+		ad := ads[chosenIdx]
+		selectionDuration.Since(start)
 
-			if ad.ImageURL == "" {
-				err = database.UpdateAdvertisementImageURL(ad.AdID, fmt.Sprintf("%s/cdn/%s/%s?v=%d", access.GetDomain(r), adFolder, fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID), time.Now().Unix()))
-				if err != nil {
-					log.Error("Failed to fix advertisement image URL: %s", err.Error())
-				}
+		if ad.ImageURL == "" && !degraded {
+			err = h.ads.SetImageURL(r.Context(), ad.AdID, fmt.Sprintf("%s/cdn/%s/%s?v=%d", access.GetDomain(r), adFolder, fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID), time.Now().Unix()))
+			if err != nil {
+				log.Ctx(r.Context()).Error("Failed to fix advertisement image URL: %s", err.Error())
 			}
+		}
 
-			// Get view and click stats for this ad
-			views, clicks, err := database.GetAdStats(ad.AdID)
+		// Get view and click stats for this ad
+		if !degraded {
+			views, clicks, err := h.ads.Stats(r.Context(), ad.AdID)
 			if err != nil {
-				log.Error("Failed to get ad stats: %s", err.Error())
+				log.Ctx(r.Context()).Error("Failed to get ad stats: %s", err.Error())
 			} else {
 				ad.Views = uint64(views)
 				ad.Clicks = uint64(clicks)
 			}
-
-			log.Debug("Returning ad as JSON: %s", ad.ImageURL)
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(ad); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
+
+		log.Ctx(r.Context()).Debug("Returning ad as JSON: %s", ad.ImageURL)
+		adsServed.Inc(string(adFolder))
+		router.WriteJSON(w, http.StatusOK, ad)
 	})
 
-	http.HandleFunc("/api/ad/get", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Getting ad by id...")
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary: "Get an approved ad",
+		Query: []router.Param{
+			{Name: "id", Type: "integer", Required: true, Description: "Advertisement ID"},
+		},
+		Response: utils.Ad{},
+	}).Get("/api/ad/get", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Getting ad by id...")
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+		header.Set("Cache-Control", "no-store")
 
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
-			header.Set("Cache-Control", "no-store")
+		query := r.URL.Query()
+		idStr := query.Get("id")
 
-			query := r.URL.Query()
-			idStr := query.Get("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad ID: %s", err.Error())
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, "Invalid ad ID parameter", router.Details{"parameter": "id"})
+			return
+		}
 
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				log.Error("Failed to get ad ID: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		ad, err := h.ads.Get(r.Context(), id)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get ad")
+			return
+		}
 
-			ad, err := database.GetAdvertisement(id)
+		if ad.ImageURL == "" {
+			adFolder, err := utils.AdTypeFromInt(ad.Type)
 			if err != nil {
-				log.Error("Failed to get ad: %s", err.Error())
-				http.Error(w, "Failed to get ad", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to get ad type: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get ad type")
 				return
 			}
 
-			if ad.ImageURL == "" {
-				adFolder, err := utils.AdTypeFromInt(ad.Type)
-				if err != nil {
-					log.Error("Failed to get ad type: %s", err.Error())
-					http.Error(w, "Failed to get ad type", http.StatusInternalServerError)
-					return
-				}
-
-				err = database.UpdateAdvertisementImageURL(ad.AdID, fmt.Sprintf("%s/cdn/%s/%s?v=%d", access.GetDomain(r), adFolder, fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID), time.Now().Unix()))
-				if err != nil {
-					log.Error("Failed to fix advertisement image URL: %s", err.Error())
-					http.Error(w, "Failed to fix advertisement image URL", http.StatusInternalServerError)
-					return
-				}
-			}
-
-			user, err := database.GetUser(ad.UserID)
+			err = h.ads.SetImageURL(r.Context(), ad.AdID, fmt.Sprintf("%s/cdn/%s/%s?v=%d", access.GetDomain(r), adFolder, fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID), time.Now().Unix()))
 			if err != nil {
-				log.Error("Failed to get ad owner: %s", err.Error())
-				http.Error(w, "Failed to get ad owner", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to fix advertisement image URL: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to fix advertisement image URL")
 				return
 			}
+		}
 
-			if user.Banned {
-				log.Warn("Owner %s of advertisement of ID %v is banned", user.Username, ad.AdID)
-				http.Error(w, "Advertisement owner is banned", http.StatusForbidden)
-				return
-			}
+		user, err := h.users.Get(r.Context(), ad.UserID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad owner: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get ad owner")
+			return
+		}
 
-			// Get view and click stats for this ad
-			views, clicks, err := database.GetAdStats(ad.AdID)
-			if err != nil {
-				log.Error("Failed to get ad stats: %s", err.Error())
-			} else {
-				ad.Views = uint64(views)
-				ad.Clicks = uint64(clicks)
-			}
+		if user.Banned {
+			log.Ctx(r.Context()).Warn("Owner %s of advertisement of ID %v is banned", user.Username, ad.AdID)
+			router.Error(w, r, http.StatusForbidden, router.CodeOwnerBanned, "Advertisement owner is banned")
+			return
+		}
 
-			log.Info("Returning ad as JSON: %s", ad.ImageURL)
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(ad); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
+		// Get view and click stats for this ad
+		views, clicks, err := h.ads.Stats(r.Context(), ad.AdID)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get ad stats: %s", err.Error())
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			ad.Views = uint64(views)
+			ad.Clicks = uint64(clicks)
 		}
+
+		log.Ctx(r.Context()).Info("Returning ad as JSON: %s", ad.ImageURL)
+		router.WriteJSON(w, http.StatusOK, ad)
 	})
 }
+
+// servable lists the approved ads of owners in good standing. When the
+// database fails it falls back to the last list that did not.
+func (h *Handler) servable(ctx context.Context) ([]*utils.Ad, error) {
+	ads, err := h.liveAds(ctx)
+	if err == nil {
+		h.pool.store(ads)
+		return ads, nil
+	}
+
+	fallback, poolErr := h.pool.load()
+	if poolErr != nil {
+		return nil, errors.Join(err, poolErr)
+	}
+
+	log.Ctx(ctx).Warn("Serving %d ads from the last good pool: %s", len(fallback), err.Error())
+	return fallback, nil
+}
+
+func (h *Handler) liveAds(ctx context.Context) ([]*utils.Ad, error) {
+	rows, err := h.ads.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ads: %w", err)
+	}
+
+	safeAds, err := database.FilterAdsFromBannedUsers(ctx, h.users, rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter safe ads: %w", err)
+	}
+
+	liveAds, err := database.FilterAdsByPending(safeAds, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter pending ads: %w", err)
+	}
+
+	return liveAds, nil
+}
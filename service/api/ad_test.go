@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+func TestRandomAd(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.AddUser(utils.User{ID: "1", Username: "owner"})
+	banned := ts.mem.AddUser(utils.User{ID: "2", Username: "banned", Banned: true})
+
+	live := ts.mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1})
+	ts.mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1, Pending: true})
+	ts.mem.AddAd(utils.Ad{UserID: banned.ID, Type: 1})
+	ts.mem.AddAd(utils.Ad{UserID: banned.ID, Type: 2})
+
+	t.Run("serves only approved ads of owners in good standing", func(t *testing.T) {
+		for range 20 {
+			resp, body := ts.Get(t, "/api/ad?type=1", nil)
+			if got := routertest.Decode[utils.Ad](t, resp, body); got.AdID != live.AdID {
+				t.Fatalf("served ad %d, expected %d", got.AdID, live.AdID)
+			}
+		}
+	})
+
+	t.Run("fixes a missing image URL", func(t *testing.T) {
+		resp, body := ts.Get(t, "/api/ad?type=1", nil)
+		routertest.Decode[utils.Ad](t, resp, body)
+
+		ad, err := ts.repos.Ads.Get(t.Context(), live.AdID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ad.ImageURL == "" {
+			t.Error("image URL left empty")
+		}
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   router.Code
+	}{
+		{name: "without ads of the type", path: "/api/ad?type=2", status: http.StatusNotFound, code: router.CodeNotFound},
+		{name: "unknown type", path: "/api/ad?type=9", status: http.StatusBadRequest, code: router.CodeInvalidParameter},
+		{name: "malformed type", path: "/api/ad?type=banner", status: http.StatusBadRequest, code: router.CodeInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.Get(t, tt.path, nil)
+			routertest.ExpectError(t, resp, body, tt.status, tt.code)
+		})
+	}
+}
+
+func TestGetAd(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.AddUser(utils.User{ID: "1", Username: "owner"})
+	banned := ts.mem.AddUser(utils.User{ID: "2", Username: "banned", Banned: true})
+
+	ad := ts.mem.AddAd(utils.Ad{UserID: owner.ID, Type: 2, Views: 10, Clicks: 3})
+	hidden := ts.mem.AddAd(utils.Ad{UserID: banned.ID, Type: 2})
+
+	t.Run("with stats", func(t *testing.T) {
+		resp, body := ts.Get(t, fmt.Sprintf("/api/ad/get?id=%d", ad.AdID), nil)
+		got := routertest.Decode[utils.Ad](t, resp, body)
+		if got.AdID != ad.AdID || got.Views != 10 || got.Clicks != 3 {
+			t.Errorf("got %+v, expected ad %d with 10 views and 3 clicks", got, ad.AdID)
+		}
+	})
+
+	t.Run("of a banned owner", func(t *testing.T) {
+		resp, body := ts.Get(t, fmt.Sprintf("/api/ad/get?id=%d", hidden.AdID), nil)
+		routertest.ExpectError(t, resp, body, http.StatusForbidden, router.CodeOwnerBanned)
+	})
+
+	t.Run("malformed id", func(t *testing.T) {
+		resp, body := ts.Get(t, "/api/ad/get?id=first", nil)
+		routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeInvalidParameter)
+	})
+}
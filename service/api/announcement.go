@@ -1,39 +1,27 @@
 package api
 
 import (
-	"encoding/json"
 	"net/http"
-	"service/database"
+
 	"service/log"
+	"service/router"
+	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/api/announcement", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Getting latest announcement...")
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodGet {
-			header.Set("Content-Type", "application/json")
+func (h *Handler) registerAnnouncement(rt *router.Router) {
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary:  "Latest announcement",
+		Response: utils.Announcement{},
+	}).Get("/api/announcement", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Getting latest announcement...")
 
-			announcement, err := database.GetLatestAnnouncement()
-			if err != nil {
-				log.Error("Failed to get latest announcement: %s", err.Error())
-				http.Error(w, "Failed to get latest announcement", http.StatusInternalServerError)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(announcement); err != nil {
-				log.Error("Failed to encode response: %s", err.Error())
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		announcement, err := h.announcements.Latest(r.Context())
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to get latest announcement: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to get latest announcement")
+			return
 		}
+
+		router.WriteJSON(w, http.StatusOK, announcement)
 	})
 }
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"sync/atomic"
+	"time"
+
+	"service/access"
+	"service/config"
+	"service/database"
+	"service/metrics"
+	"service/router"
+	"service/utils"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Serving and events of the mod endpoints
+var (
+	adsServed         = metrics.NewCounter("ads_served_total", "Ads served to the mod by type.", "type")
+	adEvents          = metrics.NewCounter("ad_events_total", "Views and clicks recorded from the mod.", "event")
+	selectionDuration = metrics.NewHistogram("ad_selection_duration_seconds", "Time taken to pick the ad served by /api/ad.", metrics.DefaultBuckets)
+)
+
+// Endpoints used by the mod and the Ko-fi webhook
+type Handler struct {
+	cfg           atomic.Pointer[config.Config] // Latest reloadable settings
+	ads           database.AdRepository
+	users         database.UserRepository
+	reports       database.ReportRepository
+	announcements database.AnnouncementRepository
+	auth          *access.Handler
+	globalStats   *cache.Cache
+	pool          *pool // Fallback of /api/ad during outages
+}
+
+func New(store *config.Store, repos *database.Repositories, auth *access.Handler) *Handler {
+	h := &Handler{
+		ads:           repos.Ads,
+		users:         repos.Users,
+		reports:       repos.Reports,
+		announcements: repos.Announcements,
+		auth:          auth,
+		globalStats:   cache.New(10*time.Minute, 15*time.Minute),
+		pool:          &pool{path: store.Current().Database.Snapshot},
+	}
+
+	store.Subscribe(func(cfg *config.Config) { h.cfg.Store(cfg) })
+	return h
+}
+
+func (h *Handler) Register(rt *router.Router) {
+	h.registerAd(rt)
+	h.registerAnnouncement(rt)
+	h.registerLimits(rt)
+	// views and clicks are spooled during outages rather than refused
+	readOnly := rt.With(router.ReadOnly(utils.Degraded))
+	h.registerOrder(readOnly)
+	h.registerReport(readOnly)
+	h.registerStats(rt)
+}
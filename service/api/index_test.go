@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"service/access"
+	"service/config"
+	"service/database"
+	"service/database/memory"
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+// Account and token the fake Argon server accepts
+const (
+	playerAccount = 7
+	playerToken   = "token"
+)
+
+// Mod endpoints backed by an in-memory store, validating players against a
+// fake Argon server
+type testServer struct {
+	*routertest.Server
+	mem   *memory.Store
+	repos *database.Repositories
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	argon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		valid := query.Get("account_id") == "7" && query.Get("authtoken") == playerToken
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(utils.ArgonValidation{Valid: valid, Cause: "token mismatch"})
+	}))
+	t.Cleanup(argon.Close)
+
+	cfg := config.Default()
+	cfg.Endpoints.Argon = argon.URL
+	cfg.Database.Snapshot = filepath.Join(t.TempDir(), "ads-snapshot.json")
+
+	mem := memory.New(cfg.Limits)
+	store := config.NewStore("", cfg)
+	repos := mem.Repositories()
+
+	mux := http.NewServeMux()
+	New(store, repos, access.New(store, repos, router.NewOrigins())).Register(router.New(mux))
+
+	return &testServer{Server: routertest.NewServer(t, mux), mem: mem, repos: repos}
+}
+
+func TestLimits(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := ts.Get(t, "/api/limits", nil)
+	got := routertest.Decode[limitsResponse](t, resp, body)
+
+	tiers := config.Default().Limits.Tiers
+	for name, tt := range map[string]struct{ got, want config.Tier }{
+		"normal":   {got.Tiers.Normal.Tier, tiers.Normal},
+		"verified": {got.Tiers.Verified.Tier, tiers.Verified},
+		"staff":    {got.Tiers.Staff.Tier, tiers.Staff},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %s tier %+v, expected %+v", name, tt.got, tt.want)
+		}
+	}
+
+	if want := int64(tiers.Normal.AdLifetime.Seconds()); got.Tiers.Normal.AdLifetimeSeconds != want {
+		t.Errorf("got a lifetime of %d seconds, expected %d", got.Tiers.Normal.AdLifetimeSeconds, want)
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	ts := newTestServer(t)
+	ts.mem.AddUser(utils.User{ID: "1", Username: "admin", IsAdmin: true})
+
+	for _, title := range []string{"First", "Second"} {
+		if _, err := ts.mem.AddAnnouncement("1", title, "Content of "+title); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, body := ts.Get(t, "/api/announcement", nil)
+	got := routertest.Decode[utils.Announcement](t, resp, body)
+	if got.Title != "Second" || got.User.Username != "admin" {
+		t.Errorf("got %+v, expected the second announcement by admin", got)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+
+	"service/config"
+	"service/log"
+	"service/router"
+)
+
+// Limits with the ad lifetime of every tier also given in seconds for clients
+// without duration parsing
+type limitsResponse struct {
+	Rewards config.Rewards `json:"rewards"`
+	Glow    config.Glow    `json:"glow"`
+	Tiers   tiersResponse  `json:"tiers"`
+}
+
+type tiersResponse struct {
+	Normal   tierResponse `json:"normal"`
+	Verified tierResponse `json:"verified"`
+	Staff    tierResponse `json:"staff"`
+}
+
+type tierResponse struct {
+	config.Tier
+	AdLifetimeSeconds int64 `json:"ad_lifetime_seconds"`
+}
+
+func newTierResponse(t config.Tier) tierResponse {
+	return tierResponse{Tier: t, AdLifetimeSeconds: int64(t.AdLifetime.Seconds())}
+}
+
+func (h *Handler) registerLimits(rt *router.Router) {
+	rt.With(router.JSON).Doc(router.Operation{
+		Summary:  "Business limits in effect",
+		Response: limitsResponse{},
+	}).Get("/api/limits", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Getting business limits...")
+
+		limits := h.cfg.Load().Limits
+		body := limitsResponse{
+			Rewards: limits.Rewards,
+			Glow:    limits.Glow,
+			Tiers: tiersResponse{
+				Normal:   newTierResponse(limits.Tiers.Normal),
+				Verified: newTierResponse(limits.Tiers.Verified),
+				Staff:    newTierResponse(limits.Tiers.Staff),
+			},
+		}
+
+		router.WriteJSON(w, http.StatusOK, body)
+	})
+}
@@ -1,14 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
-	"service/database"
 	"service/log"
+	"service/router"
 )
 
 type KofiType string
@@ -39,128 +39,110 @@ type Kofi struct {
 	DiscordUserID         string         `json:"discord_userid"`
 }
 
-func boostCode(env string) (string, error) {
-	code := os.Getenv(env)
-	if code == "" {
-		return "", fmt.Errorf("direct link code env %s is not defined!", env)
-	} else {
-		return code, nil
-	}
-}
-
-func getBoostReward(code string) uint {
-	codeBoost, err := boostCode("KOFI_LINK_BOOST")
-	if err != nil {
-		log.Error(err.Error())
-		return 0
-	}
-
-	codeBoostOverdrive, err := boostCode("KOFI_LINK_BOOST_OVERDRIVE")
-	if err != nil {
-		log.Error(err.Error())
+func (h *Handler) getBoostReward(ctx context.Context, code string) uint {
+	cfg := h.cfg.Load()
+	kofi := cfg.Kofi
+	if kofi.LinkBoost == "" || kofi.LinkBoostOverdrive == "" {
+		log.Ctx(ctx).Error("Ko-fi direct link codes are not configured!")
 		return 0
 	}
 
 	switch code {
-	case codeBoost:
-		return 5
+	case kofi.LinkBoost:
+		return cfg.Limits.Rewards.Boost
 
-	case codeBoostOverdrive:
-		return 50
+	case kofi.LinkBoostOverdrive:
+		return cfg.Limits.Rewards.BoostOverdrive
 
 	default:
 		return 0
 	}
 }
 
-func init() {
-	http.HandleFunc("/api/order", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Ko-fi webhook called")
+func (h *Handler) registerOrder(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Ko-fi purchase webhook",
+		Form: []router.Param{
+			{Name: "data", Type: "string", Required: true, Description: "Ko-fi payment as JSON"},
+		},
+		Text: "Ko-fi webhook received and processed",
+	}).Post("/api/order", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Ko-fi webhook called")
+
+		if err := r.ParseForm(); err != nil {
+			log.Ctx(r.Context()).Error("Failed to parse form data: %s", err.Error())
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Failed to parse form data")
+			return
+		}
+
+		data := r.FormValue("data")
+		if data == "" {
+			log.Ctx(r.Context()).Error("Missing form data")
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Missing form data")
+			return
+		}
 
-		header := w.Header()
+		var body Kofi
+		if err := json.Unmarshal([]byte(data), &body); err != nil {
+			log.Ctx(r.Context()).Error("Failed to unmarshal JSON: %s", err.Error())
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Failed to unmarshal JSON")
+			return
+		}
 
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+		log.Redact(r.Context(), body.VerificationToken)
+		log.Ctx(r.Context()).Debug("Ko-fi %s payment of %s for user of ID %s", body.Type, body.Amount, body.DiscordUserID)
 
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
+		if token := h.cfg.Load().Kofi.VerificationToken; token == "" || token != body.VerificationToken {
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Unauthorized")
+			return
+		}
 
-			if err := r.ParseForm(); err != nil {
-				log.Error("Failed to parse form data: %s", err.Error())
-				http.Error(w, "Failed to parse form data", http.StatusBadRequest)
-				return
-			}
+		switch body.Type {
+		case KofiTypeShopOrder:
+			log.Ctx(r.Context()).Debug("Processing Ko-fi shop order for user of ID %s...", body.DiscordUserID)
 
-			log.Debug("Ko-fi values: %+v", r.Form)
+			for _, item := range body.ShopItems {
+				if b := h.getBoostReward(r.Context(), item.DirectLinkCode); b > 0 {
+					if err := h.users.AddBoosts(r.Context(), body.DiscordUserID, item.Quantity*b); err != nil {
+						log.Ctx(r.Context()).Error("Failed to add boosts: %s", err.Error())
+						router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to add boosts")
+						return
+					}
 
-			data := r.FormValue("data")
-			if data == "" {
-				log.Error("Missing form data")
-				http.Error(w, "Missing form data", http.StatusBadRequest)
-				return
+					log.Ctx(r.Context()).Info("Added %d boosts to user of ID %s", b, body.DiscordUserID)
+				}
 			}
 
-			var body Kofi
-			if err := json.Unmarshal([]byte(data), &body); err != nil {
-				log.Error("Failed to unmarshal JSON: %s", err.Error())
-				http.Error(w, "Failed to unmarshal JSON", http.StatusBadRequest)
+		case KofiTypeSubscription:
+			log.Ctx(r.Context()).Debug("Processing Ko-fi subscription for user of ID %s...", body.DiscordUserID)
+
+			user, err := h.users.Verify(r.Context(), body.DiscordUserID, body.IsSubscriptionPayment)
+			if err != nil {
+				log.Ctx(r.Context()).Error("Failed to verify user through subscription: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to verify user through subscription")
 				return
 			}
 
-			if os.Getenv("KOFI_VERIFICATION_TOKEN") != body.VerificationToken {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			err = h.users.AddBoosts(r.Context(), user.ID, h.cfg.Load().Limits.Rewards.Subscription)
+			if err != nil {
+				log.Ctx(r.Context()).Error("Failed to add boosts: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to add boosts")
 				return
 			}
 
-			switch body.Type {
-			case KofiTypeShopOrder:
-				log.Debug("Processing Ko-fi shop order for user of ID %s...", body.DiscordUserID)
-
-				for _, item := range body.ShopItems {
-					if b := getBoostReward(item.DirectLinkCode); b > 0 {
-						if err := database.AddBoostsToUser(body.DiscordUserID, item.Quantity*b); err != nil {
-							log.Error("Failed to add boosts: %s", err.Error())
-							http.Error(w, "Failed to add boosts", http.StatusInternalServerError)
-							return
-						}
-
-						log.Info("Added %d boosts to user of ID %s", b, body.DiscordUserID)
-					}
-				}
-
-			case KofiTypeSubscription:
-				log.Debug("Processing Ko-fi subscription for user of ID %s...", body.DiscordUserID)
-
-				user, err := database.VerifyUser(body.DiscordUserID, body.IsSubscriptionPayment)
-				if err != nil {
-					log.Error("Failed to verify user through subscription: %s", err.Error())
-					http.Error(w, "Failed to verify user through subscription", http.StatusInternalServerError)
-					return
-				}
-
-				err = database.AddBoostsToUser(user.ID, 3)
-				if err != nil {
-					log.Error("Failed to add boosts: %s", err.Error())
-					http.Error(w, "Failed to add boosts", http.StatusInternalServerError)
-					return
-				}
-
-				if body.IsSubscriptionPayment {
-					log.Info("Verified %s with subscription!", user.Username)
-				} else {
-					log.Warn("Unverified %s due to subscription failure", user.Username)
-				}
-
-			default:
-				log.Error("Invalid payment type")
-				http.Error(w, "Invalid payment type", http.StatusBadRequest)
-				return
+			if body.IsSubscriptionPayment {
+				log.Ctx(r.Context()).Info("Verified %s with subscription!", user.Username)
+			} else {
+				log.Ctx(r.Context()).Warn("Unverified %s due to subscription failure", user.Username)
 			}
 
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "Ko-fi webhook received and processed")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		default:
+			log.Ctx(r.Context()).Error("Invalid payment type")
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Invalid payment type")
+			return
 		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Ko-fi webhook received and processed")
 	})
 }
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"service/log"
+	"service/utils"
+)
+
+// Shortest time between two writes of the snapshot
+const snapshotEvery = time.Minute
+
+// Servable ads of the last /api/ad that reached the database, served while
+// it is unavailable. The copy on disk covers outages starting before the
+// first request, such as a restart during one.
+type pool struct {
+	mu      sync.Mutex
+	path    string // DB_SNAPSHOT
+	ads     []utils.Ad
+	written time.Time
+}
+
+// store keeps the ads, copied as the cache behind them changes
+func (p *pool) store(ads []*utils.Ad) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ads = make([]utils.Ad, len(ads))
+	for i, a := range ads {
+		p.ads[i] = *a
+	}
+
+	if p.path == "" || time.Since(p.written) < snapshotEvery {
+		return
+	}
+
+	p.written = time.Now()
+	if err := p.write(); err != nil {
+		log.Error("Failed to write the ads snapshot: %s", err.Error())
+	}
+}
+
+// write replaces the snapshot at once so a crash never leaves half of it
+func (p *pool) write() error {
+	b, err := json.Marshal(p.ads)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// load returns copies of the last servable ads, read from the snapshot when
+// none were stored since startup
+func (p *pool) load() ([]*utils.Ad, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ads) == 0 {
+		b, err := os.ReadFile(p.path)
+		if err != nil {
+			return nil, fmt.Errorf("no ads snapshot: %w", err)
+		}
+
+		if err := json.Unmarshal(b, &p.ads); err != nil {
+			return nil, fmt.Errorf("failed to parse the ads snapshot: %w", err)
+		}
+
+		log.Warn("Loaded %d ads from the snapshot taken at %s", len(p.ads), p.modified())
+	}
+
+	out := make([]*utils.Ad, len(p.ads))
+	for i := range p.ads {
+		a := p.ads[i]
+		out[i] = &a
+	}
+
+	return out, nil
+}
+
+func (p *pool) modified() string {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "an unknown time"
+	}
+
+	return info.ModTime().UTC().Format(time.RFC3339)
+}
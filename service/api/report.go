@@ -2,76 +2,75 @@ package api
 
 import (
 	"encoding/json"
-
+	"errors"
 	"net/http"
 
 	"service/access"
-	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 )
 
-func init() {
-	http.HandleFunc("/api/report", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Receiving report...")
-		header := w.Header()
+// Report sent by the mod
+type reportRequest struct {
+	AdID        int64  `json:"ad_id"`
+	AccountID   int    `json:"account_id"`
+	AuthToken   string `json:"authtoken"`
+	Description string `json:"description"`
+}
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
+func (h *Handler) registerReport(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Report an ad from the game",
+		Body:    reportRequest{},
+	}).Post("/api/report", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Receiving report...")
 
-		if r.Method == http.MethodPost {
-			header.Set("Content-Type", "application/json")
+		var body reportRequest
 
-			var body struct {
-				AdID        int64  `json:"ad_id"`
-				AccountID   int    `json:"account_id"`
-				AuthToken   string `json:"authtoken"`
-				Description string `json:"description"`
-			}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			log.Ctx(r.Context()).Error("Failed to parse JSON body: %s", err.Error())
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Failed to parse JSON body")
+			return
+		}
 
-			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-				log.Error("Failed to parse JSON body: %s", err.Error())
-				http.Error(w, "Failed to parse JSON body", http.StatusInternalServerError)
-				return
-			}
+		user := &utils.ArgonUser{Account: body.AccountID, Token: body.AuthToken}
+		valid, err := h.auth.ValidateArgonUser(r.Context(), user)
+		if errors.Is(err, access.ErrArgonInvalid) {
+			log.Ctx(r.Context()).Error("Failed to validate Argon user: %s", err.Error())
+			router.Error(w, r, http.StatusUnauthorized, router.CodeArgonInvalid, "Invalid Argon user")
+			return
+		} else if err != nil {
+			log.Ctx(r.Context()).Error("Failed to validate Argon user: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Failed to validate Argon user")
+			return
+		}
 
-			user := &utils.ArgonUser{Account: body.AccountID, Token: body.AuthToken}
-			valid, err := access.ValidateArgonUser(user)
+		if valid {
+			user, err = h.auth.GetArgonUser(r.Context(), body.AccountID)
 			if err != nil {
-				log.Error("Failed to validate Argon user: %s", err.Error())
-				http.Error(w, "Failed to validate Argon user", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error("Failed to check for Argon user: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to check for Argon user")
 				return
 			}
 
-			if valid {
-				user, err = access.GetArgonUser(body.AccountID)
-				if err != nil {
-					log.Error("Failed to check for Argon user: %s", err.Error())
-					http.Error(w, "Failed to check for Argon user", http.StatusInternalServerError)
-					return
-				}
-
-				if user.ReportBanned {
-					log.Warn("Argon user %s attempted to report ad of ID %d while banned", user.Account, body.AdID)
-					http.Error(w, "Banned from ad reporting", http.StatusForbidden)
-					return
-				}
-
-				err = database.NewReport(body.AdID, body.AccountID, body.Description)
-				if err != nil {
-					log.Error("Failed to create report: %s", err.Error())
-					http.Error(w, "Failed to create report", http.StatusInternalServerError)
-					return
-				}
+			if user.ReportBanned {
+				log.Ctx(r.Context()).Warn("Argon user %s attempted to report ad of ID %d while banned", user.Account, body.AdID)
+				router.Error(w, r, http.StatusForbidden, router.CodeReportBanned, "Banned from ad reporting")
+				return
+			}
 
-				log.Info("Registered report for ad of ID %d", body.AdID)
-			} else {
-				http.Error(w, "Invalid Argon user", http.StatusUnauthorized)
+			err = h.reports.Create(r.Context(), body.AdID, body.AccountID, body.Description)
+			if err != nil {
+				log.Ctx(r.Context()).Error("Failed to create report: %s", err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to create report")
 				return
 			}
+
+			log.Ctx(r.Context()).Info("Registered report for ad of ID %d", body.AdID)
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			router.Error(w, r, http.StatusUnauthorized, router.CodeArgonInvalid, "Invalid Argon user")
+			return
 		}
 	})
 }
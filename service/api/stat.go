@@ -2,93 +2,93 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"service/access"
-	"service/database"
 	"service/log"
+	"service/router"
 	"service/utils"
 )
 
-func newStat(r *http.Request, adEvent utils.AdEvent) (int, error) {
-	var body struct {
-		AdID      int64  `json:"ad_id"`
-		AccountID int    `json:"account_id"`
-		AuthToken string `json:"authtoken"`
-	}
+// Player event sent by the mod
+type eventRequest struct {
+	AdID      int64  `json:"ad_id"`
+	AccountID int    `json:"account_id"`
+	AuthToken string `json:"authtoken"`
+}
+
+// newStat records an event for the player in the body, returning the status
+// and error code to reply with when it fails
+func (h *Handler) newStat(r *http.Request, adEvent utils.AdEvent) (int, router.Code, error) {
+	var body eventRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		log.Error("Failed to parse JSON body: %s", err.Error())
-		return http.StatusInternalServerError, err
+		log.Ctx(r.Context()).Error("Failed to parse JSON body: %s", err.Error())
+		return http.StatusBadRequest, router.CodeBadRequest, err
 	}
 
-	log.Debug("Body decoded - AdID: %v", body.AdID)
+	log.Ctx(r.Context()).Debug("Body decoded - AdID: %v", body.AdID)
 
 	user := &utils.ArgonUser{Account: body.AccountID, Token: body.AuthToken}
-	valid, err := access.ValidateArgonUser(user)
-	if err != nil {
-		log.Error("Failed to validate Argon user: %s", err.Error())
-		return http.StatusInternalServerError, err
+	valid, err := h.auth.ValidateArgonUser(r.Context(), user)
+	if errors.Is(err, access.ErrArgonInvalid) {
+		log.Ctx(r.Context()).Error("Failed to validate Argon user: %s", err.Error())
+		return http.StatusUnauthorized, router.CodeArgonInvalid, err
+	} else if err != nil {
+		log.Ctx(r.Context()).Error("Failed to validate Argon user: %s", err.Error())
+		return http.StatusInternalServerError, router.CodeUpstreamFailed, err
 	}
 
 	if valid {
-		err := database.NewStat(adEvent, body.AdID)
+		err := h.ads.NewStat(r.Context(), adEvent, body.AdID)
 		if err != nil {
-			log.Error("Failed to create database click statistic: %s", err.Error())
-			return http.StatusInternalServerError, err
+			log.Ctx(r.Context()).Error("Failed to create database click statistic: %s", err.Error())
+			return http.StatusInternalServerError, router.CodeInternal, err
 		}
 
-		log.Info("%s passed for player %d", adEvent, body.AccountID)
+		log.Ctx(r.Context()).Info("%s passed for player %d", adEvent, body.AccountID)
+		adEvents.Inc(string(adEvent))
 	} else {
-		return http.StatusUnauthorized, fmt.Errorf("argon user invalid")
+		return http.StatusUnauthorized, router.CodeArgonInvalid, access.ErrArgonInvalid
 	}
 
-	return http.StatusOK, nil
+	return http.StatusOK, "", nil
 }
 
-func init() {
-	http.HandleFunc("/api/click", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Registering click...")
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			status, err := newStat(r, utils.AdEventClick)
-			if err != nil {
-				http.Error(w, "Failed to register click statistic", status)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "Click registered!")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *Handler) registerStats(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Record a click on an ad",
+		Body:    eventRequest{},
+		Text:    "Click registered!",
+	}).Post("/api/click", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Registering click...")
+
+		status, code, err := h.newStat(r, utils.AdEventClick)
+		if err != nil {
+			router.Error(w, r, status, code, "Failed to register click statistic")
+			return
 		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Click registered!")
 	})
 
-	http.HandleFunc("/api/view", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Registering view...")
-		header := w.Header()
-
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			status, err := newStat(r, utils.AdEventView)
-			if err != nil {
-				http.Error(w, "Failed to register view statistic", status)
-				return
-			}
-
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprint(w, "View registered!")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	rt.Doc(router.Operation{
+		Summary: "Record a view of an ad",
+		Body:    eventRequest{},
+		Text:    "View registered!",
+	}).Post("/api/view", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Registering view...")
+
+		status, code, err := h.newStat(r, utils.AdEventView)
+		if err != nil {
+			router.Error(w, r, status, code, "Failed to register view statistic")
+			return
 		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "View registered!")
 	})
 }
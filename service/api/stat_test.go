@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+func TestEvents(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.AddUser(utils.User{ID: "1", Username: "owner"})
+	ad := ts.mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1})
+
+	for _, path := range []string{"/api/view", "/api/click"} {
+		t.Run(path, func(t *testing.T) {
+			resp, body := ts.Post(t, path, eventRequest{AdID: ad.AdID, AccountID: playerAccount, AuthToken: playerToken})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+			}
+
+			resp, body = ts.Post(t, path, eventRequest{AdID: ad.AdID, AccountID: playerAccount + 1, AuthToken: "forged"})
+			routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeArgonInvalid)
+
+			resp, body = ts.Post(t, path, []byte("{"))
+			routertest.ExpectError(t, resp, body, http.StatusBadRequest, router.CodeBadRequest)
+		})
+	}
+
+	views, clicks, err := ts.repos.Ads.Stats(t.Context(), ad.AdID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if views != 1 || clicks != 1 {
+		t.Errorf("got %d views and %d clicks, expected one of each", views, clicks)
+	}
+}
+
+func TestReport(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.mem.AddUser(utils.User{ID: "1", Username: "owner"})
+	ad := ts.mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1})
+
+	report := reportRequest{AdID: ad.AdID, AccountID: playerAccount, AuthToken: playerToken, Description: "Offensive"}
+
+	t.Run("from a valid player", func(t *testing.T) {
+		resp, body := ts.Post(t, "/api/report", report)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+		}
+
+		reports, err := ts.repos.Reports.List(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(reports) != 1 || reports[0].Ad.AdID != ad.AdID || reports[0].Description != "Offensive" {
+			t.Errorf("got reports %+v, expected the one sent", reports)
+		}
+	})
+
+	t.Run("with a forged token", func(t *testing.T) {
+		forged := report
+		forged.AccountID = playerAccount + 1
+		forged.AuthToken = "forged"
+
+		resp, body := ts.Post(t, "/api/report", forged)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeArgonInvalid)
+	})
+
+	t.Run("from a player banned from reporting", func(t *testing.T) {
+		if err := ts.repos.Argon.SetReportBanned(t.Context(), playerAccount, true); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, body := ts.Post(t, "/api/report", report)
+		routertest.ExpectError(t, resp, body, http.StatusForbidden, router.CodeReportBanned)
+	})
+}
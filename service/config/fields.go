@@ -0,0 +1,210 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration reads as a Go duration string such as "5s" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+// A single setting found by walking the Config struct
+type field struct {
+	env      string        // Environment variable overriding it
+	path     string        // Dotted JSON path in the config file
+	secret   bool          // Masked in reports
+	required string        // "true" or "production"
+	value    reflect.Value // Settable value
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+func (c *Config) fields() []field {
+	var out []field
+	walk(reflect.ValueOf(c).Elem(), "", "", &out)
+
+	return out
+}
+
+// walk collects the settings of a struct. The env tag of a nested struct is a
+// suffix appended to the variables of its fields, so the same struct can
+// appear several times.
+func walk(v reflect.Value, prefix string, suffix string, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		path := prefix + name
+
+		fv := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			nested := suffix
+			if s := sf.Tag.Get("env"); s != "" {
+				nested += "_" + s
+			}
+
+			walk(fv, path+".", nested, out)
+			continue
+		}
+
+		env := sf.Tag.Get("env") + suffix
+
+		*out = append(*out, field{
+			env:      env,
+			path:     path,
+			secret:   sf.Tag.Get("secret") == "true",
+			required: sf.Tag.Get("required"),
+			value:    fv,
+		})
+	}
+}
+
+// markChanged records source as the origin of every setting differing from before
+func (c *Config) markChanged(before *Config, source string) {
+	prev := before.fields()
+	for i, f := range c.fields() {
+		if !reflect.DeepEqual(f.value.Interface(), prev[i].value.Interface()) {
+			c.sources[f.env] = source
+		}
+	}
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+	for _, f := range c.fields() {
+		raw, found := os.LookupEnv(f.env)
+		if !found || raw == "" {
+			continue
+		}
+
+		if err := set(f.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			continue
+		}
+
+		c.sources[f.env] = "env"
+	}
+
+	return errors.Join(errs...)
+}
+
+func set(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+
+		v.Set(reflect.ValueOf(Duration{d}))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(n)
+
+	case reflect.Uint:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(n)
+
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", v.Type())
+		}
+
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(list))
+
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+
+	return nil
+}
+
+// normalize resolves driver aliases and trims trailing slashes off base URLs
+// so paths can be appended, and off origins so they compare as sent by browsers
+func (c *Config) normalize() {
+	switch driver := strings.ToLower(strings.TrimSpace(c.Database.Driver)); driver {
+	case "", "mariadb":
+		c.Database.Driver = "mysql"
+	case "sqlite3":
+		c.Database.Driver = "sqlite"
+	default:
+		c.Database.Driver = driver
+	}
+
+	for i, o := range c.CORS.AllowedOrigins {
+		c.CORS.AllowedOrigins[i] = strings.TrimRight(strings.TrimSpace(o), "/")
+	}
+
+	e := &c.Endpoints
+	for _, u := range []*string{&e.Discord, &e.DiscordCDN, &e.Argon, &e.Geode, &e.Boomlings} {
+		*u = strings.TrimRight(*u, "/")
+	}
+}
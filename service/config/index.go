@@ -0,0 +1,292 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Default location of the optional config file, relative to the working directory
+const DefaultPath = "../config.json"
+
+// Settings of the whole service. Every field can be set in the JSON config
+// file and overridden by the environment variable named in its env tag.
+// Sections tagged reload:"true" can change without a restart, see Store.
+type Config struct {
+	Env       string     `json:"env" env:"ENV"`                           // "production" enables secure cookies and strict validation
+	WebPort   string     `json:"web_port" env:"WEB_PORT"`                 // Port the HTTP server listens on
+	LogLevel  int        `json:"log_level" env:"LOG_LEVEL" reload:"true"` // 0 debug, 1 info, 2 warn, 3 error, 4 done, 5 plain
+	Log       Log        `json:"log" reload:"true"`                       // Output format and sinks
+	Database  Database   `json:"database"`                                // SQL connection
+	Discord   Discord    `json:"discord"`                                 // OAuth application and webhooks
+	Argon     Argon      `json:"argon"`                                   // Mod player validation
+	Kofi      Kofi       `json:"kofi"`                                    // Ko-fi purchases
+	Endpoints Endpoints  `json:"endpoints"`                               // Base URLs of external services
+	Limits    Limits     `json:"limits" reload:"true"`                    // Business rules
+	Features  Features   `json:"features" reload:"true"`                  // Switches for whole features
+	Selection Selection  `json:"selection" reload:"true"`                 // Weights picking the ad served by /api/ad
+	CORS      CORS       `json:"cors" reload:"true"`                      // Cross-origin browser access
+	Metrics   Metrics    `json:"metrics" reload:"true"`                   // Prometheus scraping
+	Jobs      Jobs       `json:"jobs"`                                    // Schedules of the background jobs
+	TLS       TLS        `json:"tls"`                                     // Native HTTPS
+	Headers   Headers    `json:"headers" reload:"true"`                   // Security headers of the dashboard
+	Proxies   Proxies    `json:"proxies" reload:"true"`                   // Reverse proxies in front of the service
+	RateLimit RateLimits `json:"rate_limit" reload:"true"`                // Requests allowed per client
+	CDN       CDN        `json:"cdn"`                                     // Ad images under /cdn/
+	sources   map[string]string
+}
+
+// Forwarding headers, X-Forwarded-For and CF-Connecting-IP, are only
+// believed when sent by one of these proxies
+type Proxies struct {
+	Trusted []string `json:"trusted" env:"TRUSTED_PROXIES"` // CIDRs or addresses, comma separated in the environment
+}
+
+// Token buckets holding Burst requests, refilled at Rate per second. Every
+// request counts against Default for its client IP, except ad images which
+// have their own per IP budget. Events and Submit count on top of it.
+type RateLimits struct {
+	Default RateLimit `json:"default"`             // Per client IP
+	Events  RateLimit `json:"events" env:"EVENTS"` // /api/view and /api/click, per client IP and Argon account
+	Submit  RateLimit `json:"submit" env:"SUBMIT"` // /ads/submit, per Discord user
+	CDN     RateLimit `json:"cdn" env:"CDN"`       // Ad images under /cdn/, per client IP
+}
+
+type RateLimit struct {
+	Rate  float64 `json:"rate" env:"RATE_LIMIT"`  // Requests per second
+	Burst int     `json:"burst" env:"RATE_BURST"` // Requests at once
+}
+
+// Resized and re-encoded variants of the ad images, asked for with ?w=, ?h=
+// and ?fmt=, are kept in memory until the cache holds VariantCache bytes.
+// Images of pending ads are only served to their owner and staff, or through
+// links signed with SigningKey such as those of the staff webhook.
+type CDN struct {
+	VariantCache int64    `json:"variant_cache" env:"CDN_VARIANT_CACHE"`           // Bytes of variants kept around, 0 disables caching
+	SigningKey   string   `json:"signing_key" env:"CDN_SIGNING_KEY" secret:"true"` // Key of signed image links, derived from DISCORD_CLIENT_SECRET when empty
+	SignedFor    Duration `json:"signed_for" env:"CDN_SIGNED_FOR"`                 // Lifetime of signed image links
+}
+
+// Native HTTPS for deployments without a reverse proxy. The files are read
+// again whenever they change, as renewals replace them.
+type TLS struct {
+	Cert         string   `json:"cert" env:"TLS_CERT"`                   // PEM certificate chain, serving HTTPS on WEB_PORT when set
+	Key          string   `json:"key" env:"TLS_KEY"`                     // PEM private key
+	RedirectPort string   `json:"redirect_port" env:"TLS_REDIRECT_PORT"` // Plain HTTP port redirecting to HTTPS, none when empty
+	CheckEvery   Duration `json:"check_every" env:"TLS_CHECK_EVERY"`     // How often the files are checked for a renewal
+}
+
+// Sent with the dashboard pages, HSTS only over HTTPS
+type Headers struct {
+	HSTS Duration `json:"hsts" env:"HEADERS_HSTS"` // max-age of Strict-Transport-Security, omitted when 0
+	CSP  string   `json:"csp" env:"HEADERS_CSP"`   // Content-Security-Policy, omitted when empty
+}
+
+// Schedules are a duration like "12h" or a five field cron spec like
+// "0 4 * * *", in the local time zone
+type Jobs struct {
+	AdExpiry       string `json:"ad_expiry" env:"JOBS_AD_EXPIRY"`             // Deleting expired ads
+	SessionCleanup string `json:"session_cleanup" env:"JOBS_SESSION_CLEANUP"` // Deleting expired sessions
+}
+
+// Scrapers send the token as "Authorization: Bearer <token>". Without a
+// token the endpoint is open, which production does not allow.
+type Metrics struct {
+	Enabled bool   `json:"enabled" env:"METRICS_ENABLED"`           // Serve /metrics
+	Token   string `json:"token" env:"METRICS_TOKEN" secret:"true"` // Bearer token of scrapers
+}
+
+// Origins are written like https://ads.example.com, without a path. The site
+// itself never needs listing.
+type CORS struct {
+	AllowedOrigins []string `json:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"` // Origins that may call the API with the session cookie, comma separated in the environment
+}
+
+type Features struct {
+	Submissions bool `json:"submissions" env:"FEATURE_SUBMISSIONS"` // Accept new ads at /ads/submit
+}
+
+// Every ad starts at Base weight, then gains the following bonuses
+type Selection struct {
+	Base           float64  `json:"base" env:"SELECTION_BASE"`                         // Starting weight
+	PerBoost       float64  `json:"per_boost" env:"SELECTION_PER_BOOST"`               // Per boost on the ad
+	Verified       float64  `json:"verified" env:"SELECTION_VERIFIED"`                 // Owner is verified
+	Fresh          float64  `json:"fresh" env:"SELECTION_FRESH"`                       // Young ads, decaying with clicks
+	FreshWindow    Duration `json:"fresh_window" env:"SELECTION_FRESH_WINDOW"`         // Age under which an ad is fresh
+	FreshDecay     float64  `json:"fresh_decay" env:"SELECTION_FRESH_DECAY"`           // Share of global clicks decaying the fresh bonus by 1/e
+	MatureAfter    Duration `json:"mature_after" env:"SELECTION_MATURE_AFTER"`         // Age after which the smoothed click rate counts
+	MatureCap      float64  `json:"mature_cap" env:"SELECTION_MATURE_CAP"`             // Upper bound of the smoothed click rate bonus
+	ClickRate      float64  `json:"click_rate" env:"SELECTION_CLICK_RATE"`             // Multiplier of the ad click rate
+	OwnerClickRate float64  `json:"owner_click_rate" env:"SELECTION_OWNER_CLICK_RATE"` // Multiplier of the owner's click rate
+}
+
+type Database struct {
+	Driver          string   `json:"driver" env:"DB_DRIVER"`                         // mysql or sqlite
+	Host            string   `json:"host" env:"DB_HOST"`                             // MySQL host:port
+	User            string   `json:"user" env:"DB_USER"`                             // MySQL user
+	Pass            string   `json:"pass" env:"DB_PASS" secret:"true"`               // MySQL password
+	Name            string   `json:"name" env:"DB_NAME"`                             // MySQL schema
+	Path            string   `json:"path" env:"DB_PATH"`                             // SQLite file
+	AutoMigrate     bool     `json:"auto_migrate" env:"DB_AUTO_MIGRATE"`             // Apply migrations on startup
+	QueryTimeout    Duration `json:"query_timeout" env:"DB_QUERY_TIMEOUT"`           // Upper bound of a single data-access call
+	MaxOpenConns    int      `json:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`         // Pool size
+	MaxIdleConns    int      `json:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`         // Idle connections kept around
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`   // Recycle connections after
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"` // Close idle connections after
+	ConnectAttempts int      `json:"connect_attempts" env:"DB_CONNECT_ATTEMPTS"`     // Pings at startup before starting degraded
+	ConnectBackoff  Duration `json:"connect_backoff" env:"DB_CONNECT_BACKOFF"`       // Wait after the first failed ping, doubling up to 30s
+	HealthInterval  Duration `json:"health_interval" env:"DB_HEALTH_INTERVAL"`       // Pings detecting outages and recoveries
+	Snapshot        string   `json:"snapshot" env:"DB_SNAPSHOT"`                     // Servable ads kept on disk for outages
+	Spool           string   `json:"spool" env:"DB_SPOOL"`                           // Views and clicks recorded during outages
+}
+
+type Discord struct {
+	ClientID     string  `json:"client_id" env:"DISCORD_CLIENT_ID" required:"true"`
+	ClientSecret string  `json:"client_secret" env:"DISCORD_CLIENT_SECRET" secret:"true" required:"true"`
+	RedirectURI  string  `json:"redirect_uri" env:"DISCORD_REDIRECT_URI" required:"true"`
+	Webhook      Webhook `json:"webhook"`                   // Public announcements channel
+	StaffWebhook Webhook `json:"staff_webhook" env:"STAFF"` // Staff review channel
+
+	// Where /login?redirect= may send users once logged in: paths of the site
+	// like /dashboard, or absolute URLs like https://ads.example.com/admin.
+	// Each entry also covers what lies below it.
+	LoginRedirects []string `json:"login_redirects" env:"DISCORD_LOGIN_REDIRECTS"`
+}
+
+type Webhook struct {
+	ID    string `json:"id" env:"DISCORD_WH_ID" required:"production"`
+	Token string `json:"token" env:"DISCORD_WH_TOKEN" secret:"true" required:"production"`
+}
+
+type Argon struct {
+	Token string `json:"token" env:"ARGON" secret:"true" required:"production"` // Server API token
+}
+
+type Kofi struct {
+	VerificationToken  string `json:"verification_token" env:"KOFI_VERIFICATION_TOKEN" secret:"true" required:"production"`
+	LinkBoost          string `json:"link_boost" env:"KOFI_LINK_BOOST" required:"production"`                     // Shop item granting 5 boosts
+	LinkBoostOverdrive string `json:"link_boost_overdrive" env:"KOFI_LINK_BOOST_OVERDRIVE" required:"production"` // Shop item granting 50 boosts
+}
+
+type Endpoints struct {
+	Discord    string `json:"discord" env:"DISCORD_URL"`         // OAuth, REST API and webhooks
+	DiscordCDN string `json:"discord_cdn" env:"DISCORD_CDN_URL"` // User avatars
+	Argon      string `json:"argon" env:"ARGON_URL"`             // Account validation
+	Geode      string `json:"geode" env:"GEODE_URL"`             // Mod index
+	Boomlings  string `json:"boomlings" env:"BOOMLINGS_URL"`     // Level servers
+}
+
+// Default returns the settings used when neither the file nor the environment sets them
+func Default() *Config {
+	return &Config{
+		WebPort:  "3000",
+		LogLevel: 0,
+		Log:      defaultLog(),
+		Database: Database{
+			Driver:          "mysql",
+			Path:            filepath.Join("..", "gd-ads.db"),
+			AutoMigrate:     true,
+			QueryTimeout:    Duration{5 * time.Second},
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration{5 * time.Minute},
+			ConnMaxIdleTime: Duration{time.Minute},
+			ConnectAttempts: 6,
+			ConnectBackoff:  Duration{time.Second},
+			HealthInterval:  Duration{5 * time.Second},
+			Snapshot:        filepath.Join("..", "ads-snapshot.json"),
+			Spool:           filepath.Join("..", "events-spool.jsonl"),
+		},
+		Discord: Discord{LoginRedirects: []string{"/dashboard", "/admin"}},
+		Endpoints: Endpoints{
+			Discord:    "https://discord.com",
+			DiscordCDN: "https://cdn.discordapp.com",
+			Argon:      "https://argon.globed.dev",
+			Geode:      "https://api.geode-sdk.org",
+			Boomlings:  "https://www.boomlings.com",
+		},
+		Jobs: Jobs{AdExpiry: "12h", SessionCleanup: "3h"},
+		TLS:  TLS{CheckEvery: Duration{time.Minute}},
+		Headers: Headers{
+			HSTS: Duration{365 * 24 * time.Hour},
+			// the hash allows the ad blocker notice inlined in index.html
+			CSP: "default-src 'self'; script-src 'self' 'sha256-8gsSdebl9gwWoBdYPlKXmeljOgdYgsS05r8VqeVzZIo='; img-src 'self' data: https://cdn.discordapp.com https://avatars.githubusercontent.com; " +
+				"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; font-src 'self' https://fonts.gstatic.com; " +
+				"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+		},
+		RateLimit: RateLimits{
+			Default: RateLimit{Rate: 10, Burst: 30},
+			Events:  RateLimit{Rate: 0.5, Burst: 10},
+			Submit:  RateLimit{Rate: 0.05, Burst: 3},
+			CDN:     RateLimit{Rate: 50, Burst: 100},
+		},
+		CDN:      CDN{VariantCache: 64 << 20, SignedFor: Duration{7 * 24 * time.Hour}},
+		Limits:   defaultLimits(),
+		Features: Features{Submissions: true},
+		Selection: Selection{
+			Base:           1,
+			PerBoost:       1,
+			Verified:       3,
+			Fresh:          3,
+			FreshWindow:    Duration{60 * time.Hour},
+			FreshDecay:     0.025,
+			MatureAfter:    Duration{24 * time.Hour},
+			MatureCap:      2,
+			ClickRate:      10,
+			OwnerClickRate: 1,
+		},
+		sources: make(map[string]string),
+	}
+}
+
+// Load reads the defaults, then the JSON file at path if it exists, then the
+// environment. The file is required only when explicitly asked for through
+// CONFIG_FILE. The result still has to be validated.
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if p := os.Getenv("CONFIG_FILE"); p != "" {
+		if err := c.readFile(p); err != nil {
+			return nil, err
+		}
+	} else if path != "" {
+		if _, err := os.Stat(path); err == nil {
+			if err := c.readFile(path); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	c.normalize()
+
+	return c, nil
+}
+
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	before := *c
+	if err := json.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	c.markChanged(&before, "file "+path)
+	return nil
+}
+
+// Production reports whether the service runs with ENV=production
+func (c *Config) Production() bool {
+	return c.Env == "production"
+}
@@ -0,0 +1,77 @@
+package config
+
+import "time"
+
+// Business rules of the service, published read-only at /api/limits so the
+// frontend and the mod don't have to duplicate them
+type Limits struct {
+	Rewards Rewards `json:"rewards"` // Boosts granted by Ko-fi purchases
+	Glow    Glow    `json:"glow"`    // Highlighting of served ads
+	Tiers   Tiers   `json:"tiers"`   // Per-account allowances
+}
+
+type Rewards struct {
+	Boost          uint `json:"boost" env:"KOFI_REWARD_BOOST"`                     // Per KOFI_LINK_BOOST item
+	BoostOverdrive uint `json:"boost_overdrive" env:"KOFI_REWARD_BOOST_OVERDRIVE"` // Per KOFI_LINK_BOOST_OVERDRIVE item
+	Subscription   uint `json:"subscription" env:"KOFI_REWARD_SUBSCRIPTION"`       // Per subscription payment
+}
+
+// Boost counts an ad needs to exceed for each glow level. Ads of verified
+// owners glow at level 2 regardless.
+type Glow struct {
+	Boosted   uint `json:"boosted" env:"LIMIT_GLOW_BOOSTED"`     // Level 1
+	Overdrive uint `json:"overdrive" env:"LIMIT_GLOW_OVERDRIVE"` // Level 3
+}
+
+type Tiers struct {
+	Normal   Tier `json:"normal" env:"NORMAL"`
+	Verified Tier `json:"verified" env:"VERIFIED"` // Ko-fi subscribers
+	Staff    Tier `json:"staff" env:"STAFF"`       // Staff and admins
+}
+
+// Allowances of the accounts in a tier. Ad lifetime and boosts follow the
+// tier of the ad's owner.
+type Tier struct {
+	MaxActiveAds   int      `json:"max_active_ads" env:"LIMIT_MAX_ACTIVE_ADS"`     // Unexpired ads an account can have
+	MaxUploadBytes int64    `json:"max_upload_bytes" env:"LIMIT_MAX_UPLOAD_BYTES"` // Size of a submitted image
+	AdLifetime     Duration `json:"ad_lifetime" env:"LIMIT_AD_LIFETIME"`           // Ads expire this long after submission
+	MaxAdBoosts    uint     `json:"max_ad_boosts" env:"LIMIT_MAX_AD_BOOSTS"`       // Boosts a single ad can hold
+}
+
+func defaultLimits() Limits {
+	lifetime := Duration{14 * 24 * time.Hour}
+	tier := Tier{MaxActiveAds: 8, MaxUploadBytes: 10 << 20, AdLifetime: lifetime, MaxAdBoosts: 30}
+	privileged := Tier{MaxActiveAds: 20, MaxUploadBytes: 10 << 20, AdLifetime: lifetime, MaxAdBoosts: 30}
+
+	return Limits{
+		Rewards: Rewards{Boost: 5, BoostOverdrive: 50, Subscription: 3},
+		Glow:    Glow{Boosted: 0, Overdrive: 15},
+		Tiers:   Tiers{Normal: tier, Verified: privileged, Staff: privileged},
+	}
+}
+
+// Tier picks the allowances of an account, staff taking precedence over verified
+func (l *Limits) Tier(staff bool, verified bool) Tier {
+	switch {
+	case staff:
+		return l.Tiers.Staff
+	case verified:
+		return l.Tiers.Verified
+	default:
+		return l.Tiers.Normal
+	}
+}
+
+// GlowLevel of an ad with the given boosts
+func (l *Limits) GlowLevel(boosts uint, verified bool) uint {
+	switch {
+	case boosts > l.Glow.Overdrive:
+		return 3
+	case verified:
+		return 2
+	case boosts > l.Glow.Boosted:
+		return 1
+	default:
+		return 0
+	}
+}
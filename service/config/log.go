@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"service/log"
+)
+
+// Logs always go to stdout, and also to File when it is set
+type Log struct {
+	Format   string   `json:"format" env:"LOG_FORMAT"`       // text or json
+	File     string   `json:"file" env:"LOG_FILE"`           // Log file, rotated by size
+	MaxSize  int64    `json:"max_size" env:"LOG_MAX_SIZE"`   // Bytes written before the file rotates
+	MaxFiles int      `json:"max_files" env:"LOG_MAX_FILES"` // Rotated files kept next to it
+	Packages []string `json:"packages" env:"LOG_PACKAGES"`   // Levels of single packages like database=debug, comma separated in the environment
+}
+
+func defaultLog() Log {
+	return Log{Format: "text", MaxSize: 10 << 20, MaxFiles: 5}
+}
+
+// Options converts the section for log.Configure
+func (l *Log) Options() (log.Options, error) {
+	opts := log.Options{
+		Format:   l.Format,
+		File:     l.File,
+		MaxSize:  l.MaxSize,
+		MaxFiles: l.MaxFiles,
+		Packages: make(map[string]int),
+	}
+
+	for _, entry := range l.Packages {
+		pkg, name, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(pkg) == "" {
+			return opts, fmt.Errorf("LOG_PACKAGES entry %q must look like package=level", entry)
+		}
+
+		level, err := log.ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return opts, fmt.Errorf("LOG_PACKAGES entry %q: %w", entry, err)
+		}
+
+		opts.Packages[strings.TrimSpace(pkg)] = level
+	}
+
+	return opts, nil
+}
+
+// ApplyLog sets LOG_LEVEL and the sinks of the log package, which masks
+// the settings tagged secret:"true" wherever they show up
+func (c *Config) ApplyLog() error {
+	log.SetLevel(c.LogLevel)
+
+	var secrets []string
+	for _, f := range c.fields() {
+		if f.secret {
+			secrets = append(secrets, f.value.String())
+		}
+	}
+
+	log.Secrets(secrets...)
+
+	opts, err := c.Log.Options()
+	if err != nil {
+		return err
+	}
+
+	return log.Configure(opts)
+}
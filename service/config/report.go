@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"service/jobs"
+)
+
+// Validate reports every missing or invalid setting at once. Settings tagged
+// required:"production" are only enforced with ENV=production.
+func (c *Config) Validate() error {
+	errs := c.database()
+	for _, f := range c.fields() {
+		if !f.value.IsZero() {
+			continue
+		}
+
+		if f.required == "true" || (f.required == "production" && c.Production()) {
+			errs = append(errs, fmt.Errorf("%s (%s) is required", f.env, f.path))
+		}
+	}
+
+	if c.LogLevel < 0 || c.LogLevel > 5 {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be between 0 and 5, got %d", c.LogLevel))
+	}
+
+	if f := c.Log.Format; f != "text" && f != "json" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", f))
+	}
+
+	if _, err := c.Log.Options(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Log.File != "" && (c.Log.MaxSize <= 0 || c.Log.MaxFiles <= 0) {
+		errs = append(errs, fmt.Errorf("LOG_MAX_SIZE and LOG_MAX_FILES must be positive"))
+	}
+
+	if d := c.Database; d.ConnectAttempts <= 0 || d.ConnectBackoff.Duration <= 0 || d.HealthInterval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("DB_CONNECT_ATTEMPTS, DB_CONNECT_BACKOFF and DB_HEALTH_INTERVAL must be positive"))
+	}
+
+	for _, f := range c.fields() {
+		if strings.HasPrefix(f.path, "limits.tiers.") && !positive(f.value) {
+			errs = append(errs, fmt.Errorf("%s (%s) must be positive", f.env, f.path))
+		}
+	}
+
+	if c.Metrics.Enabled && c.Metrics.Token == "" && c.Production() {
+		errs = append(errs, fmt.Errorf("METRICS_TOKEN is required to enable METRICS_ENABLED in production"))
+	}
+
+	for _, f := range c.fields() {
+		if strings.HasPrefix(f.path, "jobs.") {
+			if _, err := jobs.Parse(f.value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("%s (%s): %w", f.env, f.path, err))
+			}
+		}
+	}
+
+	if t := c.TLS; (t.Cert == "") != (t.Key == "") {
+		errs = append(errs, fmt.Errorf("TLS_CERT and TLS_KEY must be set together"))
+	} else if t.RedirectPort != "" && (t.Cert == "" || t.RedirectPort == c.WebPort) {
+		errs = append(errs, fmt.Errorf("TLS_REDIRECT_PORT needs TLS_CERT and a port other than WEB_PORT"))
+	}
+
+	if c.TLS.CheckEvery.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("TLS_CHECK_EVERY must be positive"))
+	}
+
+	if c.CDN.VariantCache < 0 {
+		errs = append(errs, fmt.Errorf("CDN_VARIANT_CACHE must not be negative"))
+	}
+
+	if c.CDN.SignedFor.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("CDN_SIGNED_FOR must be positive"))
+	}
+
+	if c.Headers.HSTS.Duration < 0 {
+		errs = append(errs, fmt.Errorf("HEADERS_HSTS must not be negative"))
+	}
+
+	for _, p := range c.Proxies.Trusted {
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				errs = append(errs, fmt.Errorf("TRUSTED_PROXIES entry %q must be a CIDR or an address", p))
+			}
+		}
+	}
+
+	for _, f := range c.fields() {
+		if !strings.HasPrefix(f.path, "rate_limit.") {
+			continue
+		}
+
+		if (f.value.CanFloat() && f.value.Float() <= 0) || (f.value.CanInt() && f.value.Int() <= 0) {
+			errs = append(errs, fmt.Errorf("%s (%s) must be positive", f.env, f.path))
+		}
+	}
+
+	for _, target := range c.Discord.LoginRedirects {
+		u, err := url.Parse(target)
+		if err == nil && u.RawQuery == "" && u.Fragment == "" {
+			if u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") {
+				continue
+			}
+
+			if (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+				continue
+			}
+		}
+
+		errs = append(errs, fmt.Errorf("DISCORD_LOGIN_REDIRECTS entry %q must be a path like /dashboard or a URL like https://example.com/dashboard", target))
+	}
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS entry %q must be an origin like https://example.com", o))
+		}
+	}
+
+	return joined(errs)
+}
+
+// ValidateDatabase only checks the settings needed to connect, for tools such
+// as the migrate command
+func (c *Config) ValidateDatabase() error {
+	return joined(c.database())
+}
+
+func (c *Config) database() []error {
+	var errs []error
+
+	d := c.Database
+	switch d.Driver {
+	case "mysql":
+		for _, v := range [][2]string{{"DB_HOST", d.Host}, {"DB_USER", d.User}, {"DB_NAME", d.Name}} {
+			if v[1] == "" {
+				errs = append(errs, fmt.Errorf("%s is required by the mysql driver", v[0]))
+			}
+		}
+
+	case "sqlite":
+		if d.Path == "" {
+			errs = append(errs, fmt.Errorf("DB_PATH is required by the sqlite driver"))
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("unsupported DB_DRIVER %q", d.Driver))
+	}
+
+	if d.QueryTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("DB_QUERY_TIMEOUT must be positive"))
+	}
+
+	return errs
+}
+
+// positive reports whether a numeric or duration setting is above zero
+func positive(v reflect.Value) bool {
+	switch {
+	case v.Type() == durationType:
+		return v.Interface().(Duration).Duration > 0
+	case v.CanInt():
+		return v.Int() > 0
+	case v.CanUint():
+		return v.Uint() > 0
+	}
+
+	return true
+}
+
+func joined(errs []error) error {
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return nil
+}
+
+// Report lists the effective value and origin of every setting, masking secrets
+func (c *Config) Report() string {
+	var b strings.Builder
+	b.WriteString("Effective configuration:\n")
+
+	for _, f := range c.fields() {
+		value := fmt.Sprint(f.value.Interface())
+		switch v := f.value.Interface().(type) {
+		case Duration:
+			value = v.String()
+		case []string:
+			value = strings.Join(v, ",")
+		}
+
+		if f.secret {
+			value = mask(value)
+		} else if value == "" {
+			value = "(unset)"
+		}
+
+		source := c.sources[f.env]
+		if source == "" {
+			source = "default"
+		}
+
+		fmt.Fprintf(&b, "  %-32s %-32s [%s]\n", f.env, value, source)
+	}
+
+	return b.String()
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return "(unset)"
+	}
+
+	return "********"
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Store holds the live configuration and reloads it from the same file and
+// environment it was loaded from. Only top-level sections tagged
+// reload:"true" change at runtime, other changes wait for a restart.
+type Store struct {
+	mu          sync.Mutex
+	path        string
+	current     *Config
+	subscribers []func(*Config)
+}
+
+// Outcome of a reload
+type Reload struct {
+	Applied []string `json:"applied"`          // Settings now in effect
+	Pending []string `json:"restart_required"` // Settings changed on disk that need a restart
+}
+
+func NewStore(path string, cfg *Config) *Store {
+	return &Store{path: path, current: cfg}
+}
+
+// Current configuration, which must be treated as read-only
+func (s *Store) Current() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current
+}
+
+// Subscribe calls fn with the current configuration now and again after
+// every reload that changes it
+func (s *Store) Subscribe(fn func(*Config)) {
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, fn)
+	cur := s.current
+	s.mu.Unlock()
+
+	fn(cur)
+}
+
+// Reload reads the configuration again and notifies subscribers. Nothing
+// changes when the new configuration fails to load or validate.
+func (s *Store) Reload() (*Reload, error) {
+	next, err := Load(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	cur := s.current
+	merged := *cur
+	merged.sources = make(map[string]string)
+
+	v := reflect.ValueOf(&merged).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("reload") == "true" {
+			v.Field(i).Set(reflect.ValueOf(next).Elem().Field(i))
+		}
+	}
+
+	res := &Reload{Applied: []string{}, Pending: []string{}}
+	prev := cur.fields()
+	incoming := next.fields()
+	for i, f := range merged.fields() {
+		if reloadable(f.path) {
+			if source, found := next.sources[f.env]; found {
+				merged.sources[f.env] = source
+			}
+		} else if source, found := cur.sources[f.env]; found {
+			merged.sources[f.env] = source
+		}
+
+		switch {
+		case !reflect.DeepEqual(f.value.Interface(), prev[i].value.Interface()):
+			res.Applied = append(res.Applied, f.env)
+		case !reflect.DeepEqual(incoming[i].value.Interface(), prev[i].value.Interface()):
+			res.Pending = append(res.Pending, f.env)
+		}
+	}
+
+	if err := merged.Validate(); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	if len(res.Applied) == 0 {
+		s.mu.Unlock()
+		return res, nil
+	}
+
+	s.current = &merged
+	subscribers := slices.Clone(s.subscribers)
+	s.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(&merged)
+	}
+
+	return res, nil
+}
+
+// reloadable reports whether a setting belongs to a section tagged reload:"true"
+func reloadable(path string) bool {
+	top := strings.Split(path, ".")[0]
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name == top {
+			return t.Field(i).Tag.Get("reload") == "true"
+		}
+	}
+
+	return false
+}
+
+func (r *Reload) String() string {
+	return fmt.Sprintf("applied [%s], restart required for [%s]", strings.Join(r.Applied, ", "), strings.Join(r.Pending, ", "))
+}
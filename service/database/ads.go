@@ -1,14 +1,21 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"service/config"
 	"service/log"
+	"service/metrics"
 	"service/utils"
 )
 
@@ -16,14 +23,39 @@ func newAds() []*utils.Ad {
 	return []*utils.Ad{}
 }
 
+// Guards the ads and users caches, which the handlers share with the database
+// monitor rebuilding them after an outage
+var cacheMu sync.Mutex
+
 // Current ads cache
 var currentAds []*utils.Ad = nil
 var currentAdsSince time.Time = time.Now()
 
-func getAds() []*utils.Ad {
+// clone copies every entry, so that the cache never shares what callers
+// modify outside of cacheMu
+func clone[T any](entries []*T) []*T {
+	out := make([]*T, 0, len(entries))
+	for _, e := range entries {
+		cp := *e
+		out = append(out, &cp)
+	}
+
+	return out
+}
+
+// getAds copies the cached ads, as callers range over and modify them unlocked
+func getAds(ctx context.Context) []*utils.Ad {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	// dropped now and then so that the database stays the source of truth
+	if time.Since(currentAdsSince) > 15*time.Minute {
+		currentAds = nil
+	}
+
 	if currentAds != nil {
-		log.Debug("Returning cached ads list")
-		return currentAds
+		log.Ctx(ctx).Debug("Returning cached ads list")
+		return clone(currentAds)
 	}
 
 	currentAdsSince = time.Now()
@@ -31,104 +63,204 @@ func getAds() []*utils.Ad {
 	return newAds()
 }
 
+// setAds replaces the ads cache, emptying it when ads is nil
+func setAds(ads []*utils.Ad) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if ads != nil {
+		ads = clone(ads)
+	}
+
+	currentAds, currentAdsSince = ads, time.Now()
+}
+
+// findAd returns a copy of the cached ad
 func findAd(id int64) (*utils.Ad, bool) {
-	if len(currentAds) > 0 {
-		for _, a := range currentAds {
-			if a.AdID == id {
-				return a, true
-			}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	for _, a := range currentAds {
+		if a.AdID == id {
+			cp := *a
+			return &cp, true
 		}
 	}
 
 	return nil, false
 }
 
-func setAd(ad *utils.Ad) []*utils.Ad {
-	if len(currentAds) <= 0 {
-		currentAds = []*utils.Ad{ad}
-		return currentAds
-	}
+// setAd caches a copy of ad, so later changes to it need another setAd
+func setAd(ctx context.Context, ad *utils.Ad) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
 
+	cp := *ad
 	for i, a := range currentAds {
 		if a.AdID == ad.AdID {
-			currentAds[i] = ad
-			return currentAds
+			currentAds[i] = &cp
+			return
 		}
 	}
 
-	currentAds = append(currentAds, ad)
-	return currentAds
+	currentAds = append(currentAds, &cp)
 }
 
-func deleteAd(id int64) []*utils.Ad {
-	if len(currentAds) > 0 {
-		for i, a := range currentAds {
-			if a.AdID == id {
-				currentAds = append((currentAds)[:i], (currentAds)[i+1:]...)
-			}
-		}
-	}
+func deleteAd(ctx context.Context, id int64) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
 
-	return getAds()
+	currentAds = slices.DeleteFunc(currentAds, func(a *utils.Ad) bool { return a.AdID == id })
 }
 
-func ApproveAd(id int64) (*utils.Ad, error) {
-	stmt, err := utils.PrepareStmt(dat, "UPDATE advertisements SET pending = FALSE, created_at = NOW() WHERE ad_id = ?")
+func ApproveAd(ctx context.Context, id int64) (*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE advertisements SET pending = FALSE, created_at = CURRENT_TIMESTAMP WHERE ad_id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.ExecContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if val, found := findAd(id); found {
-		val.Pending = false
-	}
+	// the cached entry still has the ad pending with its old creation time
+	deleteAd(ctx, id)
 
 	// fetch the ad so we can return it and touch its image file
-	ad, err := GetAdvertisement(id)
+	ad, err := GetAdvertisement(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	// try to touch the ad image to reset its modification time
+	// move the image out of review, restarting its lifetime
 	if ad != nil {
-		if adType, err := utils.AdTypeFromInt(ad.Type); err == nil {
-			adPath := filepath.Join("..", "ad_storage", string(adType), fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID))
-			now := time.Now()
-
-			if err := os.Chtimes(adPath, now, now); err != nil {
-				log.Error("Failed to reset image for ad approval %s: %s", adPath, err.Error())
-			} else {
-				log.Info("Reset image %s for ad approval", adPath)
+		publishImage(ctx, ad)
+		setAd(ctx, ad)
+	}
+
+	return ad, nil
+}
+
+// publishImage moves the image of an approved ad where the CDN serves it to
+// everyone and points the ad at it
+func publishImage(ctx context.Context, ad *utils.Ad) {
+	adPath, err := utils.ImagePath(ad)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to determine the image of ad %d: %s", ad.AdID, err.Error())
+		return
+	}
+
+	pending := *ad
+	pending.Pending = true
+	pendingPath, err := utils.ImagePath(&pending)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to determine the image of ad %d: %s", ad.AdID, err.Error())
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(adPath), os.ModePerm); err != nil {
+		log.Ctx(ctx).Error("Failed to create the image directory %s: %s", filepath.Dir(adPath), err.Error())
+		return
+	}
+
+	if err := os.Rename(pendingPath, adPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Ctx(ctx).Error("Failed to publish image %s: %s", pendingPath, err.Error())
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(adPath, now, now); err != nil {
+		log.Ctx(ctx).Error("Failed to reset image for ad approval %s: %s", adPath, err.Error())
+	} else {
+		log.Ctx(ctx).Info("Published image %s for ad approval", adPath)
+	}
+
+	if imageURL := utils.PublishedImageURL(ad.ImageURL); imageURL != ad.ImageURL {
+		if err := UpdateAdvertisementImageURL(ctx, ad.AdID, imageURL); err != nil {
+			log.Ctx(ctx).Error("Failed to update the image URL of ad %d: %s", ad.AdID, err.Error())
+			return
+		}
+
+		ad.ImageURL = imageURL
+	}
+}
+
+// movePendingImages moves the images of ads still pending from before they
+// were kept apart into the pending folder, pointing the ads at them, so that
+// the CDN stops serving them to everyone. Ads already moved are left alone.
+func movePendingImages(ctx context.Context) {
+	ads, err := ListPendingAdvertisements(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to list the pending ads to move their images: %s", err.Error())
+		return
+	}
+
+	moved := 0
+	for _, ad := range ads {
+		pendingPath, err := utils.ImagePath(ad)
+		if err != nil {
+			log.Ctx(ctx).Error("Failed to determine the image of ad %d: %s", ad.AdID, err.Error())
+			continue
+		}
+
+		public := *ad
+		public.Pending = false
+		publicPath, err := utils.ImagePath(&public)
+		if err != nil {
+			log.Ctx(ctx).Error("Failed to determine the image of ad %d: %s", ad.AdID, err.Error())
+			continue
+		}
+
+		if _, err := os.Stat(pendingPath); errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Stat(publicPath); err != nil {
+				continue
 			}
-		} else {
-			log.Error("Failed to determine ad type for resetting file: %s", err.Error())
+
+			if err := os.MkdirAll(filepath.Dir(pendingPath), os.ModePerm); err != nil {
+				log.Ctx(ctx).Error("Failed to create the image directory %s: %s", filepath.Dir(pendingPath), err.Error())
+				continue
+			}
+
+			if err := os.Rename(publicPath, pendingPath); err != nil {
+				log.Ctx(ctx).Error("Failed to move image %s out of the public folder: %s", publicPath, err.Error())
+				continue
+			}
+
+			moved++
 		}
 
-		currentAds = setAd(ad)
+		if imageURL := utils.PendingImageURL(ad.ImageURL); imageURL != ad.ImageURL {
+			if err := UpdateAdvertisementImageURL(ctx, ad.AdID, imageURL); err != nil {
+				log.Ctx(ctx).Error("Failed to update the image URL of ad %d: %s", ad.AdID, err.Error())
+			}
+		}
 	}
 
-	return ad, nil
+	if moved > 0 {
+		log.Ctx(ctx).Info("Moved the images of %d pending ad(s) out of the public folder", moved)
+	}
 }
 
 // inserts or updates an ad row
-func CreateAdvertisement(userId string, levelID string, adType int) (int64, error) {
+func CreateAdvertisement(ctx context.Context, userId string, levelID string, adType int) (int64, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if userId == "" || levelID == "" {
 		return 0, fmt.Errorf("missing ad fields")
 	}
 
 	// Create new ad - allow multiple ads per user per type
-	stmt, err := utils.PrepareStmt(dat, "INSERT INTO advertisements (user_id, level_id, type, pending) VALUES (?, ?, ?, ?)")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "INSERT INTO advertisements (user_id, level_id, type, pending) VALUES (?, ?, ?, ?)")
 	if err != nil {
 		return 0, err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.Exec(userId, levelID, adType, true)
+	res, err := stmt.ExecContext(ctx, userId, levelID, adType, true)
 	if err != nil {
 		return 0, err
 	}
@@ -136,30 +268,30 @@ func CreateAdvertisement(userId string, levelID string, adType int) (int64, erro
 	return res.LastInsertId()
 }
 
-func GetAdUnixExpiry(ad *utils.Ad) int64 {
-	expiry := ad.Created.Unix() + int64((14 * 24 * time.Hour).Seconds())
+func GetAdUnixExpiry(ad *utils.Ad, lifetime time.Duration) int64 {
+	expiry := ad.Created.Unix() + int64(lifetime.Seconds())
 
 	return expiry
 }
 
 // fetches all ads for a given user
-func ListAllAdvertisements() ([]*utils.Ad, error) {
-	if time.Since(currentAdsSince) > 15*time.Minute {
-		currentAds = nil
-	}
+func ListAllAdvertisements(ctx context.Context) ([]*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	cached := getAds(ctx)
+	metrics.Cache("ads", len(cached) > 0)
 
-	if len(currentAds) > 0 {
-		log.Debug("Returning cached ads list")
-		return getAds(), nil
+	if len(cached) > 0 {
+		return cached, nil
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM advertisements ORDER BY ad_id DESC")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM advertisements ORDER BY ad_id DESC")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -183,8 +315,8 @@ func ListAllAdvertisements() ([]*utils.Ad, error) {
 			return nil, err
 		}
 
-		r.Expiry = GetAdUnixExpiry(r)
-		currentAds = setAd(r)
+		r.Expiry = GetAdUnixExpiry(r, tierOf(ctx, r.UserID).AdLifetime.Duration)
+		setAd(ctx, r)
 
 		out = append(out, r)
 	}
@@ -192,14 +324,16 @@ func ListAllAdvertisements() ([]*utils.Ad, error) {
 	return out, rows.Err()
 }
 
-func ListPendingAdvertisements() ([]*utils.Ad, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM advertisements WHERE pending = TRUE ORDER BY ad_id DESC")
+func ListPendingAdvertisements(ctx context.Context) ([]*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM advertisements WHERE pending = TRUE ORDER BY ad_id DESC")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -223,8 +357,8 @@ func ListPendingAdvertisements() ([]*utils.Ad, error) {
 			return nil, err
 		}
 
-		r.Expiry = GetAdUnixExpiry(r)
-		currentAds = setAd(r)
+		r.Expiry = GetAdUnixExpiry(r, tierOf(ctx, r.UserID).AdLifetime.Duration)
+		setAd(ctx, r)
 
 		out = append(out, r)
 	}
@@ -243,10 +377,10 @@ func FilterAdsByPending(rows []*utils.Ad, showPending bool) ([]*utils.Ad, error)
 	return out, nil
 }
 
-func FilterAdsFromBannedUsers(rows []*utils.Ad) ([]*utils.Ad, error) {
+func FilterAdsFromBannedUsers(ctx context.Context, users UserRepository, rows []*utils.Ad) ([]*utils.Ad, error) {
 	var out []*utils.Ad
 	for _, r := range rows {
-		user, err := GetUser(r.UserID)
+		user, err := users.Get(ctx, r.UserID)
 		if err != nil {
 			return nil, err
 		}
@@ -286,9 +420,15 @@ func FilterAdsByType(rows []*utils.Ad, adType utils.AdType) ([]*utils.Ad, error)
 	return out, nil
 }
 
-func GetAdvertisement(adId int64) (*utils.Ad, error) {
-	if val, found := findAd(adId); found {
-		views, clicks, err := GetAdStats(adId)
+func GetAdvertisement(ctx context.Context, adId int64) (*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	val, found := findAd(adId)
+	metrics.Cache("ads", found)
+
+	if found {
+		views, clicks, err := GetAdStats(ctx, adId)
 		if err != nil {
 			return nil, err
 		}
@@ -299,13 +439,12 @@ func GetAdvertisement(adId int64) (*utils.Ad, error) {
 		return val, nil
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM advertisements WHERE ad_id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM advertisements WHERE ad_id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	row := stmt.QueryRow(adId)
+	row := stmt.QueryRowContext(ctx, adId)
 	if row != nil {
 		r := new(utils.Ad)
 		if err := row.Scan(
@@ -327,8 +466,8 @@ func GetAdvertisement(adId int64) (*utils.Ad, error) {
 			return nil, err
 		}
 
-		r.Expiry = GetAdUnixExpiry(r)
-		currentAds = setAd(r)
+		r.Expiry = GetAdUnixExpiry(r, tierOf(ctx, r.UserID).AdLifetime.Duration)
+		setAd(ctx, r)
 
 		return r, nil
 	} else {
@@ -337,20 +476,22 @@ func GetAdvertisement(adId int64) (*utils.Ad, error) {
 }
 
 // returns the owning user_id for an ad
-func GetAdvertisementOwnerId(adId int64) (string, error) {
+func GetAdvertisementOwnerId(ctx context.Context, adId int64) (string, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if val, found := findAd(adId); found {
 		return val.UserID, nil
 	}
 
 	var uid string
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT user_id FROM advertisements WHERE ad_id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT user_id FROM advertisements WHERE ad_id = ?")
 	if err != nil {
 		return "", err
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(adId).Scan(&uid)
+	err = stmt.QueryRowContext(ctx, adId).Scan(&uid)
 	if err != nil {
 		return "", err
 	}
@@ -358,67 +499,81 @@ func GetAdvertisementOwnerId(adId int64) (string, error) {
 	return uid, nil
 }
 
-func UpdateAdvertisementImageURL(adId int64, imageURL string) error {
+func UpdateAdvertisementImageURL(ctx context.Context, adId int64, imageURL string) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if imageURL == "" {
 		return fmt.Errorf("empty image url")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "UPDATE advertisements SET image_url = ? WHERE ad_id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE advertisements SET image_url = ? WHERE ad_id = ?")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	ad, err := GetAdvertisement(adId)
+	ad, err := GetAdvertisement(ctx, adId)
 	if err != nil {
 		return err
 	}
 
 	ad.ImageURL = imageURL
-	currentAds = setAd(ad)
+	setAd(ctx, ad)
 
-	_, err = stmt.Exec(imageURL, adId)
+	_, err = stmt.ExecContext(ctx, imageURL, adId)
 	return err
 }
 
-func DeleteAdvertisement(adId int64) (*utils.Ad, error) {
-	ad, err := GetAdvertisement(adId)
+func DeleteAdvertisement(ctx context.Context, adId int64) (*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	ad, err := GetAdvertisement(ctx, adId)
 	if err != nil {
 		return ad, err
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "DELETE FROM advertisements WHERE ad_id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "DELETE FROM advertisements WHERE ad_id = ?")
 	if err != nil {
 		return ad, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(adId)
+	_, err = stmt.ExecContext(ctx, adId)
 	if err != nil {
 		return ad, err
 	}
 
-	currentAds = deleteAd(adId)
+	deleteAd(ctx, adId)
 
 	return ad, nil
 }
 
-func DeleteAllExpiredAds() error {
-	stmt, err := utils.PrepareStmt(dat, "DELETE FROM advertisements WHERE created_at < NOW() - INTERVAL 14 DAY")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+func DeleteAllExpiredAds(ctx context.Context) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	tiers := currentLimits().Tiers
+	for _, t := range []struct {
+		tier  config.Tier
+		owned string // Ads whose owner is in the tier, see Limits.Tier
+	}{
+		{tiers.Staff, "user_id IN (SELECT id FROM users WHERE is_admin OR is_staff)"},
+		{tiers.Verified, "user_id IN (SELECT id FROM users WHERE verified AND NOT (is_admin OR is_staff))"},
+		{tiers.Normal, "user_id NOT IN (SELECT id FROM users WHERE verified OR is_admin OR is_staff)"},
+	} {
+		stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("DELETE FROM advertisements WHERE %s AND created_at < %s", t.owned, utils.DbDialect().Ago(t.tier.AdLifetime.Duration)))
+		if err != nil {
+			return err
+		}
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return err
+		}
 	}
 
-	adsDir := filepath.Join("..", "ad_storage")
-	err = filepath.WalkDir(adsDir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(utils.StorageDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			log.Error("Error accessing path %s: %s", path, err.Error())
+			log.Ctx(ctx).Error("Error accessing path %s: %s", path, err.Error())
 			return nil // continue walking
 		}
 
@@ -428,46 +583,50 @@ func DeleteAllExpiredAds() error {
 
 		info, err := d.Info()
 		if err != nil {
-			log.Error("Failed to get file info for %s: %s", path, err.Error())
+			log.Ctx(ctx).Error("Failed to get file info for %s: %s", path, err.Error())
 			return nil
 		}
 
-		if time.Since(info.ModTime()) > 14*24*time.Hour {
-			log.Info("Removing expired ad %s (%v B)", path, info.Size())
+		// images are named <user>-<id>.webp
+		owner, _, _ := strings.Cut(d.Name(), "-")
+		if time.Since(info.ModTime()) > tierOf(ctx, owner).AdLifetime.Duration {
+			log.Ctx(ctx).Info("Removing expired ad %s (%v B)", path, info.Size())
 			if err := os.Remove(path); err != nil {
-				log.Error("Failed to remove file %s: %s", path, err.Error())
+				log.Ctx(ctx).Error("Failed to remove file %s: %s", path, err.Error())
 			}
 		} else {
-			log.Debug("Advertisement %s is still valid", path)
+			log.Ctx(ctx).Debug("Advertisement %s is still valid", path)
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		log.Error("Failed to walk ad directory: %s", err.Error())
+		log.Ctx(ctx).Error("Failed to walk ad directory: %s", err.Error())
 		return err
 	}
 
-	currentAds = nil // clear cache
+	setAds(nil) // clear cache
 
 	return nil
 }
 
 // returns the count of active (non-expired) advertisements for a user
-func CountActiveAdvertisementsByUser(userId string) (int, error) {
+func CountActiveAdvertisementsByUser(ctx context.Context, userId string) (int, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if userId == "" {
 		return 0, fmt.Errorf("empty user id")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT COUNT(*) FROM advertisements WHERE user_id = ? AND created_at > NOW() - INTERVAL 14 DAY")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("SELECT COUNT(*) FROM advertisements WHERE user_id = ? AND created_at > %s", utils.DbDialect().Ago(tierOf(ctx, userId).AdLifetime.Duration)))
 	if err != nil {
 		return 0, err
 	}
-	defer stmt.Close()
 
 	var count int
-	err = stmt.QueryRow(userId).Scan(&count)
+	err = stmt.QueryRowContext(ctx, userId).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
@@ -476,16 +635,18 @@ func CountActiveAdvertisementsByUser(userId string) (int, error) {
 }
 
 // returns total_views and total_clicks for a given ad id
-func GetAdStats(adId int64) (int, int, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT views, clicks FROM advertisements WHERE ad_id = ?")
+func GetAdStats(ctx context.Context, adId int64) (int, int, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT views, clicks FROM advertisements WHERE ad_id = ?")
 	if err != nil {
 		return 0, 0, err
 	}
-	defer stmt.Close()
 
 	var views int
 	var clicks int
-	err = stmt.QueryRow(adId).Scan(&views, &clicks)
+	err = stmt.QueryRowContext(ctx, adId).Scan(&views, &clicks)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -493,89 +654,96 @@ func GetAdStats(adId int64) (int, int, error) {
 	return views, clicks, nil
 }
 
-func BoostAd(adId int64, boosts uint, user string) (*utils.Ad, error) {
-	ad, err := GetAdvertisement(adId)
+func BoostAd(ctx context.Context, adId int64, boosts uint, user string) (*utils.Ad, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	ad, err := GetAdvertisement(ctx, adId)
 	if err != nil {
 		return nil, err
 	}
 
-	if ad.BoostCount >= 30 {
+	// the cap follows the tier of the owner, not of whoever spends the boosts
+	maxBoosts := tierOf(ctx, ad.UserID).MaxAdBoosts
+	if ad.BoostCount >= maxBoosts {
 		return nil, fmt.Errorf("maximum boost limit already reached")
 	}
 
-	available := 30 - ad.BoostCount
+	available := maxBoosts - ad.BoostCount
 	if boosts > available {
 		boosts = available
 	}
 
-	deductStmt, err := utils.PrepareStmt(dat, "UPDATE users SET boost_count = boost_count - ? WHERE id = ?")
+	deductStmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET boost_count = boost_count - ? WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer deductStmt.Close()
 
-	_, err = deductStmt.Exec(boosts, user)
+	_, err = deductStmt.ExecContext(ctx, boosts, user)
 	if err != nil {
 		return nil, err
 	}
 
-	u, err := GetUser(user)
+	u, err := GetUser(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
 	u.BoostCount -= boosts
-	currentUsers = setUser(u)
+	setUser(ctx, u)
 
-	stmt, err := utils.PrepareStmt(dat, "UPDATE advertisements SET boost_count = boost_count + ? WHERE ad_id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE advertisements SET boost_count = boost_count + ? WHERE ad_id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(boosts, adId)
+	_, err = stmt.ExecContext(ctx, boosts, adId)
 	if err != nil {
 		return nil, err
 	}
 
 	ad.BoostCount += boosts
-	currentAds = setAd(ad)
+	setAd(ctx, ad)
 
 	return ad, nil
 }
 
-func AddBoostsToUser(userId string, boosts uint) error {
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET boost_count = boost_count + ? WHERE id = ?")
+func AddBoostsToUser(ctx context.Context, userId string, boosts uint) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET boost_count = boost_count + ? WHERE id = ?")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(boosts, userId)
+	_, err = stmt.ExecContext(ctx, boosts, userId)
 	if err != nil {
 		return err
 	}
 
-	user, err := GetUser(userId)
+	user, err := GetUser(ctx, userId)
 	if err != nil {
 		return err
 	}
 
 	user.BoostCount += boosts
-	currentUsers = setUser(user)
+	setUser(ctx, user)
 
 	return nil
 }
 
-func NewReport(adId int64, accountId int, description string) error {
-	existsStmt, err := utils.PrepareStmt(dat, "SELECT EXISTS(SELECT 1 FROM reports WHERE ad_id = ? AND account_id = ?)")
+func NewReport(ctx context.Context, adId int64, accountId int, description string) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	existsStmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT EXISTS(SELECT 1 FROM reports WHERE ad_id = ? AND account_id = ?)")
 	if err != nil {
 		return err
 	}
-	defer existsStmt.Close()
 
 	var exists bool
-	err = existsStmt.QueryRow(adId, accountId).Scan(&exists)
+	err = existsStmt.QueryRowContext(ctx, adId, accountId).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -584,31 +752,32 @@ func NewReport(adId int64, accountId int, description string) error {
 		return fmt.Errorf("report from this account for this ad already exists")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "INSERT INTO reports (ad_id, account_id, description) VALUES (?, ?, ?)")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "INSERT INTO reports (ad_id, account_id, description) VALUES (?, ?, ?)")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(adId, accountId, description)
+	_, err = stmt.ExecContext(ctx, adId, accountId, description)
 	return err
 }
 
-func GetReport(id int64) (*utils.Report, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM reports WHERE id = ?")
+func GetReport(ctx context.Context, id int64) (*utils.Report, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM reports WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
 	report := new(utils.Report)
 	var adId int64
-	err = stmt.QueryRow(id).Scan(&report.ID, &adId, &report.AccountID, &report.Description, &report.Created)
+	err = stmt.QueryRowContext(ctx, id).Scan(&report.ID, &adId, &report.AccountID, &report.Description, &report.Created)
 	if err != nil {
 		return nil, err
 	}
 
-	ad, err := GetAdvertisement(adId)
+	ad, err := GetAdvertisement(ctx, adId)
 	if err != nil {
 		return nil, err
 	}
@@ -618,14 +787,16 @@ func GetReport(id int64) (*utils.Report, error) {
 	return report, nil
 }
 
-func ListAllReports() ([]*utils.Report, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM reports ORDER BY created_at ASC")
+func ListAllReports(ctx context.Context) ([]*utils.Report, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM reports ORDER BY created_at ASC")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -645,7 +816,7 @@ func ListAllReports() ([]*utils.Report, error) {
 			return nil, err
 		}
 
-		ad, err := GetAdvertisement(adId)
+		ad, err := GetAdvertisement(ctx, adId)
 		if err != nil {
 			return nil, err
 		}
@@ -657,23 +828,15 @@ func ListAllReports() ([]*utils.Report, error) {
 	return out, rows.Err()
 }
 
-func FinishReport(report *utils.Report) error {
-	stmt, err := utils.PrepareStmt(dat, "DELETE FROM reports WHERE id = ?")
+func FinishReport(ctx context.Context, report *utils.Report) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "DELETE FROM reports WHERE id = ?")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(report.ID)
+	_, err = stmt.ExecContext(ctx, report.ID)
 	return err
 }
-
-func init() {
-	ads, err := ListAllAdvertisements()
-	if err != nil {
-		log.Error("Failed to initialize ads cache: %s", err.Error())
-	} else {
-		currentAds = ads
-		log.Info("Initialized ads cache with %d ads", len(ads))
-	}
-}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"service/utils"
+)
+
+func GetArgonUser(ctx context.Context, id int) (*utils.ArgonUser, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM argon WHERE account_id = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	user := new(utils.ArgonUser)
+	err = stmt.QueryRowContext(ctx, id).Scan(&user.Account, &user.Token, &user.ReportBanned, &user.ValidAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// records a validated account, refreshing its validation time
+func UpsertArgonUser(ctx context.Context, user *utils.ArgonUser) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	d := utils.DbDialect()
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("INSERT INTO argon (account_id, authtoken) VALUES (?, ?) %s",
+		d.OnConflict("account_id", "authtoken = "+d.Excluded("authtoken"), "valid_at = CURRENT_TIMESTAMP"),
+	))
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, user.Account, user.Token)
+	return err
+}
+
+func SetArgonReportBanned(ctx context.Context, id int, banned bool) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE argon SET report_banned = ? WHERE account_id = ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, banned, id)
+	return err
+}
@@ -1,42 +1,86 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"service/config"
 	"service/log"
 	"service/utils"
 
 	"github.com/patrickmn/go-cache"
 )
 
-var dat *sql.DB
+var dat atomic.Pointer[sql.DB] // Swapped by Init after a recovery
 var globals = cache.New(5*time.Minute, 10*time.Minute)
 
-// Register a new client event for an ad
-func NewStat(event utils.AdEvent, adId int64) error {
-	log.Debug("Registering new %s on ad %d", event, adId)
+// Business rules applied by the queries, kept current through SetLimits
+var limits atomic.Pointer[config.Limits]
 
-	query := fmt.Sprintf("UPDATE advertisements SET %s = %s + 1 WHERE ad_id = ?", event, event)
+// SetLimits changes the business rules applied by later queries
+func SetLimits(l config.Limits) {
+	limits.Store(&l)
+}
+
+func currentLimits() *config.Limits {
+	if l := limits.Load(); l != nil {
+		return l
+	}
+
+	l := config.Default().Limits
+	return &l
+}
+
+// tierOf picks the allowances of a user, falling back to the normal tier
+// when the user can't be loaded
+func tierOf(ctx context.Context, userId string) config.Tier {
+	l := currentLimits()
 
-	stmt, err := utils.PrepareStmt(dat, query)
+	u, err := GetUser(ctx, userId)
 	if err != nil {
-		return err
+		return l.Tiers.Normal
+	}
+
+	return l.Tier(u.IsAdmin || u.IsStaff, u.Verified)
+}
+
+// Register a new client event for an ad, spooled while the database is
+// unavailable or when it can not be reached to write it
+func NewStat(ctx context.Context, event utils.AdEvent, adId int64) error {
+	if utils.Degraded() {
+		return spoolStat(ctx, event, adId)
+	}
+
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	log.Ctx(ctx).Debug("Registering new %s on ad %d", event, adId)
+
+	query := fmt.Sprintf("UPDATE advertisements SET %s = %s + 1 WHERE ad_id = ?", event, event)
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), query)
+	if err == nil {
+		_, err = stmt.ExecContext(ctx, adId)
 	}
-	defer stmt.Close()
 
-	if _, err := stmt.Exec(adId); err != nil {
+	// the outage may not have been noticed by the monitor yet
+	if utils.IsConnectionError(err) {
+		log.Ctx(ctx).Warn("Failed to register %s on ad %d, spooling it: %s", event, adId, err.Error())
+		return spoolStat(ctx, event, adId)
+	} else if err != nil {
 		return err
 	}
 
-	ad, err := GetAdvertisement(adId)
+	ad, err := GetAdvertisement(ctx, adId)
 	if err != nil {
-		log.Error("Failed to get advertisement %d: %s", adId, err.Error())
+		log.Ctx(ctx).Error("Failed to get advertisement %d: %s", adId, err.Error())
 		return err
 	}
 
@@ -53,25 +97,28 @@ func NewStat(event utils.AdEvent, adId int64) error {
 	}
 
 	// Get the ad owner and increment their stats
-	if ownerID, ownerErr := GetAdvertisementOwnerId(adId); ownerErr == nil && ownerID != "" {
-		log.Debug("Incrementing stats for owner %s: views +%d, clicks +%d", ownerID, viewsDelta, clicksDelta)
-		if incErr := IncrementUserStats(ownerID, viewsDelta, clicksDelta); incErr != nil {
-			log.Error("Failed to increment total stats for user %s: %s", ownerID, incErr.Error())
+	if ownerID, ownerErr := GetAdvertisementOwnerId(ctx, adId); ownerErr == nil && ownerID != "" {
+		log.Ctx(ctx).Debug("Incrementing stats for owner %s: views +%d, clicks +%d", ownerID, viewsDelta, clicksDelta)
+		if incErr := IncrementUserStats(ctx, ownerID, viewsDelta, clicksDelta); incErr != nil {
+			log.Ctx(ctx).Error("Failed to increment total stats for user %s: %s", ownerID, incErr.Error())
 		}
 	} else {
-		log.Warn("Could not find owner for ad %d: %v", adId, ownerErr)
+		log.Ctx(ctx).Warn("Could not find owner for ad %d: %v", adId, ownerErr)
 	}
 
-	currentAds = setAd(ad)
+	setAd(ctx, ad)
 
-	log.Debug("Successfully registered stat type %s for ad %d", event, adId)
+	log.Ctx(ctx).Debug("Successfully registered stat type %s for ad %d", event, adId)
 	return nil
 }
 
 // returns total_views and total_clicks for a given user id
-func GetUserTotals(userId string) (utils.Stats, error) {
+func GetUserTotals(ctx context.Context, userId string) (utils.Stats, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if val, found := globals.Get(userId); found {
-		log.Debug("Returning cached global stats for user of ID %s", userId)
+		log.Ctx(ctx).Debug("Returning cached global stats for user of ID %s", userId)
 		return val.(utils.Stats), nil
 	}
 
@@ -81,13 +128,12 @@ func GetUserTotals(userId string) (utils.Stats, error) {
 		return stats, fmt.Errorf("empty user id")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT total_views, total_clicks FROM users WHERE id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT total_views, total_clicks FROM users WHERE id = ?")
 	if err != nil {
 		return stats, err
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(userId).Scan(&stats.Views, &stats.Clicks)
+	err = stmt.QueryRowContext(ctx, userId).Scan(&stats.Views, &stats.Clicks)
 	if err != nil {
 		return stats, err
 	}
@@ -98,21 +144,23 @@ func GetUserTotals(userId string) (utils.Stats, error) {
 }
 
 // GetGlobalStats returns the total views, total clicks, and count of active advertisements
-func GetGlobalStats() (utils.GlobalStats, error) {
+func GetGlobalStats(ctx context.Context) (utils.GlobalStats, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if val, found := globals.Get("global"); found {
-		log.Debug("Returning cached global stats")
+		log.Ctx(ctx).Debug("Returning cached global stats")
 		return val.(utils.GlobalStats), nil
 	}
 
 	stats := utils.GlobalStats{}
 
-	countStmt, err := utils.PrepareStmt(dat, "SELECT total_views, total_clicks FROM users WHERE banned = FALSE")
+	countStmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT total_views, total_clicks FROM users WHERE banned = FALSE")
 	if err != nil {
 		return stats, err
 	}
-	defer countStmt.Close()
 
-	countRows, err := countStmt.Query()
+	countRows, err := countStmt.QueryContext(ctx)
 	if err != nil {
 		return stats, err
 	}
@@ -124,20 +172,19 @@ func GetGlobalStats() (utils.GlobalStats, error) {
 			&cr.Views,
 			&cr.Clicks,
 		); err != nil {
-			log.Error("Failed to scan row for global stats: %s", err.Error())
+			log.Ctx(ctx).Error("Failed to scan row for global stats: %s", err.Error())
 		}
 
 		stats.TotalViews += cr.Views
 		stats.TotalClicks += cr.Clicks
 	}
 
-	adStmt, err := utils.PrepareStmt(dat, "SELECT COUNT(*) FROM advertisements WHERE pending = FALSE")
+	adStmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT COUNT(*) FROM advertisements WHERE pending = FALSE")
 	if err != nil {
 		return stats, err
 	}
-	defer adStmt.Close()
 
-	err = adStmt.QueryRow().Scan(&stats.AdCount)
+	err = adStmt.QueryRowContext(ctx).Scan(&stats.AdCount)
 	if err != nil {
 		return stats, err
 	}
@@ -147,8 +194,8 @@ func GetGlobalStats() (utils.GlobalStats, error) {
 	return stats, nil
 }
 
-func GetModDownloads() (uint64, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://api.geode-sdk.org/v1/mods/arcticwoof.player_advertisements", nil)
+func GetModDownloads(ctx context.Context, geodeURL string) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, geodeURL+"/v1/mods/arcticwoof.player_advertisements", nil)
 	if err != nil {
 		return 0, err
 	}
@@ -165,15 +212,15 @@ func GetModDownloads() (uint64, error) {
 		return 0, err
 	}
 
-	log.Debug("Mod endpoint status: %s", resp.Status)
+	log.Ctx(ctx).Debug("Mod endpoint status: %s", resp.Status)
 
 	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
-		log.Error("Geode returned non-JSON: %s", string(dlBody))
+		log.Ctx(ctx).Error("Geode returned non-JSON: %s", string(dlBody))
 		return 0, fmt.Errorf("request returned non-json")
 	}
 
 	if resp.Request != nil {
-		log.Debug("Mod endpoint final URL: %s", resp.Request.URL.String())
+		log.Ctx(ctx).Debug("Mod endpoint final URL: %s", resp.Request.URL.String())
 	}
 
 	type payload struct {
@@ -186,7 +233,7 @@ func GetModDownloads() (uint64, error) {
 	}
 
 	if err := json.Unmarshal(dlBody, &dlResp); err != nil {
-		log.Error("Failed to decode mod response: %s", err.Error())
+		log.Ctx(ctx).Error("Failed to decode mod response: %s", err.Error())
 		return 0, err
 	}
 
@@ -197,6 +244,32 @@ func GetModDownloads() (uint64, error) {
 	return dlResp.Payload.DownloadCount, nil
 }
 
-func init() {
-	dat = utils.Db()
+// Init binds the package to an open connection, warms the users and ads
+// caches, moves the images of pending ads out of the public folder and
+// replays the events spooled during an outage. It runs again after every
+// recovery.
+func Init(ctx context.Context, db *sql.DB) {
+	dat.Store(db)
+	setAds(nil)
+	setUsers(nil)
+
+	// first, as the expiry of an ad depends on the tier of its owner
+	users, err := GetAllUsers(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to initialize users cache: %s", err.Error())
+	} else {
+		setUsers(&users)
+		log.Ctx(ctx).Info("Initialized users cache with %d users", len(users))
+	}
+
+	ads, err := ListAllAdvertisements(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to initialize ads cache: %s", err.Error())
+	} else {
+		setAds(ads)
+		log.Ctx(ctx).Info("Initialized ads cache with %d ads", len(ads))
+	}
+
+	movePendingImages(ctx)
+	replaySpool(ctx)
 }
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"service/utils"
+)
+
+type sessions struct{ s *Store }
+
+func (r sessions) Save(ctx context.Context, hash string, user *utils.DiscordUser) error {
+	r.s.SetSession(hash, *user)
+	return nil
+}
+
+func (r sessions) Get(ctx context.Context, hash string) (*utils.DiscordUser, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	sess, found := r.s.sessions[hash]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	sess.lastSeen = time.Now()
+
+	user := sess.user
+	return &user, nil
+}
+
+func (r sessions) Delete(ctx context.Context, hash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.sessions, hash)
+	return nil
+}
+
+func (r sessions) DeleteExpired(ctx context.Context, maxAge time.Duration) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var removed int64
+	for hash, sess := range r.s.sessions {
+		if time.Since(sess.lastSeen) > maxAge {
+			delete(r.s.sessions, hash)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+type argon struct{ s *Store }
+
+func (r argon) Get(ctx context.Context, accountId int) (*utils.ArgonUser, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, found := r.s.argon[accountId]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	cp := *u
+	return &cp, nil
+}
+
+func (r argon) Upsert(ctx context.Context, user *utils.ArgonUser) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u, found := r.s.argon[user.Account]; found {
+		u.Token = user.Token
+		u.ValidAt = time.Now()
+	} else {
+		r.s.argon[user.Account] = &utils.ArgonUser{Account: user.Account, Token: user.Token, ValidAt: time.Now()}
+	}
+
+	return nil
+}
+
+func (r argon) SetReportBanned(ctx context.Context, accountId int, banned bool) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u, found := r.s.argon[accountId]; found {
+		u.ReportBanned = banned
+	}
+
+	return nil
+}
+
+type announcements struct{ s *Store }
+
+func (r announcements) List(ctx context.Context) ([]*utils.Announcement, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	out := make([]*utils.Announcement, 0, len(r.s.announcements))
+	for i := len(r.s.announcements) - 1; i >= 0; i-- {
+		cp := *r.s.announcements[i]
+		out = append(out, &cp)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out, nil
+}
+
+func (r announcements) Latest(ctx context.Context) (*utils.Announcement, error) {
+	all, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(all) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return all[0], nil
+}
@@ -0,0 +1,286 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"service/config"
+	"service/database"
+	"service/utils"
+)
+
+type ads struct{ s *Store }
+
+// tier of the user, the normal one for unknown users. Called with mu held.
+func (s *Store) tier(userId string) config.Tier {
+	u, found := s.users[userId]
+	if !found {
+		return s.limits.Tiers.Normal
+	}
+
+	return s.limits.Tier(u.IsAdmin || u.IsStaff, u.Verified)
+}
+
+func (s *Store) copyAd(a *utils.Ad) *utils.Ad {
+	cp := *a
+	cp.Expiry = database.GetAdUnixExpiry(&cp, s.tier(a.UserID).AdLifetime.Duration)
+	return &cp
+}
+
+func (r ads) list(pending bool, all bool) []*utils.Ad {
+	out := make([]*utils.Ad, 0, len(r.s.ads))
+	for _, a := range r.s.ads {
+		if all || a.Pending == pending {
+			out = append(out, r.s.copyAd(a))
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].AdID > out[j].AdID })
+	return out
+}
+
+func (r ads) List(ctx context.Context) ([]*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.list(false, true), nil
+}
+
+func (r ads) ListPending(ctx context.Context) ([]*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.list(true, false), nil
+}
+
+func (r ads) Get(ctx context.Context, id int64) (*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.s.copyAd(a), nil
+}
+
+func (r ads) OwnerID(ctx context.Context, id int64) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return "", sql.ErrNoRows
+	}
+
+	return a.UserID, nil
+}
+
+func (r ads) Stats(ctx context.Context, id int64) (int, int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	return int(a.Views), int(a.Clicks), nil
+}
+
+func (r ads) CountActiveByUser(ctx context.Context, userId string) (int, error) {
+	if userId == "" {
+		return 0, fmt.Errorf("empty user id")
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	count, lifetime := 0, r.s.tier(userId).AdLifetime.Duration
+	for _, a := range r.s.ads {
+		if a.UserID == userId && time.Since(a.Created) < lifetime {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r ads) Create(ctx context.Context, userId string, levelID string, adType int) (int64, error) {
+	if userId == "" || levelID == "" {
+		return 0, fmt.Errorf("missing ad fields")
+	}
+
+	level, err := strconv.ParseInt(levelID, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, found := r.s.users[userId]; !found {
+		return 0, fmt.Errorf("user %s does not exist", userId)
+	}
+
+	r.s.lastAdID++
+	r.s.ads[r.s.lastAdID] = &utils.Ad{
+		AdID:    r.s.lastAdID,
+		UserID:  userId,
+		LevelID: level,
+		Type:    adType,
+		Created: time.Now(),
+		Pending: true,
+	}
+
+	return r.s.lastAdID, nil
+}
+
+func (r ads) SetImageURL(ctx context.Context, id int64, imageURL string) error {
+	if imageURL == "" {
+		return fmt.Errorf("empty image url")
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return sql.ErrNoRows
+	}
+
+	a.ImageURL = imageURL
+	return nil
+}
+
+func (r ads) Approve(ctx context.Context, id int64) (*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	a.Pending = false
+	a.Created = time.Now()
+	a.ImageURL = utils.PublishedImageURL(a.ImageURL)
+
+	return r.s.copyAd(a), nil
+}
+
+func (r ads) Boost(ctx context.Context, id int64, boosts uint, userId string) (*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	u, found := r.s.users[userId]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	maxBoosts := r.s.tier(a.UserID).MaxAdBoosts
+	if a.BoostCount >= maxBoosts {
+		return nil, fmt.Errorf("maximum boost limit already reached")
+	}
+
+	if available := maxBoosts - a.BoostCount; boosts > available {
+		boosts = available
+	}
+
+	u.BoostCount -= boosts
+	a.BoostCount += boosts
+
+	return r.s.copyAd(a), nil
+}
+
+func (r ads) Delete(ctx context.Context, id int64) (*utils.Ad, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	delete(r.s.ads, id)
+	for rid, rep := range r.s.reports {
+		if rep.Ad.AdID == id {
+			delete(r.s.reports, rid)
+		}
+	}
+
+	return r.s.copyAd(a), nil
+}
+
+func (r ads) DeleteExpired(ctx context.Context) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, a := range r.s.ads {
+		if time.Since(a.Created) >= r.s.tier(a.UserID).AdLifetime.Duration {
+			delete(r.s.ads, id)
+		}
+	}
+
+	return nil
+}
+
+func (r ads) NewStat(ctx context.Context, event utils.AdEvent, id int64) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[id]
+	if !found {
+		return sql.ErrNoRows
+	}
+
+	u := r.s.users[a.UserID]
+
+	switch event {
+	case utils.AdEventView:
+		a.Views++
+		if u != nil {
+			u.TotalViews++
+		}
+	case utils.AdEventClick:
+		a.Clicks++
+		if u != nil {
+			u.TotalClicks++
+		}
+	default:
+		return fmt.Errorf("invalid ad event")
+	}
+
+	return nil
+}
+
+func (r ads) GlobalStats(ctx context.Context) (utils.GlobalStats, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stats := utils.GlobalStats{}
+	for _, u := range r.s.users {
+		if !u.Banned {
+			stats.TotalViews += u.TotalViews
+			stats.TotalClicks += u.TotalClicks
+		}
+	}
+
+	for _, a := range r.s.ads {
+		if !a.Pending {
+			stats.AdCount++
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"service/config"
+	"service/database"
+	"service/utils"
+)
+
+// In-memory stand-in for the SQL database, meant for exercising handlers without a server
+type Store struct {
+	mu     sync.Mutex
+	limits config.Limits
+
+	ads      map[int64]*utils.Ad
+	lastAdID int64
+
+	users map[string]*utils.User
+
+	reports      map[int64]*utils.Report
+	lastReportID int64
+
+	sessions map[string]*session
+	argon    map[int]*utils.ArgonUser
+
+	announcements []*utils.Announcement
+}
+
+type session struct {
+	user     utils.DiscordUser
+	lastSeen time.Time
+}
+
+func New(limits config.Limits) *Store {
+	return &Store{
+		limits:   limits,
+		ads:      make(map[int64]*utils.Ad),
+		users:    make(map[string]*utils.User),
+		reports:  make(map[int64]*utils.Report),
+		sessions: make(map[string]*session),
+		argon:    make(map[int]*utils.ArgonUser),
+	}
+}
+
+// Repositories exposes the store through the same interfaces as the SQL backend
+func (s *Store) Repositories() *database.Repositories {
+	return &database.Repositories{
+		Ads:           ads{s},
+		Users:         users{s},
+		Reports:       reports{s},
+		Sessions:      sessions{s},
+		Argon:         argon{s},
+		Announcements: announcements{s},
+	}
+}
+
+// AddUser seeds a user, filling in timestamps when unset
+func (s *Store) AddUser(u utils.User) *utils.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.Created.IsZero() {
+		u.Created = time.Now()
+	}
+
+	if u.Updated.IsZero() {
+		u.Updated = u.Created
+	}
+
+	s.users[u.ID] = &u
+
+	cp := u
+	return &cp
+}
+
+// AddAd seeds an advertisement, assigning an ID when unset
+func (s *Store) AddAd(a utils.Ad) *utils.Ad {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.AdID == 0 {
+		s.lastAdID++
+		a.AdID = s.lastAdID
+	} else if a.AdID > s.lastAdID {
+		s.lastAdID = a.AdID
+	}
+
+	if a.Created.IsZero() {
+		a.Created = time.Now()
+	}
+
+	a.Expiry = database.GetAdUnixExpiry(&a, s.tier(a.UserID).AdLifetime.Duration)
+	s.ads[a.AdID] = &a
+
+	cp := a
+	return &cp
+}
+
+// AddAnnouncement seeds an announcement authored by an existing user
+func (s *Store) AddAnnouncement(userId string, title string, content string) (*utils.Announcement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, found := s.users[userId]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	a := &utils.Announcement{
+		ID:      uint(len(s.announcements) + 1),
+		User:    *u,
+		Title:   title,
+		Content: content,
+		Created: time.Now(),
+	}
+
+	s.announcements = append(s.announcements, a)
+
+	cp := *a
+	return &cp, nil
+}
+
+// SetSession stores a session for the given cookie hash
+func (s *Store) SetSession(hash string, user utils.DiscordUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[hash] = &session{user: user, lastSeen: time.Now()}
+}
+
+// Login gives the user a session, returning the cookie that carries it
+func (s *Store) Login(u *utils.User) *http.Cookie {
+	raw := "session of " + u.ID
+	s.SetSession(utils.HashSessionID(raw), utils.DiscordUser{ID: u.ID, Username: u.Username})
+
+	return &http.Cookie{Name: "session_id", Value: raw}
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"service/utils"
+)
+
+type reports struct{ s *Store }
+
+func (r reports) Create(ctx context.Context, adId int64, accountId int, description string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	a, found := r.s.ads[adId]
+	if !found {
+		return fmt.Errorf("advertisement %d does not exist", adId)
+	}
+
+	for _, rep := range r.s.reports {
+		if rep.Ad.AdID == adId && rep.AccountID == accountId {
+			return fmt.Errorf("report from this account for this ad already exists")
+		}
+	}
+
+	r.s.lastReportID++
+	r.s.reports[r.s.lastReportID] = &utils.Report{
+		ID:          r.s.lastReportID,
+		Ad:          *a,
+		AccountID:   accountId,
+		Description: description,
+		Created:     time.Now(),
+	}
+
+	return nil
+}
+
+func (r reports) withAd(rep *utils.Report) *utils.Report {
+	cp := *rep
+	if a, found := r.s.ads[rep.Ad.AdID]; found {
+		cp.Ad = *r.s.copyAd(a)
+	}
+
+	return &cp
+}
+
+func (r reports) Get(ctx context.Context, id int64) (*utils.Report, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rep, found := r.s.reports[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.withAd(rep), nil
+}
+
+func (r reports) List(ctx context.Context) ([]*utils.Report, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var out []*utils.Report
+	for _, rep := range r.s.reports {
+		out = append(out, r.withAd(rep))
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (r reports) Finish(ctx context.Context, report *utils.Report) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.reports, report.ID)
+	return nil
+}
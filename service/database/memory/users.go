@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"service/utils"
+)
+
+type users struct{ s *Store }
+
+func (r users) Get(ctx context.Context, id string) (*utils.User, error) {
+	if id == "" {
+		return nil, fmt.Errorf("empty user id")
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, found := r.s.users[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	cp := *u
+	return &cp, nil
+}
+
+func (r users) sorted(less func(a, b *utils.User) bool) []*utils.User {
+	out := make([]*utils.User, 0, len(r.s.users))
+	for _, u := range r.s.users {
+		cp := *u
+		out = append(out, &cp)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return out
+}
+
+func (r users) List(ctx context.Context) ([]*utils.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.sorted(func(a, b *utils.User) bool { return a.ID > b.ID }), nil
+}
+
+func (r users) Leaderboard(ctx context.Context, stat utils.StatBy, page uint64, maxPerPage uint64) ([]*utils.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var value func(u *utils.User) uint64
+	switch stat {
+	case utils.StatByViews:
+		value = func(u *utils.User) uint64 { return u.TotalViews }
+	case utils.StatByClicks:
+		value = func(u *utils.User) uint64 { return u.TotalClicks }
+
+	default:
+		return nil, fmt.Errorf("invalid leaderboard stat")
+	}
+
+	var out []*utils.User
+	for _, u := range r.sorted(func(a, b *utils.User) bool { return value(a) > value(b) }) {
+		if !u.Banned {
+			out = append(out, u)
+		}
+	}
+
+	start := page * maxPerPage
+	end := start + maxPerPage
+
+	if start >= uint64(len(out)) {
+		return make([]*utils.User, 0), nil
+	}
+
+	if end > uint64(len(out)) {
+		end = uint64(len(out))
+	}
+
+	return out[start:end], nil
+}
+
+func (r users) Totals(ctx context.Context, id string) (utils.Stats, error) {
+	u, err := r.Get(ctx, id)
+	if err != nil {
+		return utils.Stats{}, err
+	}
+
+	return utils.Stats{Views: int(u.TotalViews), Clicks: int(u.TotalClicks)}, nil
+}
+
+func (r users) Upsert(ctx context.Context, id string, username string, avatarUrl string) error {
+	if id == "" {
+		return fmt.Errorf("empty user id")
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := time.Now()
+	if u, found := r.s.users[id]; found {
+		u.Username = username
+		u.AvatarURL = avatarUrl
+		u.Updated = now
+	} else {
+		r.s.users[id] = &utils.User{ID: id, Username: username, AvatarURL: avatarUrl, Created: now, Updated: now}
+	}
+
+	return nil
+}
+
+// update applies fn to a stored user and returns a copy of the result
+func (r users) update(id string, fn func(u *utils.User)) (*utils.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	u, found := r.s.users[id]
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	fn(u)
+
+	cp := *u
+	return &cp, nil
+}
+
+func (r users) AddBoosts(ctx context.Context, id string, boosts uint) error {
+	_, err := r.update(id, func(u *utils.User) { u.BoostCount += boosts })
+	return err
+}
+
+func (r users) Verify(ctx context.Context, id string, verified bool) (*utils.User, error) {
+	return r.update(id, func(u *utils.User) { u.Verified = verified })
+}
+
+func (r users) Staff(ctx context.Context, id string) (*utils.User, error) {
+	return r.update(id, func(u *utils.User) { u.IsStaff = true })
+}
+
+func (r users) Ban(ctx context.Context, id string) (*utils.User, error) {
+	return r.update(id, func(u *utils.User) { u.Banned = true })
+}
+
+func (r users) Unban(ctx context.Context, id string) (*utils.User, error) {
+	return r.update(id, func(u *utils.User) { u.Banned = false })
+}
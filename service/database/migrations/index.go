@@ -0,0 +1,359 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"service/log"
+	"service/utils"
+)
+
+//go:embed sql/mysql/*.sql sql/sqlite/*.sql
+var files embed.FS
+
+// Name of the advisory lock held while migrating
+const lockName = "gd_ads_schema_migrations"
+
+// Seconds to wait for another instance to release the migration lock
+const lockTimeout = 60
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// A single numbered schema change
+type Migration struct {
+	Version uint64 // Ordered version number
+	Name    string // Descriptive name taken from the file name
+	Up      string // Statements applying the change
+	Down    string // Statements reverting the change
+}
+
+// Applied state of a migration
+type State struct {
+	Migration
+	Applied   bool      // Recorded in schema_migrations
+	AppliedAt time.Time // When it was applied
+}
+
+// Load reads every embedded migration for a dialect ordered by version
+func Load(dialect utils.SQLDialect) ([]*Migration, error) {
+	dir := path.Join("sql", string(dialect))
+
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+
+		version, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", e.Name(), err)
+		}
+
+		body, err := files.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, found := byVersion[version]
+		if !found {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]*Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+
+		out = append(out, mig)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+
+	return out, nil
+}
+
+// Up applies pending migrations in order, at most steps of them when steps > 0
+func Up(db *sql.DB, dialect utils.SQLDialect, steps int) (int, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = withLock(db, dialect, func(conn *sql.Conn) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if steps > 0 && applied >= steps {
+				break
+			}
+
+			if _, found := done[m.Version]; found {
+				continue
+			}
+
+			log.Info("Applying migration %d_%s...", m.Version, m.Name)
+			ok, err := apply(conn, m, m.Up, true)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				applied++
+			}
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migrations, one when steps <= 0
+func Down(db *sql.DB, dialect utils.SQLDialect, steps int) (int, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	migrations, err := Load(dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	err = withLock(db, dialect, func(conn *sql.Conn) error {
+		done, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if _, found := done[m.Version]; !found {
+				continue
+			}
+
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted", m.Version, m.Name)
+			}
+
+			log.Info("Reverting migration %d_%s...", m.Version, m.Name)
+			ok, err := apply(conn, m, m.Down, false)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				reverted++
+			}
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists every known migration along with whether it has been applied
+func Status(db *sql.DB, dialect utils.SQLDialect) ([]*State, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	if db == nil {
+		return nil, fmt.Errorf("database connection non-existent")
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureTable(conn, dialect); err != nil {
+		return nil, err
+	}
+
+	done, err := appliedVersions(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*State, 0, len(migrations))
+	for _, m := range migrations {
+		s := &State{Migration: *m}
+		if at, found := done[m.Version]; found {
+			s.Applied = true
+			s.AppliedAt = at
+		}
+
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+func ensureTable(conn *sql.Conn, dialect utils.SQLDialect) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (version)
+)`
+
+	if dialect == utils.DialectMySQL {
+		query += " ENGINE = InnoDB DEFAULT CHARSET = utf8mb4"
+	}
+
+	_, err := conn.ExecContext(context.Background(), query)
+	return err
+}
+
+func appliedVersions(conn *sql.Conn) (map[uint64]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[uint64]time.Time)
+	for rows.Next() {
+		var version uint64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+
+		out[version] = at
+	}
+
+	return out, rows.Err()
+}
+
+// withLock serializes migrations across instances sharing the same database.
+// SQLite has no advisory locks, there every migration transaction takes the
+// write lock and re-checks its version instead.
+func withLock(db *sql.DB, dialect utils.SQLDialect, fn func(conn *sql.Conn) error) error {
+	if db == nil {
+		return fmt.Errorf("database connection non-existent")
+	}
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if dialect == utils.DialectSQLite {
+		if err := ensureTable(conn, dialect); err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+
+		return fn(conn)
+	}
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	if !locked.Valid || locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for migration lock")
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName); err != nil {
+			log.Error("Failed to release migration lock: %s", err.Error())
+		}
+	}()
+
+	if err := ensureTable(conn, dialect); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// apply runs a migration body and records it in one transaction, reporting
+// false when another instance got to it first. MariaDB commits implicitly on
+// DDL, so a failure halfway through may still need manual cleanup there.
+func apply(conn *sql.Conn, m *Migration, body string, up bool) (bool, error) {
+	statements, err := Split(body)
+	if err != nil {
+		return false, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var recorded bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = ?)", m.Version).Scan(&recorded); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if recorded == up {
+		log.Debug("Migration %d_%s was already handled elsewhere", m.Version, m.Name)
+		return false, tx.Rollback()
+	}
+
+	for _, stmt := range statements {
+		log.Debug("Executing migration statement: %.50s...", stmt)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to record migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Split breaks a migration file into individual statements. Semicolons inside
+// quotes and comments are ignored, and every statement must be terminated so a
+// forgotten semicolon fails loudly instead of swallowing the next statement.
+func Split(body string) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+
+	var quote byte
+	lineComment := false
+	blockComment := false
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		var next byte
+		if i+1 < len(body) {
+			next = body[i+1]
+		}
+
+		switch {
+		case lineComment:
+			if c == '\n' {
+				lineComment = false
+				cur.WriteByte(c)
+			}
+			continue
+
+		case blockComment:
+			if c == '*' && next == '/' {
+				blockComment = false
+				i++
+			}
+			continue
+
+		case quote != 0:
+			cur.WriteByte(c)
+			if c == '\\' && quote != '`' && next != 0 {
+				cur.WriteByte(next)
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case c == '-' && next == '-':
+			lineComment = true
+			i++
+
+		case c == '#':
+			lineComment = true
+
+		case c == '/' && next == '*':
+			blockComment = true
+			i++
+
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			cur.WriteByte(c)
+
+		case c == ';':
+			if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+				out = append(out, stmt)
+			}
+			cur.Reset()
+
+		default:
+			cur.WriteByte(c)
+		}
+	}
+
+	if quote != 0 || blockComment {
+		return nil, fmt.Errorf("unterminated quote or comment")
+	}
+
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		return nil, fmt.Errorf("statement is missing a terminating semicolon: %.50s...", rest)
+	}
+
+	return out, nil
+}
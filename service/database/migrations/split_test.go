@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+		err  bool
+	}{
+		{
+			name: "statements",
+			body: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name: "empty statements dropped",
+			body: ";\n  ;\nSELECT 1;;",
+			want: []string{"SELECT 1"},
+		},
+		{
+			name: "semicolons in quotes",
+			body: "INSERT INTO t VALUES ('a;b', \"c;d\");\nSELECT `we;ird` FROM t;",
+			want: []string{"INSERT INTO t VALUES ('a;b', \"c;d\")", "SELECT `we;ird` FROM t"},
+		},
+		{
+			name: "escaped quotes",
+			body: `INSERT INTO t VALUES ('it\'s; fine', 'it''s; fine');`,
+			want: []string{`INSERT INTO t VALUES ('it\'s; fine', 'it''s; fine')`},
+		},
+		{
+			name: "backslashes in backticks",
+			body: "SELECT `a\\`;",
+			want: []string{"SELECT `a\\`"},
+		},
+		{
+			name: "line comments",
+			body: "-- leading; comment\nSELECT 1; -- trailing; comment\n# hash; comment\nSELECT 2;",
+			want: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name: "block comments",
+			body: "/* a; b */ SELECT /* inline; */ 1;\n/*\n multi;\n line\n*/\nSELECT 2;",
+			want: []string{"SELECT  1", "SELECT 2"},
+		},
+		{
+			name: "comment markers in quotes",
+			body: "SELECT '-- not; a comment', '/* nor; this */';",
+			want: []string{"SELECT '-- not; a comment', '/* nor; this */'"},
+		},
+		{
+			name: "only comments",
+			body: "-- nothing here\n/* or here */\n",
+			want: nil,
+		},
+		{
+			name: "unterminated final statement",
+			body: "SELECT 1;\nSELECT 2",
+			err:  true,
+		},
+		{
+			name: "unterminated quote",
+			body: "SELECT 'a;",
+			err:  true,
+		},
+		{
+			name: "unterminated block comment",
+			body: "SELECT 1; /* open;",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Split(tt.body)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Split(%q) = %q, expected an error", tt.body, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Split(%q) failed: %v", tt.body, err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Split(%q) = %q, expected %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -59,7 +59,7 @@ CREATE TABLE IF NOT EXISTS reports (
     PRIMARY KEY (id),
     KEY idx_ad_id (ad_id),
     CONSTRAINT fx_ads_ad FOREIGN KEY (ad_id) REFERENCES advertisements (ad_id) ON DELETE CASCADE ON UPDATE CASCADE
-)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
 
 CREATE TABLE IF NOT EXISTS announcements (
     id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
@@ -69,5 +69,5 @@ CREATE TABLE IF NOT EXISTS announcements (
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (id),
     KEY idx_user_id (user_id),
-    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
-);
\ No newline at end of file
+    CONSTRAINT fk_announcements_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
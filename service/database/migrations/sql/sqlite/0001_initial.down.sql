@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS announcements;
+
+DROP TABLE IF EXISTS reports;
+
+DROP TABLE IF EXISTS argon;
+
+DROP TABLE IF EXISTS sessions;
+
+DROP TABLE IF EXISTS advertisements;
+
+DROP TABLE IF EXISTS users;
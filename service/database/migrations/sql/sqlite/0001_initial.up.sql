@@ -0,0 +1,74 @@
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(32) NOT NULL,
+    username VARCHAR(100) NOT NULL,
+    avatar_url VARCHAR(255) NOT NULL DEFAULT '',
+    total_views BIGINT NOT NULL DEFAULT 0,
+    total_clicks BIGINT NOT NULL DEFAULT 0,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    is_staff BOOLEAN NOT NULL DEFAULT FALSE,
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+    banned BOOLEAN NOT NULL DEFAULT FALSE,
+    boost_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS advertisements (
+    ad_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id VARCHAR(32) NOT NULL,
+    level_id INTEGER NOT NULL,
+    type INTEGER NOT NULL,
+    views BIGINT NOT NULL DEFAULT 0,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    image_url VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    pending BOOLEAN NOT NULL DEFAULT TRUE,
+    boost_count INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT fk_ads_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_advertisements_user_id ON advertisements (user_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(32) NOT NULL,
+    username VARCHAR(100) NOT NULL,
+    discriminator CHAR(4) NOT NULL,
+    avatar VARCHAR(64) DEFAULT NULL,
+    last_seen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id),
+    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS argon (
+    account_id INTEGER NOT NULL,
+    authtoken VARCHAR(255) DEFAULT NULL,
+    report_banned BOOLEAN NOT NULL DEFAULT FALSE,
+    valid_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (account_id)
+);
+
+CREATE TABLE IF NOT EXISTS reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ad_id BIGINT NOT NULL,
+    account_id INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fx_ads_ad FOREIGN KEY (ad_id) REFERENCES advertisements (ad_id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_ad_id ON reports (ad_id);
+
+CREATE TABLE IF NOT EXISTS announcements (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id VARCHAR(32) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_announcements_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_announcements_user_id ON announcements (user_id);
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"service/utils"
+)
+
+// Advertisement rows, their cache and their event counters
+type AdRepository interface {
+	List(ctx context.Context) ([]*utils.Ad, error)
+	ListPending(ctx context.Context) ([]*utils.Ad, error)
+	Get(ctx context.Context, id int64) (*utils.Ad, error)
+	OwnerID(ctx context.Context, id int64) (string, error)
+	Stats(ctx context.Context, id int64) (int, int, error)
+	CountActiveByUser(ctx context.Context, userId string) (int, error)
+	Create(ctx context.Context, userId string, levelID string, adType int) (int64, error)
+	SetImageURL(ctx context.Context, id int64, imageURL string) error
+	Approve(ctx context.Context, id int64) (*utils.Ad, error)
+	Boost(ctx context.Context, id int64, boosts uint, userId string) (*utils.Ad, error)
+	Delete(ctx context.Context, id int64) (*utils.Ad, error)
+	DeleteExpired(ctx context.Context) error
+	NewStat(ctx context.Context, event utils.AdEvent, id int64) error
+	GlobalStats(ctx context.Context) (utils.GlobalStats, error)
+}
+
+// Dashboard accounts
+type UserRepository interface {
+	Get(ctx context.Context, id string) (*utils.User, error)
+	List(ctx context.Context) ([]*utils.User, error)
+	Leaderboard(ctx context.Context, stat utils.StatBy, page uint64, maxPerPage uint64) ([]*utils.User, error)
+	Totals(ctx context.Context, id string) (utils.Stats, error)
+	Upsert(ctx context.Context, id string, username string, avatarUrl string) error
+	AddBoosts(ctx context.Context, id string, boosts uint) error
+	Verify(ctx context.Context, id string, verified bool) (*utils.User, error)
+	Staff(ctx context.Context, id string) (*utils.User, error)
+	Ban(ctx context.Context, id string) (*utils.User, error)
+	Unban(ctx context.Context, id string) (*utils.User, error)
+}
+
+// Player reports against advertisements
+type ReportRepository interface {
+	Create(ctx context.Context, adId int64, accountId int, description string) error
+	Get(ctx context.Context, id int64) (*utils.Report, error)
+	List(ctx context.Context) ([]*utils.Report, error)
+	Finish(ctx context.Context, report *utils.Report) error
+}
+
+// Login sessions keyed by the hash of their cookie
+type SessionRepository interface {
+	Save(ctx context.Context, hash string, user *utils.DiscordUser) error
+	Get(ctx context.Context, hash string) (*utils.DiscordUser, error)
+	Delete(ctx context.Context, hash string) error
+	DeleteExpired(ctx context.Context, maxAge time.Duration) (int64, error)
+}
+
+// Validated Argon accounts of mod players
+type ArgonRepository interface {
+	Get(ctx context.Context, accountId int) (*utils.ArgonUser, error)
+	Upsert(ctx context.Context, user *utils.ArgonUser) error
+	SetReportBanned(ctx context.Context, accountId int, banned bool) error
+}
+
+// Site announcements
+type AnnouncementRepository interface {
+	Latest(ctx context.Context) (*utils.Announcement, error)
+	List(ctx context.Context) ([]*utils.Announcement, error)
+}
+
+// Every repository a handler may depend on
+type Repositories struct {
+	Ads           AdRepository
+	Users         UserRepository
+	Reports       ReportRepository
+	Sessions      SessionRepository
+	Argon         ArgonRepository
+	Announcements AnnouncementRepository
+}
+
+// NewSQLRepositories returns repositories backed by the connection given to Init
+func NewSQLRepositories() *Repositories {
+	return &Repositories{
+		Ads:           sqlAds{},
+		Users:         sqlUsers{},
+		Reports:       sqlReports{},
+		Sessions:      sqlSessions{},
+		Argon:         sqlArgon{},
+		Announcements: sqlAnnouncements{},
+	}
+}
+
+type sqlAds struct{}
+
+func (sqlAds) List(ctx context.Context) ([]*utils.Ad, error) { return ListAllAdvertisements(ctx) }
+func (sqlAds) ListPending(ctx context.Context) ([]*utils.Ad, error) {
+	return ListPendingAdvertisements(ctx)
+}
+func (sqlAds) Get(ctx context.Context, id int64) (*utils.Ad, error) { return GetAdvertisement(ctx, id) }
+func (sqlAds) OwnerID(ctx context.Context, id int64) (string, error) {
+	return GetAdvertisementOwnerId(ctx, id)
+}
+func (sqlAds) Stats(ctx context.Context, id int64) (int, int, error)    { return GetAdStats(ctx, id) }
+func (sqlAds) Approve(ctx context.Context, id int64) (*utils.Ad, error) { return ApproveAd(ctx, id) }
+func (sqlAds) Delete(ctx context.Context, id int64) (*utils.Ad, error) {
+	return DeleteAdvertisement(ctx, id)
+}
+func (sqlAds) DeleteExpired(ctx context.Context) error                    { return DeleteAllExpiredAds(ctx) }
+func (sqlAds) GlobalStats(ctx context.Context) (utils.GlobalStats, error) { return GetGlobalStats(ctx) }
+
+func (sqlAds) CountActiveByUser(ctx context.Context, userId string) (int, error) {
+	return CountActiveAdvertisementsByUser(ctx, userId)
+}
+
+func (sqlAds) Create(ctx context.Context, userId string, levelID string, adType int) (int64, error) {
+	return CreateAdvertisement(ctx, userId, levelID, adType)
+}
+
+func (sqlAds) SetImageURL(ctx context.Context, id int64, imageURL string) error {
+	return UpdateAdvertisementImageURL(ctx, id, imageURL)
+}
+
+func (sqlAds) Boost(ctx context.Context, id int64, boosts uint, userId string) (*utils.Ad, error) {
+	return BoostAd(ctx, id, boosts, userId)
+}
+
+func (sqlAds) NewStat(ctx context.Context, event utils.AdEvent, id int64) error {
+	return NewStat(ctx, event, id)
+}
+
+type sqlUsers struct{}
+
+func (sqlUsers) Get(ctx context.Context, id string) (*utils.User, error) { return GetUser(ctx, id) }
+func (sqlUsers) List(ctx context.Context) ([]*utils.User, error)         { return GetAllUsers(ctx) }
+func (sqlUsers) Totals(ctx context.Context, id string) (utils.Stats, error) {
+	return GetUserTotals(ctx, id)
+}
+func (sqlUsers) AddBoosts(ctx context.Context, id string, boosts uint) error {
+	return AddBoostsToUser(ctx, id, boosts)
+}
+func (sqlUsers) Staff(ctx context.Context, id string) (*utils.User, error) { return StaffUser(ctx, id) }
+func (sqlUsers) Ban(ctx context.Context, id string) (*utils.User, error)   { return BanUser(ctx, id) }
+func (sqlUsers) Unban(ctx context.Context, id string) (*utils.User, error) { return UnbanUser(ctx, id) }
+
+func (sqlUsers) Leaderboard(ctx context.Context, stat utils.StatBy, page uint64, maxPerPage uint64) ([]*utils.User, error) {
+	return UserLeaderboard(ctx, stat, page, maxPerPage)
+}
+
+func (sqlUsers) Upsert(ctx context.Context, id string, username string, avatarUrl string) error {
+	return UpsertUser(ctx, id, username, avatarUrl)
+}
+
+func (sqlUsers) Verify(ctx context.Context, id string, verified bool) (*utils.User, error) {
+	return VerifyUser(ctx, id, verified)
+}
+
+type sqlReports struct{}
+
+func (sqlReports) Get(ctx context.Context, id int64) (*utils.Report, error) {
+	return GetReport(ctx, id)
+}
+func (sqlReports) List(ctx context.Context) ([]*utils.Report, error) { return ListAllReports(ctx) }
+func (sqlReports) Finish(ctx context.Context, report *utils.Report) error {
+	return FinishReport(ctx, report)
+}
+
+func (sqlReports) Create(ctx context.Context, adId int64, accountId int, description string) error {
+	return NewReport(ctx, adId, accountId, description)
+}
+
+type sqlSessions struct{}
+
+func (sqlSessions) Save(ctx context.Context, hash string, user *utils.DiscordUser) error {
+	return SaveSession(ctx, hash, user)
+}
+func (sqlSessions) Get(ctx context.Context, hash string) (*utils.DiscordUser, error) {
+	return GetSession(ctx, hash)
+}
+func (sqlSessions) Delete(ctx context.Context, hash string) error { return DeleteSession(ctx, hash) }
+
+func (sqlSessions) DeleteExpired(ctx context.Context, maxAge time.Duration) (int64, error) {
+	return DeleteExpiredSessions(ctx, maxAge)
+}
+
+type sqlArgon struct{}
+
+func (sqlArgon) Get(ctx context.Context, accountId int) (*utils.ArgonUser, error) {
+	return GetArgonUser(ctx, accountId)
+}
+func (sqlArgon) Upsert(ctx context.Context, user *utils.ArgonUser) error {
+	return UpsertArgonUser(ctx, user)
+}
+
+func (sqlArgon) SetReportBanned(ctx context.Context, accountId int, banned bool) error {
+	return SetArgonReportBanned(ctx, accountId, banned)
+}
+
+type sqlAnnouncements struct{}
+
+func (sqlAnnouncements) Latest(ctx context.Context) (*utils.Announcement, error) {
+	return GetLatestAnnouncement(ctx)
+}
+func (sqlAnnouncements) List(ctx context.Context) ([]*utils.Announcement, error) {
+	return GetAllAnnouncements(ctx)
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"service/utils"
+)
+
+// stores a session under the hash of its cookie value
+func SaveSession(ctx context.Context, hash string, user *utils.DiscordUser) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	d := utils.DbDialect()
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("INSERT INTO sessions (session_id, user_id, username, discriminator, avatar) VALUES (?, ?, ?, ?, ?) %s",
+		d.OnConflict("session_id", "user_id = "+d.Excluded("user_id"), "username = "+d.Excluded("username"), "discriminator = "+d.Excluded("discriminator"), "avatar = "+d.Excluded("avatar")),
+	))
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, hash, user.ID, user.Username, user.Discriminator, user.Avatar)
+	return err
+}
+
+// looks up a session by hash and marks it as seen
+func GetSession(ctx context.Context, hash string) (*utils.DiscordUser, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT user_id, username, discriminator, avatar FROM sessions WHERE session_id = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	user := new(utils.DiscordUser)
+	err = stmt.QueryRowContext(ctx, hash).Scan(&user.ID, &user.Username, &user.Discriminator, &user.Avatar)
+	if err != nil {
+		return nil, err
+	}
+
+	updStmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE sessions SET last_seen = CURRENT_TIMESTAMP WHERE session_id = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = updStmt.ExecContext(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func DeleteSession(ctx context.Context, hash string) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "DELETE FROM sessions WHERE session_id = ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, hash)
+	return err
+}
+
+// removes sessions not seen within maxAge, returning how many were removed
+func DeleteExpiredSessions(ctx context.Context, maxAge time.Duration) (int64, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("DELETE FROM sessions WHERE last_seen < %s", utils.DbDialect().Ago(maxAge)))
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"service/log"
+	"service/metrics"
+	"service/utils"
+)
+
+// Largest spool kept on disk, later events are dropped until a replay
+const maxSpoolBytes = 16 << 20
+
+var spooledEvents = metrics.NewCounter("events_spooled_total", "Views and clicks spooled while the database was unavailable.", "event")
+
+// Views and clicks recorded while the database is unavailable, one JSON
+// line each, replayed by Init once it is back
+var spool struct {
+	mu     sync.Mutex // Guards appends and the move to the replay file
+	replay sync.Mutex // Held for a whole replay
+	path   string
+}
+
+type spooledEvent struct {
+	Event utils.AdEvent `json:"event"`
+	AdID  int64         `json:"ad_id"`
+	At    time.Time     `json:"at"`
+}
+
+// SetSpool sets the file of spooled events, DB_SPOOL
+func SetSpool(path string) {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	spool.path = path
+}
+
+func spoolStat(ctx context.Context, event utils.AdEvent, adId int64) error {
+	b, err := json.Marshal(spooledEvent{Event: event, AdID: adId, At: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	if err := appendSpool(b); err != nil {
+		return fmt.Errorf("failed to spool %s on ad %d: %w", event, adId, err)
+	}
+
+	spooledEvents.Inc(string(event))
+	log.Ctx(ctx).Info("Spooled %s on ad %d until the database recovers", event, adId)
+
+	return nil
+}
+
+func appendSpool(lines ...[]byte) error {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	if spool.path == "" {
+		return errors.New("no spool file configured")
+	}
+
+	if info, err := os.Stat(spool.path); err == nil && info.Size() >= maxSpoolBytes {
+		return errors.New("spool is full")
+	}
+
+	f, err := os.OpenFile(spool.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// replaySpool records the spooled events in order. Events that fail because
+// the database dropped again go back to the spool, others are logged and
+// dropped so a deleted ad cannot block the rest.
+func replaySpool(ctx context.Context) {
+	spool.replay.Lock()
+	defer spool.replay.Unlock()
+
+	spool.mu.Lock()
+	path := spool.path + ".replay"
+
+	// a replay interrupted by a restart is finished before the new events
+	if _, err := os.Stat(path); err != nil {
+		err = os.Rename(spool.path, path)
+		if err != nil {
+			spool.mu.Unlock()
+
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Ctx(ctx).Error("Failed to move the event spool for replay: %s", err.Error())
+			}
+
+			return
+		}
+	}
+	spool.mu.Unlock()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Ctx(ctx).Error("Failed to read the event spool: %s", err.Error())
+		return
+	}
+
+	lines := bytesLines(b)
+	replayed := 0
+	for i, line := range lines {
+		var e spooledEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Ctx(ctx).Error("Dropped malformed spooled event: %s", err.Error())
+			continue
+		}
+
+		err := NewStat(ctx, e.Event, e.AdID)
+		if err == nil {
+			replayed++
+			continue
+		}
+
+		if utils.Ping(ctx) != nil {
+			log.Ctx(ctx).Warn("Database dropped while replaying events, %d left in the spool", len(lines)-i)
+			if err := appendSpool(lines[i:]...); err != nil {
+				log.Ctx(ctx).Error("Failed to spool events again, %d lost: %s", len(lines)-i, err.Error())
+			}
+
+			break
+		}
+
+		log.Ctx(ctx).Error("Dropped spooled %s on ad %d: %s", e.Event, e.AdID, err.Error())
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.Ctx(ctx).Error("Failed to remove the replayed event spool: %s", err.Error())
+	}
+
+	if replayed > 0 {
+		log.Ctx(ctx).Done("Replayed %d spooled event(s)", replayed)
+	}
+}
+
+// bytesLines splits b into its non-empty lines
+func bytesLines(b []byte) [][]byte {
+	var lines [][]byte
+	for line := range bytes.Lines(b) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
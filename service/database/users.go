@@ -1,27 +1,37 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
 	"time"
 
 	"service/log"
+	"service/metrics"
 	"service/utils"
 )
 
-func newUsers() *[]*utils.User {
-	return new([]*utils.User)
+func newUsers() []*utils.User {
+	return []*utils.User{}
 }
 
-// Current users cache
+// Current users cache, guarded by cacheMu
 var currentUsers *[]*utils.User = nil
 var currentUsersSince time.Time = time.Now()
 
-func getUsers() *[]*utils.User {
+// getUsers copies the cached users, as callers range over and modify them unlocked
+func getUsers(ctx context.Context) []*utils.User {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if time.Since(currentUsersSince) > 15*time.Minute {
+		currentUsers = nil
+	}
+
 	if currentUsers != nil {
-		log.Debug("Returning cached ads list")
-		return currentUsers
+		log.Ctx(ctx).Debug("Returning cached users list")
+		return clone(*currentUsers)
 	}
 
 	currentUsersSince = time.Now()
@@ -29,11 +39,29 @@ func getUsers() *[]*utils.User {
 	return newUsers()
 }
 
+// setUsers replaces the users cache, disabling it when users is nil
+func setUsers(users *[]*utils.User) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if users != nil {
+		copies := clone(*users)
+		users = &copies
+	}
+
+	currentUsers, currentUsersSince = users, time.Now()
+}
+
+// findUser returns a copy of the cached user
 func findUser(id string) (*utils.User, bool) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
 	if currentUsers != nil {
 		for _, u := range *currentUsers {
 			if u.ID == id {
-				return u, true
+				cp := *u
+				return &cp, true
 			}
 		}
 	}
@@ -41,44 +69,57 @@ func findUser(id string) (*utils.User, bool) {
 	return nil, false
 }
 
-func setUser(user *utils.User) *[]*utils.User {
-	if currentUsers != nil {
-		log.Debug("Caching user %s", user.ID)
-		*currentUsers = append(*currentUsers, user)
-	}
-
-	return getUsers()
-}
+// setUser caches a copy of user, so later changes to it need another setUser
+func setUser(ctx context.Context, user *utils.User) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
 
-func deleteUser(id string) *[]*utils.User {
 	if currentUsers != nil {
+		log.Ctx(ctx).Debug("Caching user %s", user.ID)
+
+		cp := *user
 		for i, u := range *currentUsers {
-			if u.ID == id {
-				*currentUsers = append((*currentUsers)[:i], (*currentUsers)[i+1:]...)
+			if u.ID == user.ID {
+				(*currentUsers)[i] = &cp
+				return
 			}
 		}
+
+		*currentUsers = append(*currentUsers, &cp)
 	}
+}
+
+func deleteUser(ctx context.Context, id string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
 
-	return getUsers()
+	if currentUsers != nil {
+		*currentUsers = slices.DeleteFunc(*currentUsers, func(u *utils.User) bool { return u.ID == id })
+	}
 }
 
-func GetUser(id string) (*utils.User, error) {
+func GetUser(ctx context.Context, id string) (*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if id == "" {
 		return nil, fmt.Errorf("empty user id")
 	}
 
-	if val, found := findUser(id); found {
+	val, found := findUser(id)
+	metrics.Cache("users", found)
+
+	if found {
 		return val, nil
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM users WHERE id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM users WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
 	user := new(utils.User)
-	err = stmt.QueryRow(id).Scan(
+	err = stmt.QueryRowContext(ctx, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.AvatarURL,
@@ -96,28 +137,28 @@ func GetUser(id string) (*utils.User, error) {
 		return nil, err
 	}
 
-	currentUsers = setUser(user)
+	setUser(ctx, user)
 
 	return user, nil
 }
 
-func GetAllUsers() ([]*utils.User, error) {
-	if time.Since(currentUsersSince) > 15*time.Minute {
-		currentUsers = nil
-	}
+func GetAllUsers(ctx context.Context) ([]*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	cached := getUsers(ctx)
+	metrics.Cache("users", len(cached) > 0)
 
-	if currentUsers != nil && len(*currentUsers) > 0 {
-		log.Debug("Returning cached ads list")
-		return *getUsers(), nil
+	if len(cached) > 0 {
+		return cached, nil
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM users ORDER BY id DESC")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM users ORDER BY id DESC")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	users, err := stmt.Query()
+	users, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +184,7 @@ func GetAllUsers() ([]*utils.User, error) {
 			return nil, err
 		}
 
-		currentUsers = setUser(u)
+		setUser(ctx, u)
 
 		out = append(out, u)
 	}
@@ -152,32 +193,39 @@ func GetAllUsers() ([]*utils.User, error) {
 }
 
 // inserts a new user or updates username if it already exists.
-func UpsertUser(id string, username string, avatarUrl string) error {
+func UpsertUser(ctx context.Context, id string, username string, avatarUrl string) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if id == "" {
 		return fmt.Errorf("empty user id")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "INSERT INTO users (id, username, avatar_url) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE username = VALUES (username), avatar_url = VALUES (avatar_url), updated_at = CURRENT_TIMESTAMP")
+	d := utils.DbDialect()
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("INSERT INTO users (id, username, avatar_url) VALUES (?, ?, ?) %s",
+		d.OnConflict("id", "username = "+d.Excluded("username"), "avatar_url = "+d.Excluded("avatar_url"), "updated_at = CURRENT_TIMESTAMP"),
+	))
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id, username, avatarUrl)
+	_, err = stmt.ExecContext(ctx, id, username, avatarUrl)
 	return err
 }
 
 // increments total_views or total_clicks for an ad
-func IncrementAdStat(adID int64, statType utils.AdEvent) error {
+func IncrementAdStat(ctx context.Context, adID int64, statType utils.AdEvent) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	query := fmt.Sprintf("UPDATE advertisements SET %s = %s + 1 WHERE ad_id = ?", statType, statType)
 
-	stmt, err := utils.PrepareStmt(dat, query)
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), query)
 	if err != nil {
 		return fmt.Errorf("failed to prepare increment query: %w", err)
 	}
-	defer stmt.Close()
 
-	if _, err := stmt.Exec(adID); err != nil {
+	if _, err := stmt.ExecContext(ctx, adID); err != nil {
 		return fmt.Errorf("failed to increment %s for ad %d: %w", statType, adID, err)
 	}
 
@@ -185,18 +233,20 @@ func IncrementAdStat(adID int64, statType utils.AdEvent) error {
 }
 
 // increments total_views or total_clicks for a user
-func IncrementUserStats(userId string, viewsDelta int, clicksDelta int) error {
+func IncrementUserStats(ctx context.Context, userId string, viewsDelta int, clicksDelta int) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	if userId == "" {
 		return fmt.Errorf("empty user id")
 	}
 
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET total_views = total_views + ?, total_clicks = total_clicks + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET total_views = total_views + ?, total_clicks = total_clicks + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	user, err := GetUser(userId)
+	user, err := GetUser(ctx, userId)
 	if err != nil {
 		return err
 	}
@@ -204,60 +254,66 @@ func IncrementUserStats(userId string, viewsDelta int, clicksDelta int) error {
 	user.TotalViews += uint64(viewsDelta)
 	user.TotalClicks += uint64(clicksDelta)
 
-	currentUsers = setUser(user)
+	setUser(ctx, user)
 
-	_, err = stmt.Exec(viewsDelta, clicksDelta, userId)
+	_, err = stmt.ExecContext(ctx, viewsDelta, clicksDelta, userId)
 	return err
 }
 
-func VerifyUser(id string, verified bool) (*utils.User, error) {
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET verified = ? WHERE id = ?")
+func VerifyUser(ctx context.Context, id string, verified bool) (*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET verified = ? WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(verified, id)
+	_, err = stmt.ExecContext(ctx, verified, id)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := GetUser(id)
+	user, err := GetUser(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
 	user.Verified = verified
 
-	currentUsers = setUser(user)
+	setUser(ctx, user)
 
 	return user, nil
 }
 
-func StaffUser(id string) (*utils.User, error) {
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET is_staff = TRUE WHERE id = ?")
+func StaffUser(ctx context.Context, id string) (*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET is_staff = TRUE WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.ExecContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return GetUser(id)
+	return GetUser(ctx, id)
 }
 
-func BanUser(id string) (*utils.User, error) {
+func BanUser(ctx context.Context, id string) (*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	// delete all advertisements associated with the user
-	deleteAdsStmt, err := utils.PrepareStmt(dat, "SELECT * FROM advertisements WHERE user_id = ?")
+	deleteAdsStmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM advertisements WHERE user_id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer deleteAdsStmt.Close()
 
-	rows, err := deleteAdsStmt.Query(id)
+	rows, err := deleteAdsStmt.QueryContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -285,64 +341,66 @@ func BanUser(id string) (*utils.User, error) {
 	}
 
 	for _, a := range ads {
-		t, err := utils.AdTypeFromInt(a.Type)
+		adDir, err := utils.ImagePath(&a)
 		if err != nil {
 			return nil, err
 		}
 
-		adDir := filepath.Join("..", "ad_storage", string(t), fmt.Sprintf("%s-%d.webp", a.UserID, a.AdID))
 		err = os.Remove(adDir)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	user, err := GetUser(id)
+	user, err := GetUser(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
 	// ban the user
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET banned = TRUE WHERE id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET banned = TRUE WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.ExecContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	currentUsers = deleteUser(id)
+	deleteUser(ctx, id)
 
 	return user, nil
 }
 
-func UnbanUser(id string) (*utils.User, error) {
+func UnbanUser(ctx context.Context, id string) (*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
 	// unban the user
-	stmt, err := utils.PrepareStmt(dat, "UPDATE users SET banned = FALSE WHERE id = ?")
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "UPDATE users SET banned = FALSE WHERE id = ?")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.ExecContext(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	return GetUser(id)
+	return GetUser(ctx, id)
 }
 
-func UserLeaderboard(stat utils.StatBy, page uint64, maxPerPage uint64) ([]*utils.User, error) {
-	stmt, err := utils.PrepareStmt(dat, fmt.Sprintf("SELECT * FROM users WHERE banned = FALSE ORDER BY %s DESC", stat))
+func UserLeaderboard(ctx context.Context, stat utils.StatBy, page uint64, maxPerPage uint64) ([]*utils.User, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), fmt.Sprintf("SELECT * FROM users WHERE banned = FALSE ORDER BY %s DESC", stat))
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -385,27 +443,31 @@ func UserLeaderboard(stat utils.StatBy, page uint64, maxPerPage uint64) ([]*util
 	return out[start:end], nil
 }
 
-func NewAnnouncement(userID string, title string, content string) error {
-	stmt, err := utils.PrepareStmt(dat, "INSERT INTO announcements (user_id, title, content) VALUES (?, ?)")
+func NewAnnouncement(ctx context.Context, userID string, title string, content string) error {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "INSERT INTO announcements (user_id, title, content) VALUES (?, ?)")
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(userID, title, content)
+	_, err = stmt.ExecContext(ctx, userID, title, content)
 	return err
 }
 
-func GetLatestAnnouncement() (*utils.Announcement, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM announcements ORDER BY created_at DESC LIMIT 1")
+func GetLatestAnnouncement(ctx context.Context) (*utils.Announcement, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM announcements ORDER BY created_at DESC LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
 	announcement := new(utils.Announcement)
 	uid := ""
-	err = stmt.QueryRow().Scan(
+	err = stmt.QueryRowContext(ctx).Scan(
 		&announcement.ID,
 		&uid,
 		&announcement.Title,
@@ -416,7 +478,7 @@ func GetLatestAnnouncement() (*utils.Announcement, error) {
 		return nil, err
 	}
 
-	user, err := GetUser(uid)
+	user, err := GetUser(ctx, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -426,14 +488,16 @@ func GetLatestAnnouncement() (*utils.Announcement, error) {
 	return announcement, nil
 }
 
-func GetAllAnnouncements() ([]*utils.Announcement, error) {
-	stmt, err := utils.PrepareStmt(dat, "SELECT * FROM announcements ORDER BY created_at DESC")
+func GetAllAnnouncements(ctx context.Context) ([]*utils.Announcement, error) {
+	ctx, cancel := utils.WithTimeout(ctx)
+	defer cancel()
+
+	stmt, err := utils.PrepareStmt(ctx, dat.Load(), "SELECT * FROM announcements ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -453,7 +517,7 @@ func GetAllAnnouncements() ([]*utils.Announcement, error) {
 			return nil, err
 		}
 
-		user, err := GetUser(uid)
+		user, err := GetUser(ctx, uid)
 		if err != nil {
 			return nil, err
 		}
@@ -465,13 +529,3 @@ func GetAllAnnouncements() ([]*utils.Announcement, error) {
 
 	return out, nil
 }
-
-func init() {
-	users, err := GetAllUsers()
-	if err != nil {
-		log.Error("Failed to initialize users cache: %s", err.Error())
-	} else {
-		currentUsers = &users
-		log.Info("Initialized users cache with %d users", len(users))
-	}
-}
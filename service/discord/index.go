@@ -1,17 +1,30 @@
 package discord
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"sync/atomic"
 
+	"service/config"
 	"service/database"
+	"service/health"
 	"service/log"
+	"service/metrics"
 	"service/utils"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-var session *discordgo.Session
+// Messages Discord refused or never received, by notification
+var webhookFailures = metrics.NewCounter("discord_webhook_failures_total", "Discord webhook messages that failed to send.", "webhook")
+
+// Webhook notifications about advertisements
+type Webhooks struct {
+	session *discordgo.Session
+	cfg     config.Discord
+	limits  atomic.Pointer[config.Limits] // Follows config reloads
+	users   database.UserRepository
+}
 
 const (
 	WebName   = "Player Advertisements"
@@ -24,46 +37,86 @@ const (
 	colorTertiary  = 6553599
 )
 
-func getSession(private bool) (*discordgo.Session, string, string, error) {
-	if session != nil {
+func New(store *config.Store, users database.UserRepository) *Webhooks {
+	cfg := store.Current()
+	wh := &Webhooks{cfg: cfg.Discord, users: users}
+	store.Subscribe(func(cfg *config.Config) { wh.limits.Store(&cfg.Limits) })
+
+	s, err := discordgo.New("")
+	if err != nil {
+		log.Error(err.Error())
+		return wh
+	}
+
+	// route webhook executions to the configured Discord host
+	discordgo.EndpointWebhooks = cfg.Endpoints.Discord + "/api/v" + discordgo.APIVersion + "/webhooks/"
+
+	wh.session = s
+	return wh
+}
+
+// Ready reports whether notifications can be sent to the configured
+// webhooks. Both are optional, the check is skipped when neither is set.
+func (wh *Webhooks) Ready(context.Context) error {
+	configured := 0
+	for i, webhook := range []config.Webhook{wh.cfg.Webhook, wh.cfg.StaffWebhook} {
+		if webhook.ID == "" && webhook.Token == "" {
+			continue
+		}
+
+		configured++
+		if _, _, _, err := wh.getSession(i == 1); err != nil {
+			return err
+		}
+	}
+
+	if configured == 0 {
+		return fmt.Errorf("no webhook configured: %w", health.ErrSkipped)
+	}
+
+	return nil
+}
+
+func (wh *Webhooks) getSession(private bool) (*discordgo.Session, string, string, error) {
+	if wh.session != nil {
 		var id string
 		var token string
 
 		if private {
-			id = os.Getenv("DISCORD_WH_ID_STAFF")
+			id = wh.cfg.StaffWebhook.ID
 			if id == "" {
 				return nil, "", "", fmt.Errorf("discord staff webhook id variable is not defined!")
 			}
 
-			token = os.Getenv("DISCORD_WH_TOKEN_STAFF")
+			token = wh.cfg.StaffWebhook.Token
 			if token == "" {
 				return nil, "", "", fmt.Errorf("discord staff webhook token variable is not defined!")
 			}
 		} else {
-			id = os.Getenv("DISCORD_WH_ID")
+			id = wh.cfg.Webhook.ID
 			if id == "" {
 				return nil, "", "", fmt.Errorf("discord webhook id variable is not defined!")
 			}
 
-			token = os.Getenv("DISCORD_WH_TOKEN")
+			token = wh.cfg.Webhook.Token
 			if token == "" {
 				return nil, "", "", fmt.Errorf("discord webhook token variable is not defined!")
 			}
 		}
 
-		return session, id, token, nil
+		return wh.session, id, token, nil
 	} else {
 		return nil, "", "", fmt.Errorf("no discord session found")
 	}
 }
 
-func WebhookAccept(ad *utils.Ad, staff *utils.User) error {
-	s, id, token, err := getSession(false)
+func (wh *Webhooks) Accept(ctx context.Context, ad *utils.Ad, staff *utils.User) error {
+	s, id, token, err := wh.getSession(false)
 	if err != nil {
 		return err
 	}
 
-	u, err := database.GetUser(ad.UserID)
+	u, err := wh.users.Get(ctx, ad.UserID)
 	if err != nil {
 		return err
 	}
@@ -115,24 +168,27 @@ func WebhookAccept(ad *utils.Ad, staff *utils.User) error {
 		})
 
 		if err != nil {
-			log.Error(err.Error())
+			webhookFailures.Inc("accept")
+			log.Ctx(ctx).Error(err.Error())
 		}
 	}()
 
 	return nil
 }
 
-func WebhookBoost(ad *utils.Ad) error {
-	s, id, token, err := getSession(false)
+func (wh *Webhooks) Boost(ctx context.Context, ad *utils.Ad) error {
+	s, id, token, err := wh.getSession(false)
 	if err != nil {
 		return err
 	}
 
-	u, err := database.GetUser(ad.UserID)
+	u, err := wh.users.Get(ctx, ad.UserID)
 	if err != nil {
 		return err
 	}
 
+	maxBoosts := wh.limits.Load().Tier(u.IsAdmin || u.IsStaff, u.Verified).MaxAdBoosts
+
 	go func() {
 		_, err = s.WebhookExecute(id, token, false, &discordgo.WebhookParams{
 			Username:  WebName,
@@ -154,7 +210,7 @@ func WebhookBoost(ad *utils.Ad) error {
 						},
 						{
 							Name:   "Boosts",
-							Value:  fmt.Sprintf("**%d** / 30", ad.BoostCount),
+							Value:  fmt.Sprintf("**%d** / %d", ad.BoostCount, maxBoosts),
 							Inline: true,
 						},
 					},
@@ -173,20 +229,21 @@ func WebhookBoost(ad *utils.Ad) error {
 		})
 
 		if err != nil {
-			log.Error(err.Error())
+			webhookFailures.Inc("boost")
+			log.Ctx(ctx).Error(err.Error())
 		}
 	}()
 
 	return nil
 }
 
-func WebhookStaffSubmit(ad *utils.Ad) error {
-	s, id, token, err := getSession(true)
+func (wh *Webhooks) StaffSubmit(ctx context.Context, ad *utils.Ad) error {
+	s, id, token, err := wh.getSession(true)
 	if err != nil {
 		return err
 	}
 
-	u, err := database.GetUser(ad.UserID)
+	u, err := wh.users.Get(ctx, ad.UserID)
 	if err != nil {
 		return err
 	}
@@ -226,20 +283,21 @@ func WebhookStaffSubmit(ad *utils.Ad) error {
 		})
 
 		if err != nil {
-			log.Error(err.Error())
+			webhookFailures.Inc("staff_submit")
+			log.Ctx(ctx).Error(err.Error())
 		}
 	}()
 
 	return nil
 }
 
-func WebhookStaffReject(ad *utils.Ad, staff *utils.User) error {
-	s, id, token, err := getSession(true)
+func (wh *Webhooks) StaffReject(ctx context.Context, ad *utils.Ad, staff *utils.User) error {
+	s, id, token, err := wh.getSession(true)
 	if err != nil {
 		return err
 	}
 
-	u, err := database.GetUser(ad.UserID)
+	u, err := wh.users.Get(ctx, ad.UserID)
 	if err != nil {
 		return err
 	}
@@ -284,19 +342,10 @@ func WebhookStaffReject(ad *utils.Ad, staff *utils.User) error {
 		})
 
 		if err != nil {
-			log.Error(err.Error())
+			webhookFailures.Inc("staff_reject")
+			log.Ctx(ctx).Error(err.Error())
 		}
 	}()
 
 	return nil
 }
-
-func init() {
-	s, err := discordgo.New("")
-	if err != nil {
-		log.Error(err.Error())
-		return
-	}
-
-	session = s
-}
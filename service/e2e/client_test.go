@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+
+	"service/router"
+)
+
+// Browser-like client keeping its own session cookie
+type client struct {
+	base   string
+	http   *http.Client
+	header http.Header // Sent with every request
+}
+
+func newClient(base string) *client {
+	jar, _ := cookiejar.New(nil)
+
+	return &client{
+		base: base,
+		http: &http.Client{
+			Jar: jar,
+			// redirects are asserted on rather than followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		header: make(http.Header),
+	}
+}
+
+// crossSite returns a client sharing the session that sends requests the way
+// a browser does from a page on another site
+func (c *client) crossSite(origin string) *client {
+	header := c.header.Clone()
+	header.Set("Origin", origin)
+	header.Set("Sec-Fetch-Site", "cross-site")
+
+	return &client{base: c.base, http: c.http, header: header}
+}
+
+// response of a finished request with its body already read
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// do sends a request and fails unless it answers with the wanted status, any
+// status is accepted when it is zero
+func (c *client) do(method string, path string, contentType string, body io.Reader, status int) (*response, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &response{status: resp.StatusCode, header: resp.Header, body: b}
+	if status != 0 && out.status != status {
+		return out, fmt.Errorf("%s %s: expected status %d, got %d: %.200s", method, path, status, out.status, b)
+	}
+
+	if spec != nil {
+		if err := spec.check(method, path, out); err != nil {
+			return out, fmt.Errorf("contract: %w", err)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *client) get(path string, status int) (*response, error) {
+	return c.do(http.MethodGet, path, "", nil, status)
+}
+
+func (c *client) post(path string, status int) (*response, error) {
+	return c.do(http.MethodPost, path, "", nil, status)
+}
+
+func (c *client) postJSON(path string, v any, status int) (*response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(http.MethodPost, path, "application/json", bytes.NewReader(b), status)
+}
+
+func (c *client) postForm(path string, fields map[string]string, fileField string, file []byte, status int) (*response, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if fileField != "" {
+		fw, err := mw.CreateFormFile(fileField, "image.webp")
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := fw.Write(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return c.do(http.MethodPost, path, mw.FormDataContentType(), &buf, status)
+}
+
+// expectCode fails unless the response is an error envelope with the given code
+func expectCode(resp *response, code router.Code) error {
+	var body router.ErrorBody
+	if err := decode(resp, &body); err != nil {
+		return err
+	}
+
+	if body.Code != code {
+		return fmt.Errorf("expected error code %s, got %s (%s)", code, body.Code, body.Message)
+	}
+
+	return nil
+}
+
+// decode reads a JSON response body into v
+func decode(resp *response, v any) error {
+	if err := json.Unmarshal(resp.body, v); err != nil {
+		return fmt.Errorf("failed to decode %.200s: %w", resp.body, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Path parameter of an OpenAPI path template
+var wildcard = regexp.MustCompile(`\{[^}]+\}`)
+
+// Published OpenAPI document every API response of the run is checked against
+var spec *contract
+
+type contract struct {
+	Paths      map[string]map[string]*specOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type specOperation struct {
+	OperationID string          `json:"operationId"`
+	Summary     string          `json:"summary"`
+	LegacyPath  string          `json:"x-legacy-path"`
+	Parameters  []specParameter `json:"parameters"`
+	Responses   map[string]struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type specParameter struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+// The subset of OpenAPI schemas the generator writes
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+}
+
+// loadContract fetches the specification the service publishes
+func loadContract(base string) (*contract, error) {
+	resp, err := newClient(base).get("/v1/openapi.json", http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	var c contract
+	if err := decode(resp, &c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Paths) == 0 {
+		return nil, fmt.Errorf("specification has no paths")
+	}
+
+	return &c, nil
+}
+
+// operation finds the documented operation serving a request path, which may
+// be versioned or a legacy alias. Literal segments win over wildcards.
+func (c *contract) operation(method string, path string) (string, *specOperation) {
+	if !strings.HasPrefix(path, "/v1/") {
+		path = "/v1" + path
+	}
+
+	segments := strings.Split(path, "/")
+	best, bestWildcards := "", -1
+	for pattern, item := range c.Paths {
+		if item[strings.ToLower(method)] == nil {
+			continue
+		}
+
+		parts := strings.Split(pattern, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+
+		wildcards := 0
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") {
+				wildcards++
+			} else if part != segments[i] {
+				wildcards = -1
+				break
+			}
+		}
+
+		if wildcards >= 0 && (bestWildcards < 0 || wildcards < bestWildcards) {
+			best, bestWildcards = pattern, wildcards
+		}
+	}
+
+	if best == "" {
+		return "", nil
+	}
+
+	return best, c.Paths[best][strings.ToLower(method)]
+}
+
+// check fails when a response drifts from the documented operation. Requests
+// outside the API, such as the SPA and CDN, are not checked.
+func (c *contract) check(method string, target string, resp *response) error {
+	if method == http.MethodOptions {
+		return nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	pattern, op := c.operation(method, u.Path)
+	if op == nil {
+		return nil
+	}
+
+	for name := range u.Query() {
+		if !slices.Contains(op.Parameters, specParameter{Name: name, In: "query"}) {
+			return fmt.Errorf("%s %s: query parameter %q is not documented", method, pattern, name)
+		}
+	}
+
+	key := strconv.Itoa(resp.status)
+	if resp.status >= 400 {
+		key = "default"
+	}
+
+	documented, found := op.Responses[key]
+	if !found {
+		return fmt.Errorf("%s %s: status %d is not documented", method, pattern, resp.status)
+	}
+
+	// redirects carry whatever note net/http writes for browsers
+	if resp.status >= 300 && resp.status < 400 {
+		return nil
+	}
+
+	if len(documented.Content) == 0 {
+		if len(resp.body) > 0 {
+			return fmt.Errorf("%s %s: documented without a body, got %.200s", method, pattern, resp.body)
+		}
+
+		return nil
+	}
+
+	contentType := resp.header.Get("Content-Type")
+	for mediaType, content := range documented.Content {
+		if !strings.HasPrefix(contentType, mediaType) {
+			continue
+		}
+
+		if mediaType != "application/json" {
+			return nil
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(resp.body))
+		dec.UseNumber()
+
+		var body any
+		if err := dec.Decode(&body); err != nil {
+			return fmt.Errorf("%s %s: invalid JSON body: %w", method, pattern, err)
+		}
+
+		if err := c.validate(content.Schema, body, "body"); err != nil {
+			return fmt.Errorf("%s %s: status %d: %w", method, pattern, resp.status, err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%s %s: undocumented content type %q for status %d", method, pattern, contentType, resp.status)
+}
+
+// validate checks a decoded JSON value against a schema
+func (c *contract) validate(s *schema, v any, at string) error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		ref, found := c.Components.Schemas[name]
+		if !found {
+			return fmt.Errorf("%s: unknown schema %s", at, s.Ref)
+		}
+
+		return c.validate(ref, v, at)
+	}
+
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+
+		return fmt.Errorf("%s: null where %s is expected", at, s.Type)
+	}
+
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", at, v, s.Enum)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", at, v)
+		}
+
+		for _, name := range s.Required {
+			if _, found := obj[name]; !found {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+
+		var additional *schema
+		closed := string(s.AdditionalProperties) == "false"
+		if len(s.AdditionalProperties) > 0 && !closed {
+			if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+				return err
+			}
+		}
+
+		for name, value := range obj {
+			property, found := s.Properties[name]
+			if !found {
+				if closed {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+
+				property = additional
+			}
+
+			if err := c.validate(property, value, at+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", at, v)
+		}
+
+		for i, item := range arr {
+			if err := c.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", at, v)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", at, v)
+		}
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %T", at, s.Type, v)
+		}
+
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				if _, err := strconv.ParseUint(n.String(), 10, 64); err != nil {
+					return fmt.Errorf("%s: expected integer, got %s", at, n)
+				}
+			}
+		}
+
+		if f, err := n.Float64(); err == nil && s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s: %s is below the minimum %v", at, n, *s.Minimum)
+		}
+	}
+
+	return nil
+}
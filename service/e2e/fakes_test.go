@@ -0,0 +1,232 @@
+package e2e
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"service/utils"
+)
+
+// Stand-in for discord.com covering OAuth, the users API and webhooks
+type fakeDiscord struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	codes    map[string]grant             // OAuth code to the account it logs in
+	tokens   map[string]utils.DiscordUser // Issued access tokens
+	webhooks []string                     // Titles of every executed webhook embed
+	images   []string                     // Image URLs of the embeds carrying one
+}
+
+func newFakeDiscord() *fakeDiscord {
+	f := &fakeDiscord{
+		codes:  make(map[string]grant),
+		tokens: make(map[string]utils.DiscordUser),
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/oauth2/authorize", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		g, found := f.codes[r.PostForm.Get("code")]
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !found || r.PostForm.Get("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+
+		token := "token-" + g.user.ID
+		f.tokens[token] = g.user
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": token,
+			"token_type":   "Bearer",
+		})
+	})
+
+	mux.HandleFunc("/api/users/@me", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		user, found := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !found {
+			http.Error(w, `{"message":"401: Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	})
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.Contains(r.URL.Path, "/webhooks/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		var body struct {
+			Embeds []struct {
+				Title string `json:"title"`
+				Image *struct {
+					URL string `json:"url"`
+				} `json:"image"`
+			} `json:"embeds"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid webhook body", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		for _, e := range body.Embeds {
+			f.webhooks = append(f.webhooks, e.Title)
+			if e.Image != nil {
+				f.images = append(f.images, e.Image.URL)
+			}
+		}
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// An authorization code and the PKCE challenge of the login it was issued to
+type grant struct {
+	user      utils.DiscordUser
+	challenge string
+}
+
+// authorize makes an OAuth code log into the given account, once redeemed
+// with the verifier of challenge
+func (f *fakeDiscord) authorize(code string, user utils.DiscordUser, challenge string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.codes[code] = grant{user: user, challenge: challenge}
+}
+
+// webhookCount reports how many webhook embeds have been executed so far
+func (f *fakeDiscord) webhookCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.webhooks)
+}
+
+// lastImage is the image URL of the latest embed carrying one
+func (f *fakeDiscord) lastImage() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.images) == 0 {
+		return ""
+	}
+
+	return f.images[len(f.images)-1]
+}
+
+// waitWebhooks waits for more than n webhook embeds since they are sent in
+// the background, reporting whether they arrived in time
+func (f *fakeDiscord) waitWebhooks(n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if f.webhookCount() > n {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+// Stand-in for argon.globed.dev accepting a fixed set of account tokens
+type fakeArgon struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	tokens map[string]string // Account ID to its valid authtoken
+}
+
+func newFakeArgon() *fakeArgon {
+	f := &fakeArgon{tokens: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/validation/check", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		f.mu.Lock()
+		token, found := f.tokens[query.Get("account_id")]
+		f.mu.Unlock()
+
+		valid := utils.ArgonValidation{Valid: found && token == query.Get("authtoken")}
+		if !valid.Valid {
+			valid.Cause = "token mismatch"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(valid)
+	})
+
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// allow registers a valid authtoken for a player account
+func (f *fakeArgon) allow(accountId int, token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokens[fmt.Sprintf("%d", accountId)] = token
+}
+
+// Stand-in for api.geode-sdk.org reporting a fixed download count
+func newFakeGeode(downloads uint64) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/mods/arcticwoof.player_advertisements", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error":"","payload":{"download_count":%d}}`, downloads)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+// Stand-in for boomlings.com echoing back the requested level
+func newFakeBoomlings() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/database/downloadGJLevel22.php", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("secret") == "" {
+			fmt.Fprint(w, "-1")
+			return
+		}
+
+		fmt.Fprintf(w, "1:%s:2:Fake Level", r.PostForm.Get("levelID"))
+	})
+
+	return httptest.NewServer(mux)
+}
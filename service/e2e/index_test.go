@@ -0,0 +1,253 @@
+// End-to-end tests running the whole service against a throwaway SQLite
+// database and local fakes of Discord, Argon, Geode and Boomlings.
+//
+//	go test ./e2e [-args -keep]
+//
+// TestMain starts the service once. Each test signs in its own accounts and
+// submits its own ads, so none relies on what another left behind.
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"service/config"
+	"service/database"
+	"service/database/migrations"
+	"service/log"
+	"service/router"
+	"service/server"
+	"service/utils"
+)
+
+var keep = flag.Bool("keep", false, "keep the temporary database and ad storage")
+
+// Discord account promoted to admin before the service starts
+var adminUser = utils.DiscordUser{ID: "200000000000000002", Username: "e2e-admin"}
+
+// Service and fakes started by TestMain, copied by every test
+var harness *env
+
+// Numbers the Discord and Argon accounts tests create
+var accounts atomic.Int64
+
+// Service under test and the state a test builds up against it
+type env struct {
+	site     *httptest.Server
+	discord  *fakeDiscord
+	argon    *fakeArgon
+	storage  string
+	admin    *client
+	config   string          // File the service reloads its configuration from
+	database config.Database // Reconnected to after the simulated outage
+	spool    string
+
+	owner     *client // Signed in by signIn
+	ownerUser utils.DiscordUser
+	adId      int64
+	adType    int
+	imageURL  string
+	reportId  int64
+}
+
+// newEnv starts a test on the shared service, without an owner or ads yet
+func newEnv() *env {
+	e := *harness
+	return &e
+}
+
+// flow runs fn on an env of its own
+func flow(fn func(e *env) error) func(t *testing.T) {
+	return func(t *testing.T) {
+		if err := fn(newEnv()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stage runs fn on an env shared with the other stages of a test, returning
+// whether it passed so later stages can be skipped
+func stage(t *testing.T, name string, e *env, fn func(e *env) error) bool {
+	return t.Run(name, func(t *testing.T) {
+		if err := fn(e); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	root, err := os.MkdirTemp("", "gd-ads-e2e-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *keep {
+		fmt.Printf("Keeping harness files in %s\n", root)
+	} else {
+		defer os.RemoveAll(root)
+	}
+
+	// the service reads and writes ad images at ../ad_storage, which a
+	// deployment creates before the first submission
+	work := filepath.Join(root, "work")
+	for _, dir := range []string{work, filepath.Join(root, "ad_storage")} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	if err := os.Chdir(work); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	discord := newFakeDiscord()
+	defer discord.Close()
+
+	argon := newFakeArgon()
+	defer argon.Close()
+
+	geode := newFakeGeode(4242)
+	defer geode.Close()
+
+	boomlings := newFakeBoomlings()
+	defer boomlings.Close()
+
+	// environment overrides still apply, the harness then points everything at the fakes
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+
+	if _, found := os.LookupEnv("LOG_LEVEL"); !found {
+		cfg.LogLevel = 3
+	}
+
+	cfg.Database.Driver = "sqlite"
+	cfg.Database.Path = filepath.Join(root, "e2e.db")
+	cfg.Database.Snapshot = filepath.Join(root, "ads-snapshot.json")
+	cfg.Database.Spool = filepath.Join(root, "events-spool.jsonl")
+	cfg.Database.HealthInterval = config.Duration{Duration: 50 * time.Millisecond}
+	cfg.Discord = config.Discord{
+		ClientID:     "e2e-client",
+		ClientSecret: "e2e-secret",
+		RedirectURI:  "http://localhost/callback",
+		LoginRedirects: []string{
+			"/dashboard",
+			"/admin",
+			trustedOrigin + "/welcome",
+		},
+		Webhook:      config.Webhook{ID: "1", Token: "public"},
+		StaffWebhook: config.Webhook{ID: "2", Token: "staff"},
+	}
+	cfg.Argon.Token = "e2e-argon"
+	cfg.RateLimit = generousLimits
+	cfg.CORS.AllowedOrigins = []string{trustedOrigin}
+	cfg.Endpoints = config.Endpoints{
+		Discord:    discord.URL,
+		DiscordCDN: discord.URL,
+		Argon:      argon.URL,
+		Geode:      geode.URL,
+		Boomlings:  boomlings.URL,
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+
+	// written out so the reload steps can edit it
+	configPath := filepath.Join(root, "config.json")
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err == nil {
+		err = os.WriteFile(configPath, b, 0o644)
+	}
+
+	if err != nil {
+		log.Error("Failed to write harness config: %s", err.Error())
+		return 1
+	}
+
+	store := config.NewStore(configPath, cfg)
+	store.Subscribe(func(cfg *config.Config) {
+		if err := cfg.ApplyLog(); err != nil {
+			log.Error("Failed to configure logging: %s", err.Error())
+		}
+
+		database.SetLimits(cfg.Limits)
+	})
+
+	if err := utils.Connect(cfg.Database); err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	// the outage flow reconnects, closing whichever connection is current
+	defer func() { utils.Db().Close() }()
+	defer utils.CloseStatements()
+
+	if _, err := migrations.Up(utils.Db(), utils.DbDialect(), 0); err != nil {
+		log.Error("Failed to migrate harness database: %s", err.Error())
+		return 1
+	}
+
+	if _, err := utils.Db().Exec("INSERT INTO users (id, username, is_admin) VALUES (?, ?, TRUE)", adminUser.ID, adminUser.Username); err != nil {
+		log.Error("Failed to seed admin user: %s", err.Error())
+		return 1
+	}
+
+	database.SetSpool(cfg.Database.Spool)
+	database.Init(context.Background(), utils.Db())
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	utils.Monitor(monitorCtx, cfg.Database.HealthInterval.Duration, func(ctx context.Context) {
+		database.Init(ctx, utils.Db())
+	})
+
+	site := server.New(store, database.NewSQLRepositories())
+	ts := httptest.NewServer(router.AccessLog(site.Handler))
+	defer ts.Close()
+
+	site.Jobs.Start(monitorCtx)
+	defer site.Jobs.Stop(context.Background())
+
+	spec, err = loadContract(ts.URL)
+	if err != nil {
+		log.Error("Failed to load the OpenAPI specification: %s", err.Error())
+		return 1
+	}
+
+	harness = &env{
+		site:     ts,
+		discord:  discord,
+		argon:    argon,
+		storage:  filepath.Join(root, "ad_storage"),
+		config:   configPath,
+		database: cfg.Database,
+		spool:    cfg.Database.Spool,
+		admin:    newClient(ts.URL),
+	}
+
+	if err := login(harness, harness.admin, "admin-code", adminUser); err != nil {
+		log.Error("Failed to sign in the admin: %s", err.Error())
+		return 1
+	}
+
+	return m.Run()
+}
@@ -0,0 +1,187 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"service/server"
+)
+
+// writeCertificate writes a self-signed pair for 127.0.0.1 with the given serial
+func writeCertificate(certFile string, keyFile string, serial int64) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		return err
+	}
+
+	// renewals within the same second still have to be noticed
+	later := time.Now().Add(time.Duration(serial) * time.Second)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		return err
+	}
+
+	return os.Chtimes(keyFile, later, later)
+}
+
+func nativeTLS(e *env) error {
+	dir := filepath.Join(filepath.Dir(e.config), "tls")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := writeCertificate(certFile, keyFile, 1); err != nil {
+		return err
+	}
+
+	cert, err := server.LoadCertificate(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cert.Watch(ctx, 20*time.Millisecond)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cert.TLSConfig())
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: e.site.Config.Handler}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	base := "https://" + ln.Addr().String()
+	c := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true, // every request sees the current certificate
+	}}
+
+	serial := func() (*http.Response, int64, error) {
+		resp, err := c.Get(base + "/")
+		if err != nil {
+			return nil, 0, err
+		}
+		resp.Body.Close()
+
+		return resp, resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	resp, got, err := serial()
+	if err != nil {
+		return err
+	}
+
+	if got != 1 {
+		return fmt.Errorf("served certificate %d, expected 1", got)
+	}
+
+	for _, name := range []string{"Strict-Transport-Security", "Content-Security-Policy", "X-Frame-Options", "X-Content-Type-Options", "Referrer-Policy"} {
+		if resp.Header.Get(name) == "" {
+			return fmt.Errorf("dashboard served without %s", name)
+		}
+	}
+
+	// a renewal is picked up without a restart
+	if err := writeCertificate(certFile, keyFile, 2); err != nil {
+		return err
+	}
+
+	// the files are checked on a timer, not on every handshake
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if _, got, err = serial(); err != nil {
+			return err
+		} else if got == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("served certificate %d after the renewal, expected 2", got)
+		}
+	}
+
+	// a broken renewal keeps the previous pair
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o644); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		return err
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, got, err = serial(); err != nil {
+		return err
+	} else if got != 2 {
+		return fmt.Errorf("served certificate %d after a broken renewal, expected 2", got)
+	}
+
+	return httpsRedirect()
+}
+
+func httpsRedirect() error {
+	ts := httptest.NewServer(server.Redirect("8443"))
+	defer ts.Close()
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	host, _, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	for method, status := range map[string]int{http.MethodGet: http.StatusMovedPermanently, http.MethodPost: http.StatusPermanentRedirect} {
+		req, err := http.NewRequest(method, ts.URL+"/dashboard?tab=ads", nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		want := "https://" + net.JoinHostPort(host, "8443") + "/dashboard?tab=ads"
+		if resp.StatusCode != status || resp.Header.Get("Location") != want {
+			return fmt.Errorf("%s redirected with %d to %q, expected %d to %q", method, resp.StatusCode, resp.Header.Get("Location"), status, want)
+		}
+	}
+
+	return nil
+}
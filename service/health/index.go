@@ -0,0 +1,205 @@
+// Package health answers the liveness and readiness probes of the
+// orchestrator. Packages register the dependencies they need as checks and
+// report the runs of their background jobs.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"service/log"
+)
+
+// Upper bound of a single check, so a hanging dependency fails the probe
+// instead of stalling it
+const checkTimeout = 2 * time.Second
+
+// Check reports why a dependency is unusable, or nil when it works
+type Check func(ctx context.Context) error
+
+// ErrDegraded is wrapped by checks failing in a way the service works
+// around, which keeps the instance in rotation
+var ErrDegraded = errors.New("degraded")
+
+// ErrSkipped is wrapped by checks of optional dependencies left unconfigured,
+// which never fail the probe
+var ErrSkipped = errors.New("skipped")
+
+// Every check by name, see Register
+var (
+	mu     sync.Mutex
+	checks = make(map[string]Check)
+)
+
+// Register adds a readiness check, replacing one of the same name
+func Register(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	checks[name] = check
+}
+
+// Job is a background task expected to succeed at least every two intervals
+type Job struct {
+	mu      sync.Mutex
+	every   time.Duration
+	since   time.Time // Registration, standing in for the first success
+	success time.Time
+	lastErr error // Of the latest failed run
+}
+
+// NewJob registers the job running every interval as a readiness check
+// named job:name
+func NewJob(name string, every time.Duration) *Job {
+	j := &Job{every: every, since: time.Now()}
+	Register("job:"+name, j.check)
+
+	return j
+}
+
+// Done records the outcome of a run
+func (j *Job) Done(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err != nil {
+		j.lastErr = err
+	} else {
+		j.success = time.Now()
+	}
+}
+
+func (j *Job) check(context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	last := j.success
+	if last.IsZero() {
+		last = j.since
+	}
+
+	if time.Since(last) <= 2*j.every {
+		return nil
+	}
+
+	reason := "never succeeded"
+	if !j.success.IsZero() {
+		reason = "last succeeded at " + j.success.UTC().Format(time.RFC3339)
+	}
+
+	if j.lastErr != nil {
+		return fmt.Errorf("%s: %w", reason, j.lastErr)
+	}
+
+	return errors.New(reason)
+}
+
+// Outcome of the readiness probe
+type Report struct {
+	Status string            `json:"status"` // ok, degraded, or unavailable when a check failed
+	Checks map[string]Result `json:"checks"`
+}
+
+type Result struct {
+	Status   string `json:"status"`          // ok, skipped, degraded or failed
+	Error    string `json:"error,omitempty"` // Why the check failed
+	Duration string `json:"duration"`        // Time taken by the check
+}
+
+// Ready runs every check at once
+func Ready(ctx context.Context) Report {
+	mu.Lock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	all := make([]Check, len(names))
+	for i, name := range names {
+		all[i] = checks[name]
+	}
+	mu.Unlock()
+
+	results := make([]Result, len(all))
+
+	var wg sync.WaitGroup
+	for i, check := range all {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+
+			results[i] = Result{Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+			switch {
+			case errors.Is(err, ErrSkipped):
+				results[i].Status, results[i].Error = "skipped", err.Error()
+			case errors.Is(err, ErrDegraded):
+				results[i].Status, results[i].Error = "degraded", err.Error()
+			case err != nil:
+				results[i].Status, results[i].Error = "failed", err.Error()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	report := Report{Status: "ok", Checks: make(map[string]Result, len(all))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+
+		switch results[i].Status {
+		case "failed":
+			report.Status = "unavailable"
+		case "degraded":
+			if report.Status == "ok" {
+				report.Status = "degraded"
+			}
+		}
+	}
+
+	return report
+}
+
+// Live answers the liveness probe, which only fails when the process can no
+// longer serve requests at all
+func Live(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness answers the readiness probe with the result of every check,
+// failing with 503 so that traffic goes to other instances. A degraded
+// instance still answers 200 as it serves what it can.
+func Readiness(w http.ResponseWriter, r *http.Request) {
+	report := Ready(r.Context())
+
+	status := http.StatusOK
+	if report.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+		for name, res := range report.Checks {
+			if res.Status == "failed" {
+				log.Ctx(r.Context()).Warn("Readiness check %s failed: %s", name, res.Error)
+			}
+		}
+	}
+
+	write(w, status, report)
+}
+
+func write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Failed to encode health report: %s", err.Error())
+	}
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+
+	"service/log"
+	"service/router"
+)
+
+// Register serves the job status and manual triggers, rt must only let
+// administrators through
+func (s *Scheduler) Register(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary:  "List the background jobs and their last runs",
+		Auth:     router.Admin,
+		Response: []Status{},
+	}).Get("/admin/jobs", func(w http.ResponseWriter, r *http.Request) {
+		router.WriteJSON(w, http.StatusOK, s.Status())
+	})
+
+	rt.Doc(router.Operation{
+		Summary: "Run a background job now",
+		Auth:    router.Admin,
+		Path: []router.Param{
+			{Name: "name", Type: "string", Required: true, Description: "Job name, as listed at /admin/jobs"},
+		},
+		Response: Status{},
+		Status:   http.StatusAccepted,
+	}).Post("/admin/jobs/{name}/run", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		err := s.Trigger(name)
+		switch {
+		case errors.Is(err, ErrUnknown):
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such job", router.Details{"resource": "job"})
+			return
+		case errors.Is(err, ErrRunning):
+			router.Error(w, r, http.StatusConflict, router.CodeJobRunning, "The job is already running")
+			return
+		}
+
+		log.Ctx(r.Context()).Print("Job %s triggered by an administrator", name)
+
+		status, _ := s.Get(name)
+		router.WriteJSON(w, http.StatusAccepted, status)
+	})
+}
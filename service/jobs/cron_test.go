@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Thursday
+	from := time.Date(2026, time.January, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.January, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.January, 15, 10, 45, 0, 0, time.UTC)},
+		{"5,10 * * * *", time.Date(2026, time.January, 15, 11, 5, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, time.January, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 4 * * *", time.Date(2026, time.January, 16, 4, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2026, time.January, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"30 9 1 * *", time.Date(2026, time.February, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2026, time.January, 16, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Cron(tt.spec)
+			if err != nil {
+				t.Fatalf("Cron(%q) failed: %v", tt.spec, err)
+			}
+
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, expected %s", from, got, tt.want)
+			}
+
+			if s.String() != tt.spec {
+				t.Errorf("String() = %q, expected %q", s.String(), tt.spec)
+			}
+		})
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@reboot",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"0 0 31 2 *",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := Cron(spec); err == nil {
+				t.Errorf("Cron(%q) succeeded, expected an error", spec)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	from := time.Date(2026, time.January, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+		err  bool
+	}{
+		{spec: "12h", want: from.Add(12 * time.Hour)},
+		{spec: "90s", want: from.Add(90 * time.Second)},
+		{spec: "0 4 * * *", want: time.Date(2026, time.January, 16, 4, 0, 0, 0, time.UTC)},
+		{spec: "0s", err: true},
+		{spec: "-1h", err: true},
+		{spec: "soon", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %s, expected an error", tt.spec, s)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+			}
+
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, expected %s", from, got, tt.want)
+			}
+		})
+	}
+}
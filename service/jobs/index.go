@@ -0,0 +1,326 @@
+// Package jobs runs the periodic work of the service. Each job runs on an
+// interval or a cron spec, never overlapping itself, and can be triggered
+// by administrators at /admin/jobs.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"service/health"
+	"service/log"
+	"service/metrics"
+)
+
+// Upper bound of the random delay added to each run, so instances started
+// together do not hit the database at once
+const maxJitter = time.Minute
+
+var (
+	ErrUnknown = errors.New("no such job")
+	ErrRunning = errors.New("job is already running")
+)
+
+var (
+	jobRuns     = metrics.NewCounter("job_runs_total", "Runs of background jobs by outcome.", "job", "result")
+	jobDuration = metrics.NewHistogram("job_duration_seconds", "Time taken by background job runs.", metrics.DefaultBuckets, "job")
+)
+
+// Func is the work of a job, which stops early once ctx is done
+type Func func(ctx context.Context) error
+
+// Schedule gives the time of the run following t
+type Schedule interface {
+	Next(t time.Time) time.Time
+	String() string
+}
+
+type interval time.Duration
+
+// Every runs a job at a fixed interval
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i interval) String() string {
+	return "every " + time.Duration(i).String()
+}
+
+// Parse reads a schedule written either as a duration like "12h" or as a
+// cron spec like "0 4 * * *"
+func Parse(spec string) (Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", spec)
+		}
+
+		return Every(d), nil
+	}
+
+	return Cron(spec)
+}
+
+// MustParse is Parse for schedules validated beforehand
+func MustParse(spec string) Schedule {
+	s, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// Status of a job as shown at /admin/jobs
+type Status struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"next_run,omitempty"` // Jitter included, absent before Start
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"` // Of the last run, empty when it succeeded
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	run      Func
+	health   *health.Job
+	trigger  chan struct{} // Buffered by one, see Scheduler.Trigger
+
+	mu     sync.Mutex
+	status Status
+}
+
+func (j *job) snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+// Scheduler owns the jobs of the service. Jobs are added before Start.
+type Scheduler struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*job)}
+}
+
+// Add registers a job, along with a readiness check failing once it has not
+// succeeded for two periods. Interval jobs first run right after Start, as
+// a restart would otherwise postpone them, cron jobs wait for their time.
+func (s *Scheduler) Add(name string, schedule Schedule, run Func) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	next := schedule.Next(now)
+
+	s.jobs[name] = &job{
+		name:     name,
+		schedule: schedule,
+		run:      run,
+		health:   health.NewJob(name, schedule.Next(next).Sub(next)),
+		trigger:  make(chan struct{}, 1),
+		status:   Status{Name: name, Schedule: schedule.String()},
+	}
+}
+
+// Start runs every job in the background until Stop or until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.wg.Go(func() { s.loop(ctx, j) })
+	}
+
+	log.Info("Started %d background job(s)", len(s.jobs))
+}
+
+// Stop cancels the running jobs and waits for them to return, or for ctx
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("Background jobs stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background jobs still running: %w", ctx.Err())
+	}
+}
+
+// Trigger queues an immediate run of the job
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	j, found := s.jobs[name]
+	s.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+
+	if j.snapshot().Running {
+		return fmt.Errorf("%w: %s", ErrRunning, name)
+	}
+
+	select {
+	case j.trigger <- struct{}{}:
+	default: // already queued
+	}
+
+	return nil
+}
+
+// Status lists every job by name
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		out = append(out, j.snapshot())
+	}
+
+	slices.SortFunc(out, func(a, b Status) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// Get returns the status of a single job
+func (s *Scheduler) Get(name string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, found := s.jobs[name]
+	if !found {
+		return Status{}, fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+
+	return j.snapshot(), nil
+}
+
+// loop waits for each run in turn, so a job never overlaps itself
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	first := true
+	for {
+		now := time.Now()
+		next := j.schedule.Next(now)
+		if _, isInterval := j.schedule.(interval); isInterval && first {
+			next = now
+		}
+		first = false
+
+		next = next.Add(jitter(j.schedule.Next(next).Sub(next)))
+
+		j.mu.Lock()
+		j.status.NextRun = &next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-j.trigger:
+			timer.Stop()
+			log.Info("Running job %s on demand", j.name)
+		}
+
+		s.execute(ctx, j)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, j *job) {
+	start := time.Now()
+
+	j.mu.Lock()
+	j.status.Running = true
+	j.status.NextRun = nil
+	j.mu.Unlock()
+
+	log.Debug("Running job %s...", j.name)
+	err := safely(ctx, j.run)
+	elapsed := time.Since(start)
+
+	result := "ok"
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// a cancelled run says nothing about the health of the job
+		result = "interrupted"
+		log.Warn("Job %s interrupted by shutdown: %s", j.name, err.Error())
+	case err != nil:
+		result = "error"
+		log.Error("Job %s failed after %s: %s", j.name, elapsed.Round(time.Millisecond), err.Error())
+	}
+
+	if result != "interrupted" {
+		j.health.Done(err)
+	}
+
+	jobRuns.Inc(j.name, result)
+	jobDuration.Observe(elapsed.Seconds(), j.name)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Running = false
+	j.status.LastRun = &start
+	j.status.LastDuration = elapsed.Round(time.Microsecond).String()
+	j.status.LastError = ""
+	j.status.Runs++
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.status.Failures++
+	}
+}
+
+// safely turns a panicking job into a failed run
+func safely(ctx context.Context, run Func) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	return run(ctx)
+}
+
+// jitter picks a delay up to a tenth of the period, capped at maxJitter
+func jitter(period time.Duration) time.Duration {
+	limit := min(period/10, maxJitter)
+	if limit <= 0 {
+		return 0
+	}
+
+	return rand.N(limit)
+}
@@ -0,0 +1,107 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+// Field tags every line logged during a request, such as its ID or account
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Fields of a request, shared by every context derived from it so that
+// middleware deeper in the chain can add to what the access log reports
+type scope struct {
+	mu      sync.Mutex
+	fields  []Field
+	secrets []string // See Redact
+}
+
+type scopeKey struct{}
+
+// WithRequest starts the log context of a request with the given ID
+func WithRequest(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{fields: []Field{{Key: "request_id", Value: id}}})
+}
+
+// Annotate adds a field to the log context of the request, replacing a field
+// of the same key. Outside of a request it does nothing.
+func Annotate(ctx context.Context, key string, value string) {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.fields {
+		if f.Key == key {
+			s.fields[i].Value = value
+			return
+		}
+	}
+
+	s.fields = append(s.fields, Field{Key: key, Value: value})
+}
+
+// Fields returns a copy of the fields of the request
+func Fields(ctx context.Context) []Field {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Field(nil), s.fields...)
+}
+
+// RequestID returns the ID given by WithRequest, empty outside of a request
+func RequestID(ctx context.Context) string {
+	for _, f := range Fields(ctx) {
+		if f.Key == "request_id" {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+// Logger writes lines tagged with the fields of a request
+type Logger struct {
+	ctx context.Context
+}
+
+// Ctx returns a logger for the request the context belongs to. Outside of a
+// request it writes the same lines as the package functions.
+func Ctx(ctx context.Context) Logger {
+	return Logger{ctx: ctx}
+}
+
+func (l Logger) Debug(format any, a ...any) {
+	logf(l.ctx, LevelDebug, format, a...)
+}
+
+func (l Logger) Info(format any, a ...any) {
+	logf(l.ctx, LevelInfo, format, a...)
+}
+
+func (l Logger) Warn(format any, a ...any) {
+	logf(l.ctx, LevelWarn, format, a...)
+}
+
+func (l Logger) Error(format any, a ...any) {
+	logf(l.ctx, LevelError, format, a...)
+}
+
+func (l Logger) Done(format any, a ...any) {
+	logf(l.ctx, LevelDone, format, a...)
+}
+
+func (l Logger) Print(format any, a ...any) {
+	logf(l.ctx, LevelPrint, format, a...)
+}
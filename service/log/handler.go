@@ -0,0 +1,235 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Import path of the module, left out of package names in level overrides
+const module = "service/"
+
+// root filters records by level and hands them to every sink. Loggers
+// derived through WithAttrs and WithGroup keep their attributes in ops.
+var root slog.Handler = &handler{}
+
+type handler struct {
+	ops []op
+}
+
+// An attribute list or a group opened by a derived logger
+type op struct {
+	attrs []slog.Attr
+	group string
+}
+
+// Slog returns a structured logger writing to the same sinks as the
+// printf-style functions, with the fields of the request in its context
+func Slog() *slog.Logger {
+	return slog.New(root)
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().lowest()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := current.Load()
+	if r.Level < out.level(packageOf(r.PC)) {
+		return nil
+	}
+
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	// attributes added after a group belong inside it
+	for i := len(h.ops) - 1; i >= 0; i-- {
+		if o := h.ops[i]; o.group != "" {
+			attrs = []slog.Attr{{Key: o.group, Value: slog.GroupValue(attrs...)}}
+		} else {
+			attrs = append(append([]slog.Attr(nil), o.attrs...), attrs...)
+		}
+	}
+
+	redact := newRedactor(ctx)
+
+	rec := slog.NewRecord(r.Time, r.Level, redact.string(r.Message), r.PC)
+	for _, f := range Fields(ctx) {
+		rec.AddAttrs(slog.String(f.Key, f.Value))
+	}
+
+	for _, a := range attrs {
+		rec.AddAttrs(redact.attr(a))
+	}
+
+	var first error
+	for _, sink := range out.sinks {
+		if err := sink.Handle(ctx, rec.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{ops: append(h.ops[:len(h.ops):len(h.ops)], op{attrs: attrs})}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &handler{ops: append(h.ops[:len(h.ops):len(h.ops)], op{group: name})}
+}
+
+// Package of the function at pc relative to the module, such as database or
+// database/migrations, cached as the set of call sites is small
+var callers sync.Map
+
+func packageOf(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+
+	if pkg, found := callers.Load(pc); found {
+		return pkg.(string)
+	}
+
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+
+	// service/database.(*SQLAds).Get.func1
+	name := frame.Function
+	slash := strings.LastIndexByte(name, '/')
+	if dot := strings.IndexByte(name[slash+1:], '.'); dot >= 0 {
+		name = name[:slash+1+dot]
+	}
+
+	pkg := strings.TrimPrefix(name, module)
+	callers.Store(pc, pkg)
+
+	return pkg
+}
+
+// console writes the human-readable text format:
+//
+//	2025-01-02T15:04:05Z UTC | INFO | message key=value
+type console struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	color bool
+}
+
+const (
+	reset  = "\033[0m"
+	gray   = "\033[90m"
+	blue   = "\033[34m"
+	yellow = "\033[33m"
+	red    = "\033[31m"
+	green  = "\033[32m"
+)
+
+var colors = []string{gray, blue, yellow, red, green, reset}
+
+func newConsole(w io.Writer, color bool) *console {
+	return &console{mu: &sync.Mutex{}, w: w, color: color}
+}
+
+func (c *console) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (c *console) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	b.WriteString(r.Time.UTC().Format(time.RFC3339))
+	b.WriteString(" UTC ")
+
+	if c.color {
+		b.WriteString(colors[levelIndex(r.Level)])
+	}
+
+	b.WriteString("| ")
+	b.WriteString(tag(r.Level))
+	b.WriteString(" | ")
+	b.WriteString(r.Message)
+
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, "", a)
+		return true
+	})
+
+	if c.color {
+		b.WriteString(" " + reset)
+	}
+
+	b.WriteByte('\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.w.Write(b.Bytes())
+	return err
+}
+
+// writeAttr appends key=value, flattening groups into dotted keys
+func writeAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+
+		for _, inner := range v.Group() {
+			writeAttr(b, prefix, inner)
+		}
+
+		return
+	}
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	s := v.String()
+	if v.Kind() == slog.KindTime {
+		s = v.Time().UTC().Format(time.RFC3339)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \"=\n\t") {
+		s = strconv.Quote(s)
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(prefix + a.Key)
+	b.WriteByte('=')
+	b.WriteString(s)
+}
+
+func (c *console) WithAttrs([]slog.Attr) slog.Handler {
+	// root applies attributes before records reach the sinks
+	return c
+}
+
+func (c *console) WithGroup(string) slog.Handler {
+	return c
+}
+
+func levelIndex(level slog.Level) int {
+	for i := len(levels) - 1; i >= 0; i-- {
+		if level >= levels[i] {
+			return i
+		}
+	}
+
+	return 0
+}
@@ -1,100 +1,117 @@
 package log
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"strconv"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
 	"time"
 )
 
-var LogLevel int = 0
-var cached bool = false
-
+// Levels of the printf-style functions. Done and Print rank above errors so
+// milestones such as startup and shutdown still show at LOG_LEVEL 3 and 4.
 const (
-	reset  = "\033[0m"
-	gray   = "\033[90m"
-	blue   = "\033[34m"
-	yellow = "\033[33m"
-	red    = "\033[31m"
-	green  = "\033[32m"
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+	LevelDone  = slog.Level(10)
+	LevelPrint = slog.Level(12)
+)
+
+// Levels in LOG_LEVEL order, with the tags printed by the text format
+var (
+	levels = []slog.Level{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelDone, LevelPrint}
+	tags   = []string{"DEBUG", "INFO", "WARN", "ERROR", "DONE", " LOG "}
+	names  = []string{"debug", "info", "warn", "error", "done", "print"}
 )
 
+// Minimum LOG_LEVEL printed, changes at runtime on config reloads
+var logLevel atomic.Int32
+
+// SetLevel hides every message below the given level, set with LOG_LEVEL
+func SetLevel(level int) {
+	logLevel.Store(int32(level))
+}
+
+// Level returns the minimum level printed
+func Level() int {
+	return getLogLevel()
+}
+
 func getLogLevel() int {
-	if cached {
-		return LogLevel
-	} else {
-		level, done := os.LookupEnv("LOG_LEVEL")
-
-		if done {
-			val, err := strconv.Atoi(level)
-
-			if err == nil {
-				LogLevel = val
-			} else {
-				LogLevel = 1
-			}
-		} else {
-			LogLevel = 0
-		}
+	return int(logLevel.Load())
+}
 
-		cached = true
-		return LogLevel
+// ParseLevel reads a level given by name, such as warn, or by its LOG_LEVEL number
+func ParseLevel(s string) (int, error) {
+	for i, name := range names {
+		if s == name || s == fmt.Sprint(i) {
+			return i, nil
+		}
 	}
+
+	return 0, fmt.Errorf("unknown log level %q, expected one of %v or 0 to %d", s, names, len(names)-1)
 }
 
-func writeConsole(color string, tag string, format any, a ...any) {
-	utc := time.Now().UTC()
-	timeStamp := fmt.Sprintf("%s UTC", utc.Format(time.RFC3339))
+// toSlog converts a LOG_LEVEL number, clamping it to the known levels
+func toSlog(level int) slog.Level {
+	return levels[max(0, min(level, len(levels)-1))]
+}
 
-	level := fmt.Sprintf("%s| %s |", color, tag)
+// tag names a level in the text format
+func tag(level slog.Level) string {
+	return tags[levelIndex(level)]
+}
 
-	var message string
+func message(format any, a ...any) string {
 	switch v := format.(type) {
 	case string:
 		if len(a) > 0 {
-			message = fmt.Sprintf(v, a...)
-		} else {
-			message = v
+			return fmt.Sprintf(v, a...)
 		}
+
+		return v
 	default:
-		message = fmt.Sprint(format)
+		return fmt.Sprint(format)
+	}
+}
+
+// logf writes a printf-style line through the slog handler, recording the
+// caller of the exported function so per-package levels apply
+func logf(ctx context.Context, level slog.Level, format any, a ...any) {
+	if !root.Enabled(ctx, level) {
+		return
 	}
 
-	fmt.Println(timeStamp, level, message, reset)
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	r := slog.NewRecord(time.Now(), level, message(format, a...), pcs[0])
+	_ = root.Handle(ctx, r)
 }
 
 func Debug(format any, a ...any) {
-	if getLogLevel() <= 0 {
-		writeConsole(gray, "DEBUG", format, a...)
-	}
+	logf(context.Background(), LevelDebug, format, a...)
 }
 
 func Info(format any, a ...any) {
-	if getLogLevel() <= 1 {
-		writeConsole(blue, "INFO", format, a...)
-	}
+	logf(context.Background(), LevelInfo, format, a...)
 }
 
 func Warn(format any, a ...any) {
-	if getLogLevel() <= 2 {
-		writeConsole(yellow, "WARN", format, a...)
-	}
+	logf(context.Background(), LevelWarn, format, a...)
 }
 
 func Error(format any, a ...any) {
-	if getLogLevel() <= 3 {
-		writeConsole(red, "ERROR", format, a...)
-	}
+	logf(context.Background(), LevelError, format, a...)
 }
 
 func Done(format any, a ...any) {
-	if getLogLevel() <= 4 {
-		writeConsole(green, "DONE", format, a...)
-	}
+	logf(context.Background(), LevelDone, format, a...)
 }
 
 func Print(format any, a ...any) {
-	if getLogLevel() <= 5 {
-		writeConsole(reset, " LOG ", format, a...)
-	}
+	logf(context.Background(), LevelPrint, format, a...)
 }
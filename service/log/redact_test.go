@@ -0,0 +1,86 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "token in a query", in: "GET /api/ad?authtoken=abc123&type=1", want: "GET /api/ad?authtoken=[REDACTED]&type=1"},
+		{name: "secret in a form", in: "client_id=1&client_secret=hunter2&grant_type=authorization_code", want: "client_id=1&client_secret=[REDACTED]&grant_type=authorization_code"},
+		{name: "session cookie", in: "Cookie: session_id=abcdef; theme=dark", want: "Cookie: session_id=[REDACTED]; theme=dark"},
+		{name: "OAuth callback", in: "GET /callback?code=xyz789&state=s1", want: "GET /callback?code=[REDACTED]&state=s1"},
+		{name: "OAuth token exchange", in: "client_id=1&code=xyz789&code_verifier=v1", want: "client_id=1&code=[REDACTED]&code_verifier=[REDACTED]"},
+		{name: "JSON field", in: `{"verification_token": "abc", "user": "1"}`, want: `{"verification_token": "[REDACTED]", "user": "1"}`},
+		{name: "password in a URL", in: "dialing mysql://root:hunter2@db:3306/ads", want: "dialing mysql://root:[REDACTED]@db:3306/ads"},
+		{name: "password in a DSN", in: "root:hunter2@tcp(db:3306)/ads", want: "root:[REDACTED]@tcp(db:3306)/ads"},
+		{name: "authorization header", in: "Authorization: Bot abc.def-ghi", want: "Authorization: Bot [REDACTED]"},
+		{name: "error code", in: `{"code":"NOT_FOUND","message":"code=NOT_FOUND"}`, want: `{"code":"NOT_FOUND","message":"code=NOT_FOUND"}`},
+		{name: "passed checks", in: "pass=3 passes", want: "pass=3 passes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (redactor{}).string(tt.in); got != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	r := redactor{}
+
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{name: "sensitive key", attr: slog.String("Token", "abc"), want: redacted},
+		{name: "error code", attr: slog.String("code", "NOT_FOUND"), want: "NOT_FOUND"},
+		{name: "status", attr: slog.Int("status", 404), want: "404"},
+		{name: "secret in a value", attr: slog.String("url", "/callback?code=xyz789"), want: "/callback?code=" + redacted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.attr(tt.attr).Value.String(); got != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		got := r.attr(slog.Group("oauth", slog.String("access_token", "abc"), slog.String("scope", "identify")))
+		group := got.Value.Group()
+		if group[0].Value.String() != redacted || group[1].Value.String() != "identify" {
+			t.Errorf("got %v", got)
+		}
+	})
+}
+
+func TestRedactValues(t *testing.T) {
+	Secrets("database-password", "short")
+	t.Cleanup(func() { Secrets() })
+
+	ctx := WithRequest(context.Background(), "req")
+	Redact(ctx, "session-cookie-value")
+	Redact(context.Background(), "outside-of-a-request")
+
+	r := newRedactor(ctx)
+	got := r.string("database-password session-cookie-value short outside-of-a-request")
+	if want := redacted + " " + redacted + " short outside-of-a-request"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+
+	// other requests only get the global secrets
+	if got := newRedactor(WithRequest(context.Background(), "other")).string("session-cookie-value"); got != "session-cookie-value" {
+		t.Errorf("secret of a request leaked into another: %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Defaults when the configuration leaves rotation unset
+const (
+	defaultMaxSize  = 10 << 20
+	defaultMaxFiles = 5
+)
+
+// rotating appends to a file and, once it reaches maxSize bytes, shifts it to
+// path.1, path.1 to path.2 and so on, dropping the oldest past maxFiles
+type rotating struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File // Nil after a failed rotation, reopened by the next write
+	size     int64
+	closed   bool
+}
+
+func openRotating(path string, maxSize int64, maxFiles int) (*rotating, error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &rotating{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotating) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotating) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+		// a failed rotation keeps appending rather than losing lines
+		if err := r.rotate(); err != nil && r.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(b)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotating) rotate() error {
+	if err := r.file.Close(); err != nil {
+		r.file = nil
+		return err
+	}
+
+	// the oldest file is overwritten by the rename into its place
+	var err error
+	for i := r.maxFiles - 1; i >= 1 && err == nil; i-- {
+		from := fmt.Sprintf("%s.%d", r.path, i)
+		if _, statErr := os.Stat(from); statErr == nil {
+			err = os.Rename(from, fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+	}
+
+	if err == nil {
+		err = os.Rename(r.path, r.path+".1")
+	}
+
+	// keep writing to the current file when shifting failed
+	if openErr := r.open(); openErr != nil {
+		r.file = nil
+		return openErr
+	}
+
+	return err
+}
+
+func (r *rotating) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
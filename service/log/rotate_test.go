@@ -0,0 +1,77 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotating(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "service.log")
+
+	r, err := openRotating(path, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// every line fills the file, so each write after the first rotates
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{"": "line 5\n", ".1": "line 4\n", ".2": "line 3\n", ".3": "line 2\n"} {
+		b, err := os.ReadFile(path + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != want {
+			t.Errorf("%s holds %q, expected %q", filepath.Base(path+name), b, want)
+		}
+	}
+
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 3 rotated files: %v", err)
+	}
+}
+
+func TestRotatingAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	if err := os.WriteFile(path, []byte("before restart\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotating(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.maxSize != defaultMaxSize || r.maxFiles != defaultMaxFiles {
+		t.Errorf("got limits %d and %d, expected the defaults", r.maxSize, r.maxFiles)
+	}
+
+	if _, err := r.Write([]byte("after restart\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "before restart\n") || r.size != int64(len(b)) {
+		t.Errorf("got %q with size %d after reopening", b, r.size)
+	}
+
+	if _, err := r.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("wrote after closing: %v", err)
+	}
+}
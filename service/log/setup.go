@@ -0,0 +1,155 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Options of the sinks, built from the log section of the configuration
+type Options struct {
+	Format   string         // text or json
+	File     string         // Also write to this file when set
+	MaxSize  int64          // Bytes written before the file rotates
+	MaxFiles int            // Rotated files kept next to it
+	Packages map[string]int // LOG_LEVEL of packages such as database or access, overriding SetLevel
+}
+
+// Sinks and levels in effect, swapped as a whole by Configure
+type output struct {
+	sinks    []slog.Handler
+	file     *rotating
+	opts     Options
+	packages map[string]slog.Level
+}
+
+var current atomic.Pointer[output]
+
+func init() {
+	current.Store(&output{sinks: []slog.Handler{newConsole(stdout{}, true)}})
+
+	// libraries logging through log/slog or the standard logger share the sinks
+	slog.SetDefault(Slog())
+}
+
+// Configure replaces the sinks. The log file is kept open when its settings
+// did not change, so configuration reloads do not reopen it.
+func Configure(opts Options) error {
+	prev := current.Load()
+
+	var file *rotating
+	if opts.File != "" {
+		if prev.file != nil && prev.opts.File == opts.File && prev.opts.MaxSize == opts.MaxSize && prev.opts.MaxFiles == opts.MaxFiles {
+			file = prev.file
+		} else {
+			f, err := openRotating(opts.File, opts.MaxSize, opts.MaxFiles)
+			if err != nil {
+				return fmt.Errorf("failed to open log file: %w", err)
+			}
+
+			file = f
+		}
+	}
+
+	next := &output{file: file, opts: opts, packages: make(map[string]slog.Level)}
+	for pkg, level := range opts.Packages {
+		next.packages[strings.Trim(pkg, "/")] = toSlog(level)
+	}
+
+	switch opts.Format {
+	case "json":
+		next.sinks = append(next.sinks, newJSON(stdout{}))
+		if file != nil {
+			next.sinks = append(next.sinks, newJSON(file))
+		}
+	case "", "text":
+		next.sinks = append(next.sinks, newConsole(stdout{}, true))
+		if file != nil {
+			next.sinks = append(next.sinks, newConsole(file, false))
+		}
+	default:
+		if file != nil && file != prev.file {
+			file.Close()
+		}
+
+		return fmt.Errorf("unknown log format %q, expected text or json", opts.Format)
+	}
+
+	current.Store(next)
+
+	if prev.file != nil && prev.file != file {
+		prev.file.Close()
+	}
+
+	return nil
+}
+
+// Close flushes and closes the log file, later lines only go to stdout
+func Close() error {
+	prev := current.Load()
+	if prev.file == nil {
+		return nil
+	}
+
+	next := *prev
+	next.file = nil
+	next.opts.File = ""
+	next.sinks = prev.sinks[:1]
+	current.Store(&next)
+
+	return prev.file.Close()
+}
+
+// level is the minimum level of a package, from the longest matching override
+func (o *output) level(pkg string) slog.Level {
+	best := -1
+	level := toSlog(getLogLevel())
+	for prefix, l := range o.packages {
+		if (pkg == prefix || strings.HasPrefix(pkg, prefix+"/")) && len(prefix) > best {
+			best, level = len(prefix), l
+		}
+	}
+
+	return level
+}
+
+// lowest is the level below which no package logs anything
+func (o *output) lowest() slog.Level {
+	lowest := toSlog(getLogLevel())
+	for _, l := range o.packages {
+		lowest = min(lowest, l)
+	}
+
+	return lowest
+}
+
+// newJSON writes one JSON object per line, naming the levels like ParseLevel
+func newJSON(w io.Writer) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+
+			switch a.Key {
+			case slog.LevelKey:
+				a.Value = slog.StringValue(names[levelIndex(a.Value.Any().(slog.Level))])
+			case slog.TimeKey:
+				a.Value = slog.TimeValue(a.Value.Time().UTC())
+			}
+
+			return a
+		},
+	})
+}
+
+// stdout writes to whatever os.Stdout is at the time of the write
+type stdout struct{}
+
+func (stdout) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
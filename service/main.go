@@ -3,143 +3,121 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
 
-	"service/access"
-	_ "service/ads"
-	_ "service/api"
+	"service/config"
 	"service/database"
 	"service/log"
-	_ "service/proxy"
-	_ "service/stats"
-
-	"github.com/patrickmn/go-cache"
-	"golang.org/x/time/rate"
+	"service/router"
+	"service/server"
+	"service/utils"
 )
 
-var visitors = cache.New(15*time.Minute, 30*time.Minute)
-
-func getClientIP(r *http.Request) string {
-	if cf := r.Header.Get("CF-Connecting-IP"); cf != "" {
-		return cf
-	}
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return strings.Split(xff, ",")[0]
-	}
-	return strings.Split(r.RemoteAddr, ":")[0]
-}
-
-func getVisitor(ip string) *rate.Limiter {
-	if val, found := visitors.Get(ip); found {
-		return val.(*rate.Limiter)
+func main() {
+	cfg, err := config.Load(config.DefaultPath)
+	if err != nil {
+		log.Error("Failed to load configuration: %s", err.Error())
+		os.Exit(1)
 	}
 
-	limiter := rate.NewLimiter(10, 30)
-	visitors.Set(ip, limiter, cache.DefaultExpiration)
-	return limiter
-}
-
-func rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
-		limiter := getVisitor(ip)
-
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+	store := config.NewStore(config.DefaultPath, cfg)
+	store.Subscribe(func(cfg *config.Config) {
+		if err := cfg.ApplyLog(); err != nil {
+			log.Error("Failed to configure logging: %s", err.Error())
 		}
 
-		next.ServeHTTP(w, r)
+		database.SetLimits(cfg.Limits)
 	})
-}
 
-func expiryCleanupRoutine() {
-	go func() {
-		for {
-			log.Debug("Deleting expired ad records...")
-			err := database.DeleteAllExpiredAds()
-			if err != nil {
-				log.Error("Failed to delete expired ad records: %s", err.Error())
-			} else {
-				log.Info("Expired ad records cleanup complete")
-			}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := cfg.ValidateDatabase(); err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
 
-			time.Sleep(12 * time.Hour) // Run twice a day
+		if err := utils.Connect(cfg.Database); err != nil {
+			log.Error(err.Error())
 		}
-	}()
-}
 
-func main() {
-	log.Print("Starting server...")
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
 
-	port := os.Getenv("WEB_PORT")
-	if port == "" {
-		log.Warn("WEB_PORT variable is not set")
-		port = "3000"
+	log.Print(cfg.Report())
+	if err := cfg.Validate(); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
 	}
 
-	srv := &http.Server{Addr: fmt.Sprintf(":%s", port)}
+	if err := utils.Connect(cfg.Database); err != nil {
+		log.Error("%s, starting degraded until it answers", err.Error())
+	}
 
-	// SPA fallback
-	log.Debug("Setting up SPA fallback for client-side routing")
-	staticDir := "../dist"
-	fs := http.FileServer(http.Dir(staticDir))
+	log.Print("Starting server...")
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Received request for host %s", access.FullURL(r))
+	if cfg.Database.AutoMigrate {
+		autoMigrate()
+	} else {
+		log.Warn("Automatic migrations are disabled")
+	}
 
-		requestedPath := strings.TrimPrefix(filepath.Clean(r.URL.Path), "/")
-		fullPath := filepath.Join(staticDir, requestedPath)
-		if requestedPath == "" || requestedPath == "." {
-			http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
-			return
-		}
+	// cancelled once shutdown gives up on in-flight requests and their queries
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	database.SetSpool(cfg.Database.Spool)
+	database.Init(baseCtx, utils.Db())
 
-		info, err := os.Stat(fullPath)
-		if err == nil && !info.IsDir() {
-			fs.ServeHTTP(w, r)
-			return
+	// caches and the schema are rebuilt once an outage ends, spooled events replayed
+	utils.Monitor(baseCtx, cfg.Database.HealthInterval.Duration, func(ctx context.Context) {
+		if cfg.Database.AutoMigrate {
+			autoMigrate()
 		}
 
-		log.Debug("Serving index.html for SPA route: %s", r.URL.Path)
-		http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
+		database.Init(ctx, utils.Db())
 	})
 
-	log.Debug("Starting image handler...")
-	http.HandleFunc("/cdn/", func(w http.ResponseWriter, r *http.Request) {
-		header := w.Header()
+	repos := database.NewSQLRepositories()
+	site := server.New(store, repos)
 
-		requestedPath := strings.TrimPrefix(r.URL.Path, "/cdn/")
-		fullPath := filepath.Join("..", "ad_storage", requestedPath)
-
-		// Set cache control headers to prevent browser caching of ad images
-		header.Set("Content-Type", "image/webp")
-		header.Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		header.Set("Pragma", "no-cache")
-		header.Set("Expires", "0")
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%s", cfg.WebPort),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
 
-		http.ServeFile(w, r, fullPath)
-	})
+	scheme := "http"
+	if cfg.TLS.Cert != "" {
+		cert, err := server.LoadCertificate(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
 
-	log.Debug("Starting handlers...")
-	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
-		log.Info("Server pinged!")
-		header := w.Header()
+		cert.Watch(baseCtx, cfg.TLS.CheckEvery.Duration)
+		srv.TLSConfig = cert.TLSConfig()
+		scheme = "https"
+	}
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-		header.Set("Content-Type", "text/plain")
+	// plain HTTP only answers with the way to HTTPS
+	var redirect *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		redirect = &http.Server{
+			Addr:              fmt.Sprintf(":%s", cfg.TLS.RedirectPort),
+			Handler:           server.Redirect(cfg.WebPort),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
 
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "pong!")
-	})
+		go func() {
+			log.Info("Redirecting HTTP on port %s to HTTPS", cfg.TLS.RedirectPort)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error(err.Error())
+			}
+		}()
+	}
 
 	go func() {
 		defer func() {
@@ -148,16 +126,38 @@ func main() {
 			}
 		}()
 
-		log.Debug("Starting expiry routines...")
-		expiryCleanupRoutine()
+		site.Jobs.Start(baseCtx)
+
+		log.Done("Server started successfully! Serving at %s://localhost%s", scheme, srv.Addr)
+		srv.Handler = router.AccessLog(site.Handler)
+
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
 
-		log.Done("Server started successfully! Serving at http://localhost%s", srv.Addr)
-		srv.Handler = rateLimitMiddleware(http.DefaultServeMux)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err != nil && err != http.ErrServerClosed {
 			log.Error(err.Error())
 		}
 	}()
 
+	// reload the runtime configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			res, err := store.Reload()
+			if err != nil {
+				log.Error("Failed to reload configuration: %s", err.Error())
+				continue
+			}
+
+			log.Print("Configuration reloaded: %s", res)
+		}
+	}()
+
 	// shutdown sequence
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -165,8 +165,6 @@ func main() {
 
 	log.Warn("Shutting down server...")
 
-	access.StopSessionCleanup()
-
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -174,4 +172,16 @@ func main() {
 	} else {
 		log.Print("Server stopped")
 	}
+
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+
+	if err := site.Jobs.Stop(ctx); err != nil {
+		log.Error(err.Error())
+	}
+
+	cancelRequests()
+	utils.CloseStatements()
+	log.Close()
 }
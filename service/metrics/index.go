@@ -0,0 +1,306 @@
+// Package metrics keeps counters, histograms and gauges and publishes them
+// in the Prometheus text format. Packages declare their own metrics as
+// package variables, the handler of /metrics writes every one of them.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"service/log"
+)
+
+// Prefix of every metric name
+const namespace = "gdads_"
+
+// Upper bounds in seconds of the latency histograms
+var (
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	QueryBuckets   = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+)
+
+// Every metric by name, see register
+var (
+	mu       sync.Mutex
+	families = make(map[string]family)
+)
+
+type family interface {
+	write(ctx context.Context, b *bytes.Buffer) error
+}
+
+// register adds a metric, replacing one of the same name so that gauges
+// can be bound again to new repositories
+func register(name string, f family) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	families[name] = f
+}
+
+// Label values of a series joined into a map key
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func header(b *bytes.Buffer, name string, help string, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one line, with extra appended to the labels of the series
+func sample(b *bytes.Buffer, name string, labels []string, values []string, extra string, v float64) {
+	b.WriteString(name)
+
+	var pairs []string
+	for i, l := range labels {
+		pairs = append(pairs, l+`="`+escape.Replace(values[i])+`"`)
+	}
+
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) > 0 {
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	b.WriteString(" " + number(v) + "\n")
+}
+
+// Escapes of label values in the text format
+var escape = strings.NewReplacer("\\", `\\`, `"`, `\"`, "\n", `\n`)
+
+func number(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Series of a metric by their label values
+type vec[T any] struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string][]string
+	series map[string]*T
+}
+
+func newVec[T any](labels []string) vec[T] {
+	return vec[T]{labels: labels, values: make(map[string][]string), series: make(map[string]*T)}
+}
+
+// with returns the series of the label values, creating it with fn. It is
+// called with the lock held.
+func (v *vec[T]) with(values []string, fn func() *T) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: expected values for labels %v, got %v", v.labels, values))
+	}
+
+	k := key(values)
+	s, found := v.series[k]
+	if !found {
+		s = fn()
+		v.values[k] = slices.Clone(values)
+		v.series[k] = s
+	}
+
+	return s
+}
+
+// sorted returns the keys of every series in label order
+func (v *vec[T]) sorted() []string {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+// Counter only goes up, such as the number of ads served
+type Counter struct {
+	name string
+	help string
+	vec  vec[float64]
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{name: namespace + name, help: help, vec: newVec[float64](labels)}
+	register(c.name, c)
+
+	return c
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(n float64, values ...string) {
+	c.vec.mu.Lock()
+	defer c.vec.mu.Unlock()
+
+	*c.vec.with(values, func() *float64 { return new(float64) }) += n
+}
+
+func (c *Counter) write(_ context.Context, b *bytes.Buffer) error {
+	c.vec.mu.Lock()
+	defer c.vec.mu.Unlock()
+
+	header(b, c.name, c.help, "counter")
+	for _, k := range c.vec.sorted() {
+		sample(b, c.name, c.vec.labels, c.vec.values[k], "", *c.vec.series[k])
+	}
+
+	return nil
+}
+
+// Histogram counts observations, such as latencies, into buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	vec     vec[histogram]
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, sorted in
+// increasing order, and label names
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: namespace + name, help: help, buckets: buckets, vec: newVec[histogram](labels)}
+	register(h.name, h)
+
+	return h
+}
+
+// Observe records a value in the series of the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.vec.mu.Lock()
+	defer h.vec.mu.Unlock()
+
+	s := h.vec.with(values, func() *histogram { return &histogram{counts: make([]uint64, len(h.buckets))} })
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+
+	s.count++
+	s.sum += v
+}
+
+// Since observes the seconds elapsed since start
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(_ context.Context, b *bytes.Buffer) error {
+	h.vec.mu.Lock()
+	defer h.vec.mu.Unlock()
+
+	header(b, h.name, h.help, "histogram")
+	for _, k := range h.vec.sorted() {
+		s, values := h.vec.series[k], h.vec.values[k]
+
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			sample(b, h.name+"_bucket", h.vec.labels, values, `le="`+number(le)+`"`, float64(cumulative))
+		}
+
+		sample(b, h.name+"_bucket", h.vec.labels, values, `le="+Inf"`, float64(s.count))
+		sample(b, h.name+"_sum", h.vec.labels, values, "", s.sum)
+		sample(b, h.name+"_count", h.vec.labels, values, "", float64(s.count))
+	}
+
+	return nil
+}
+
+// GaugeFunc reads a value that goes up and down, such as the size of the
+// pending queue, each time the metrics are scraped
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func(ctx context.Context) (float64, error)
+}
+
+// NewGaugeFunc registers a gauge read by fn
+func NewGaugeFunc(name string, help string, fn func(ctx context.Context) (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{name: namespace + name, help: help, fn: fn}
+	register(g.name, g)
+
+	return g
+}
+
+func (g *GaugeFunc) write(ctx context.Context, b *bytes.Buffer) error {
+	v, err := g.fn(ctx)
+	if err != nil {
+		return err
+	}
+
+	header(b, g.name, g.help, "gauge")
+	sample(b, g.name, nil, nil, "", v)
+
+	return nil
+}
+
+// Lookups of the in-memory caches, shared by the packages keeping them
+var cacheRequests = NewCounter("cache_requests_total", "Lookups of in-memory caches by result.", "cache", "result")
+
+// Cache counts a lookup of the named cache as a hit or a miss
+func Cache(name string, hit bool) {
+	if hit {
+		cacheRequests.Inc(name, "hit")
+	} else {
+		cacheRequests.Inc(name, "miss")
+	}
+}
+
+// Handler writes every registered metric. A gauge failing to read is left
+// out rather than failing the whole scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+
+		all := make([]family, 0, len(names))
+		slices.Sort(names)
+		for _, name := range names {
+			all = append(all, families[name])
+		}
+		mu.Unlock()
+
+		var b bytes.Buffer
+		for i, f := range all {
+			var part bytes.Buffer
+			if err := f.write(r.Context(), &part); err != nil {
+				log.Ctx(r.Context()).Error("Failed to read metric %s: %s", names[i], err.Error())
+				continue
+			}
+
+			b.Write(part.Bytes())
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b.Bytes())
+	})
+}
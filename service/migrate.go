@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"service/database/migrations"
+	"service/log"
+	"service/utils"
+)
+
+const migrateUsage = `usage: service migrate <command> [steps]
+
+commands:
+  up [n]     apply pending migrations, or only the next n
+  down [n]   revert the last applied migration, or the last n
+  status     list migrations and whether they are applied`
+
+// migrateCommand handles `service migrate ...` and returns the exit code
+func migrateCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "invalid step count %q\n", args[1])
+			return 2
+		}
+
+		steps = n
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := migrations.Up(utils.Db(), utils.DbDialect(), steps)
+		if err != nil {
+			log.Error("Migration failed after %d applied: %s", n, err.Error())
+			return 1
+		}
+
+		log.Done("Applied %d migration(s)", n)
+
+	case "down":
+		n, err := migrations.Down(utils.Db(), utils.DbDialect(), steps)
+		if err != nil {
+			log.Error("Rollback failed after %d reverted: %s", n, err.Error())
+			return 1
+		}
+
+		log.Done("Reverted %d migration(s)", n)
+
+	case "status":
+		states, err := migrations.Status(utils.Db(), utils.DbDialect())
+		if err != nil {
+			log.Error("Failed to get migration status: %s", err.Error())
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.UTC().Format("2006-01-02 15:04:05 UTC")
+			}
+
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		tw.Flush()
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// autoMigrate brings the schema up to date on startup
+func autoMigrate() {
+	n, err := migrations.Up(utils.Db(), utils.DbDialect(), 0)
+	if err != nil {
+		log.Error("Failed to migrate database schema: %s", err.Error())
+		return
+	}
+
+	if n > 0 {
+		log.Done("Applied %d database migration(s)", n)
+	} else {
+		log.Debug("Database schema is up to date")
+	}
+}
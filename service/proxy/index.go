@@ -4,19 +4,23 @@ import (
 	"fmt"
 	"net/http"
 
+	"service/config"
 	"service/log"
+	"service/router"
 )
 
-func init() {
-	http.HandleFunc("/proxy", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Boomlings Proxy service pinged")
+func Register(rt *router.Router, store *config.Store) {
+	rt.Doc(router.Operation{
+		Summary: "Check the level proxy",
+		Text:    "pong!",
+	}).Get("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Boomlings Proxy service pinged")
 		header := w.Header()
 		header.Set("Content-Type", "text/plain")
 
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "pong!")
 	})
+
+	registerLevel(rt, store)
 }
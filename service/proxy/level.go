@@ -6,7 +6,9 @@ import (
 	"net/url"
 	"strings"
 
+	"service/config"
 	"service/log"
+	"service/router"
 )
 
 type Level struct {
@@ -14,73 +16,72 @@ type Level struct {
 	Secret string `json:"secret"`
 }
 
-func init() {
-	http.HandleFunc("/proxy/level", func(w http.ResponseWriter, r *http.Request) {
+func registerLevel(rt *router.Router, store *config.Store) {
+	rt.Doc(router.Operation{
+		Summary: "Level data from the Geometry Dash servers",
+		Form: []router.Param{
+			{Name: "levelID", Type: "integer", Required: true, Description: "Geometry Dash level ID, also read from level-id"},
+		},
+		Text: "Raw level data, -1 when the level does not exist",
+	}).Post("/proxy/level", func(w http.ResponseWriter, r *http.Request) {
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Methods", "POST")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodPost {
-			err := r.ParseForm()
-			if err != nil {
-				log.Error("Failed to parse form: %s", err.Error())
-				http.Error(w, "Invalid request", http.StatusBadRequest)
-				return
-			}
-
-			// Try both parameter names for compatibility
-			levelID := r.FormValue("levelID")
-			if levelID == "" {
-				levelID = r.FormValue("level-id")
-			}
-
-			if levelID == "" {
-				http.Error(w, "levelID is required", http.StatusBadRequest)
-				return
-			}
-
-			log.Info("Proxying request for level ID: %s", levelID)
-			formData := url.Values{}
-			formData.Set("levelID", levelID)
-			formData.Set("secret", "Wmfd2893gb7")
-
-			req, err := http.NewRequest("POST", "https://www.boomlings.com/database/downloadGJLevel22.php", strings.NewReader(formData.Encode()))
-			if err != nil {
-				log.Error("Failed to create request: %s", err.Error())
-				http.Error(w, "Failed to create request", http.StatusInternalServerError)
-				return
-			}
-
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-			req.Header.Set("User-Agent", "")
-
-			// Make the request
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Error("Failed to proxy request: %s", err.Error())
-				http.Error(w, "Failed to fetch level data", http.StatusInternalServerError)
-				return
-			}
-
-			defer resp.Body.Close()
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Error("Failed to read response: %s", err.Error())
-				http.Error(w, "Failed to read level data", http.StatusInternalServerError)
-				return
-			}
-
-			header.Set("Content-Type", "text/plain")
-
-			w.WriteHeader(resp.StatusCode)
-			w.Write(body)
-
-			log.Debug("Successfully proxied level request")
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		err := r.ParseForm()
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to parse form: %s", err.Error())
+			router.Error(w, r, http.StatusBadRequest, router.CodeBadRequest, "Invalid request")
+			return
 		}
+
+		// Try both parameter names for compatibility
+		levelID := r.FormValue("levelID")
+		if levelID == "" {
+			levelID = r.FormValue("level-id")
+		}
+
+		if levelID == "" {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeMissingParameter, "levelID is required", router.Details{"parameter": "levelID"})
+			return
+		}
+
+		log.Ctx(r.Context()).Info("Proxying request for level ID: %s", levelID)
+		formData := url.Values{}
+		formData.Set("levelID", levelID)
+		formData.Set("secret", "Wmfd2893gb7")
+
+		req, err := http.NewRequestWithContext(r.Context(), "POST", store.Current().Endpoints.Boomlings+"/database/downloadGJLevel22.php", strings.NewReader(formData.Encode()))
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to create request: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to create request")
+			return
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "")
+
+		// Make the request
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to proxy request: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Failed to fetch level data")
+			return
+		}
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to read response: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeUpstreamFailed, "Failed to read level data")
+			return
+		}
+
+		header.Set("Content-Type", "text/plain")
+
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+
+		log.Ctx(r.Context()).Debug("Successfully proxied level request")
 	})
 }
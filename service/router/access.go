@@ -0,0 +1,127 @@
+package router
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"service/log"
+	"service/metrics"
+)
+
+// Longest X-Request-ID accepted from clients and proxies
+const maxRequestID = 64
+
+// Requests by route pattern rather than path, so unknown paths share a series
+var (
+	requests        = metrics.NewCounter("http_requests_total", "HTTP requests answered.", "method", "route", "status")
+	requestDuration = metrics.NewHistogram("http_request_duration_seconds", "Time taken to answer HTTP requests.", metrics.DefaultBuckets, "route")
+)
+
+// AccessLog gives each request an ID, taken from a well-formed X-Request-ID
+// header or generated, and logs one line per request once it is answered.
+// Every line logged through log.Ctx during the request carries the ID.
+// Requests are also counted and timed in the HTTP metrics.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = r.WithContext(log.WithRequest(r.Context(), id))
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			level := log.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = log.LevelWarn
+			}
+
+			elapsed := time.Since(start)
+
+			// the query string is left out as it may carry codes and tokens
+			log.Slog().LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", r.Pattern),
+				slog.Int("status", rec.status),
+				slog.Int64("bytes", rec.bytes),
+				slog.Duration("duration", elapsed.Round(time.Microsecond)),
+			)
+
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+
+			requests.Inc(knownMethod(r.Method), route, strconv.Itoa(rec.status))
+			requestDuration.Observe(elapsed.Seconds(), route)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// knownMethod keeps made-up methods from adding series to the metrics
+func knownMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+
+	return "OTHER"
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// recorder keeps the status and size of a response for the access log
+type recorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	written bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if !rec.written {
+		rec.status = status
+		rec.written = true
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.written = true
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
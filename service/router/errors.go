@@ -0,0 +1,85 @@
+package router
+
+import (
+	"net/http"
+
+	"service/log"
+)
+
+// Code identifies a class of errors clients can branch on. Published codes
+// never change meaning, new situations get new codes.
+type Code string
+
+const (
+	CodeBadRequest          Code = "BAD_REQUEST"          // Malformed body, form or JSON
+	CodeMissingParameter    Code = "MISSING_PARAMETER"    // details.parameter is absent
+	CodeInvalidParameter    Code = "INVALID_PARAMETER"    // details.parameter has an unusable value
+	CodeUnauthorized        Code = "UNAUTHORIZED"         // No valid session
+	CodeNotAdmin            Code = "NOT_ADMIN"            // Administrators only
+	CodeNotStaff            Code = "NOT_STAFF"            // Staff and administrators only
+	CodeNotOwner            Code = "NOT_OWNER"            // The ad belongs to someone else
+	CodeArgonInvalid        Code = "ARGON_INVALID"        // Argon account or token rejected
+	CodeBanned              Code = "BANNED"               // The account is banned
+	CodeOwnerBanned         Code = "OWNER_BANNED"         // The owner of the ad is banned
+	CodeReportBanned        Code = "REPORT_BANNED"        // The player may no longer report ads
+	CodeCrossOrigin         Code = "CROSS_ORIGIN"         // Sent from an untrusted site
+	CodeNotFound            Code = "NOT_FOUND"            // details.resource does not exist
+	CodeAdLimitReached      Code = "AD_LIMIT_REACHED"     // details.limit active ads already
+	CodeInsufficientBoosts  Code = "INSUFFICIENT_BOOSTS"  // Not enough boosts left to spend
+	CodeImageTooLarge       Code = "IMAGE_TOO_LARGE"      // details.max_bytes exceeded
+	CodeSubmissionsDisabled Code = "SUBMISSIONS_DISABLED" // New ads are not accepted right now
+	CodeRateLimited         Code = "RATE_LIMITED"         // Too many requests from the client
+	CodeInvalidConfig       Code = "INVALID_CONFIG"       // The configuration on disk failed to load, see details.error
+	CodeUpstreamFailed      Code = "UPSTREAM_FAILED"      // Discord, Argon, Geode or Boomlings failed
+	CodeDegraded            Code = "DEGRADED"             // The database is unavailable, only reads are served
+	CodeJobRunning          Code = "JOB_RUNNING"          // The background job is already running
+	CodeInvalidState        Code = "INVALID_STATE"        // The login was not started by this browser or expired, start over at /login
+	CodeInternal            Code = "INTERNAL"             // Anything else, details in the server log
+)
+
+// Every published code, listed in the OpenAPI specification
+var codes = []Code{
+	CodeBadRequest, CodeMissingParameter, CodeInvalidParameter, CodeUnauthorized,
+	CodeNotAdmin, CodeNotStaff, CodeNotOwner, CodeArgonInvalid, CodeBanned,
+	CodeOwnerBanned, CodeReportBanned, CodeCrossOrigin, CodeNotFound,
+	CodeAdLimitReached, CodeInsufficientBoosts, CodeImageTooLarge,
+	CodeSubmissionsDisabled, CodeRateLimited, CodeInvalidConfig,
+	CodeUpstreamFailed, CodeDegraded, CodeJobRunning, CodeInvalidState,
+	CodeInternal,
+}
+
+// Details carries machine-readable context of an error
+type Details map[string]any
+
+// Body of every error response
+type ErrorBody struct {
+	Code      Code    `json:"code"`
+	Message   string  `json:"message"` // Human-readable, may change at any time
+	Details   Details `json:"details,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// Error replies with the JSON error envelope, the structured counterpart of http.Error
+func Error(w http.ResponseWriter, r *http.Request, status int, code Code, message string) {
+	ErrorDetails(w, r, status, code, message, nil)
+}
+
+func ErrorDetails(w http.ResponseWriter, r *http.Request, status int, code Code, message string, details Details) {
+	header := w.Header()
+
+	// drop headers meant for the body that will not be sent
+	header.Del("Content-Length")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	WriteJSON(w, status, ErrorBody{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: RequestID(r),
+	})
+}
+
+// RequestID is the identifier AccessLog gave the request
+func RequestID(r *http.Request) string {
+	return log.RequestID(r.Context())
+}
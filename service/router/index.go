@@ -0,0 +1,162 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"service/log"
+)
+
+// Middleware wraps a handler with behaviour shared between routes
+type Middleware func(http.Handler) http.Handler
+
+// Router registers method-aware ServeMux patterns behind a middleware chain
+type Router struct {
+	mux     *http.ServeMux
+	base    []Middleware // Chain every route and preflight goes through
+	chain   []Middleware // Extra middleware of this router, see With
+	table   *table       // Registered routes, shared with derived routers
+	open    bool         // Routes are open to every origin, see Open
+	version string       // Prefix of versioned routes, see Version
+	op      *Operation   // Documentation of the next route, see Doc
+}
+
+// Routes registered through a router and all routers derived from it
+type table struct {
+	methods map[string][]string // Registered methods of every path
+	routes  []*route            // Versioned routes in registration order
+}
+
+// A versioned route as published in the OpenAPI specification
+type route struct {
+	method string
+	path   string // Full path including the version prefix
+	legacy string // Unversioned alias
+	op     *Operation
+}
+
+// New returns a router whose base middleware applies to every route,
+// including the OPTIONS preflight answered for each path
+func New(mux *http.ServeMux, base ...Middleware) *Router {
+	return &Router{
+		mux:   mux,
+		base:  base,
+		table: &table{methods: make(map[string][]string)},
+	}
+}
+
+// With derives a router adding middleware after the current chain
+func (rt *Router) With(mw ...Middleware) *Router {
+	return &Router{
+		mux:     rt.mux,
+		base:    rt.base,
+		chain:   append(slices.Clip(rt.chain), mw...),
+		table:   rt.table,
+		open:    rt.open,
+		version: rt.version,
+		op:      rt.op,
+	}
+}
+
+// Open derives a router whose routes, including their preflight, accept calls
+// from any origin instead of the allowlist
+func (rt *Router) Open() *Router {
+	derived := rt.With()
+	derived.open = true
+
+	return derived
+}
+
+// Version derives a router publishing its routes under prefix, such as "/v1".
+// Every route stays reachable at its unversioned path for older clients.
+func (rt *Router) Version(prefix string) *Router {
+	derived := rt.With()
+	derived.version = prefix
+
+	return derived
+}
+
+// Doc derives a router documenting the next route it registers
+func (rt *Router) Doc(op Operation) *Router {
+	derived := rt.With()
+	derived.op = &op
+
+	return derived
+}
+
+func (rt *Router) Handle(method string, path string, h http.Handler) {
+	if rt.version == "" {
+		rt.register(method, path, h)
+		return
+	}
+
+	rt.register(method, rt.version+path, h)
+	rt.register(method, path, h)
+
+	rt.table.routes = append(rt.table.routes, &route{
+		method: method,
+		path:   rt.version + path,
+		legacy: path,
+		op:     rt.op,
+	})
+}
+
+func (rt *Router) register(method string, path string, h http.Handler) {
+	base := rt.base
+	if rt.open {
+		base = append(slices.Clip(base), AnyOrigin)
+	}
+
+	if _, found := rt.table.methods[path]; !found {
+		rt.mux.Handle(http.MethodOptions+" "+path, wrap(rt.preflight(path), base))
+	}
+
+	rt.table.methods[path] = append(rt.table.methods[path], method)
+	rt.mux.Handle(method+" "+path, wrap(wrap(h, rt.chain), base))
+}
+
+func (rt *Router) HandleFunc(method string, path string, fn http.HandlerFunc) {
+	rt.Handle(method, path, fn)
+}
+
+func (rt *Router) Get(path string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodGet, path, fn)
+}
+
+func (rt *Router) Post(path string, fn http.HandlerFunc) {
+	rt.Handle(http.MethodPost, path, fn)
+}
+
+// preflight lists the methods of a path for CORS and OPTIONS requests
+func (rt *Router) preflight(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := strings.Join(append(slices.Clone(rt.table.methods[path]), http.MethodOptions), ", ")
+
+		header := w.Header()
+		header.Set("Allow", methods)
+		header.Set("Access-Control-Allow-Methods", methods)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// wrap applies a chain so its first middleware runs first
+func wrap(h http.Handler, chain []Middleware) http.Handler {
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+
+	return h
+}
+
+// WriteJSON encodes v as the response body with the given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Failed to encode response: %s", err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+package router
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"service/log"
+)
+
+// Recover turns a panicking handler into a 500 response instead of a dropped connection
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			// the server aborts the response on its own
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			log.Ctx(r.Context()).Error("Recovered from panic in %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			Error(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// JSON marks every response of the route as JSON
+func JSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReadOnly refuses requests that change data with 503 while degraded
+// reports true, leaving reads through
+func ReadOnly(degraded func() bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if degraded() {
+					w.Header().Set("Retry-After", "30")
+					Error(w, r, http.StatusServiceUnavailable, CodeDegraded, "The database is unavailable, changes are disabled until it recovers")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Who may call a route
+type Auth int
+
+const (
+	Public  Auth = iota // Anyone
+	Session             // Any logged-in account
+	Staff               // Staff and administrators
+	Admin               // Administrators only
+)
+
+// Param documents a query, path or form parameter
+type Param struct {
+	Name        string
+	Type        string // integer, number, boolean, string or file
+	Required    bool
+	Description string
+}
+
+// Operation documents a route in the OpenAPI specification
+type Operation struct {
+	Summary  string
+	Auth     Auth
+	Path     []Param // The {wildcards} of the path, undocumented ones are plain strings
+	Query    []Param // Query string parameters
+	Body     any     // JSON request body, given as a value of its type
+	Form     []Param // Fields of a multipart or urlencoded request body
+	Response any     // JSON response body, given as a value of its type
+	Text     string  // Describes a plain text response body, used instead of Response
+	Status   int     // Success status, 200 when zero
+}
+
+// Info heads the OpenAPI specification
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+var wildcard = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// OpenAPI serves the OpenAPI 3 specification of every versioned route. It is
+// generated on the first request, once all routes are registered.
+func (rt *Router) OpenAPI(info Info) http.HandlerFunc {
+	var once sync.Once
+	var spec map[string]any
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { spec = rt.Spec(info) })
+		WriteJSON(w, http.StatusOK, spec)
+	}
+}
+
+// Spec builds the OpenAPI 3 document of every versioned route
+func (rt *Router) Spec(info Info) map[string]any {
+	s := newSchemas()
+	errorRef := s.of(reflect.TypeOf(ErrorBody{}))
+
+	paths := make(map[string]any)
+	for _, rte := range rt.table.routes {
+		item, found := paths[rte.path].(map[string]any)
+		if !found {
+			item = make(map[string]any)
+			paths[rte.path] = item
+		}
+
+		item[strings.ToLower(rte.method)] = s.operation(rte, errorRef)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "session_id",
+				},
+			},
+		},
+	}
+}
+
+func (s *schemas) operation(rte *route, errorRef map[string]any) map[string]any {
+	op := rte.op
+	if op == nil {
+		op = &Operation{}
+	}
+
+	out := map[string]any{
+		"operationId":   operationID(rte.method, rte.path),
+		"tags":          []string{strings.Split(strings.TrimPrefix(rte.legacy, "/"), "/")[0]},
+		"x-legacy-path": rte.legacy,
+	}
+
+	if op.Summary != "" {
+		out["summary"] = op.Summary
+	}
+
+	switch op.Auth {
+	case Session:
+		out["security"] = []any{map[string]any{"session": []string{}}}
+	case Staff:
+		out["security"] = []any{map[string]any{"session": []string{}}}
+		out["description"] = "Requires a staff or administrator account."
+	case Admin:
+		out["security"] = []any{map[string]any{"session": []string{}}}
+		out["description"] = "Requires an administrator account."
+	}
+
+	var params []any
+	for _, m := range wildcard.FindAllStringSubmatch(rte.path, -1) {
+		p := Param{Name: m[1], Type: "string"}
+		for _, doc := range op.Path {
+			if doc.Name == p.Name {
+				p = doc
+			}
+		}
+
+		p.Required = true
+		params = append(params, parameter(p, "path"))
+	}
+
+	for _, p := range op.Query {
+		params = append(params, parameter(p, "query"))
+	}
+
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if op.Body != nil {
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": s.of(reflect.TypeOf(op.Body))},
+			},
+		}
+	} else if len(op.Form) > 0 {
+		mediaType := "application/x-www-form-urlencoded"
+		properties := make(map[string]any)
+		var required []string
+		for _, p := range op.Form {
+			if p.Type == "file" {
+				mediaType = "multipart/form-data"
+			}
+
+			properties[p.Name] = paramSchema(p)
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{mediaType: map[string]any{"schema": schema}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case op.Response != nil:
+		success["content"] = map[string]any{
+			"application/json": map[string]any{"schema": s.of(reflect.TypeOf(op.Response))},
+		}
+	case op.Text != "":
+		success["description"] = op.Text
+		success["content"] = map[string]any{
+			"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+		}
+	}
+
+	out["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": errorRef},
+			},
+		},
+	}
+
+	return out
+}
+
+func parameter(p Param, in string) map[string]any {
+	out := map[string]any{
+		"name":     p.Name,
+		"in":       in,
+		"required": p.Required,
+		"schema":   paramSchema(p),
+	}
+
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+
+	return out
+}
+
+func paramSchema(p Param) map[string]any {
+	schema := map[string]any{"type": p.Type}
+	if p.Type == "file" {
+		schema = map[string]any{"type": "string", "format": "binary"}
+	}
+
+	if p.Description != "" {
+		schema["description"] = p.Description
+	}
+
+	return schema
+}
+
+// operationID turns "GET /v1/ads/pending/accept" into getAdsPendingAccept
+func operationID(method string, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+
+	for i, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, "{}.")
+		if i < 2 || part == "" {
+			continue
+		}
+
+		for _, word := range strings.FieldsFunc(part, func(r rune) bool { return r == '-' || r == '_' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+
+	return b.String()
+}
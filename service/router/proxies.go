@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"service/log"
+)
+
+type clientKey struct{}
+
+// Proxies is the list of reverse proxies whose forwarding headers are
+// believed. Headers from anyone else are ignored, as clients can send them.
+type Proxies struct {
+	trusted atomic.Pointer[[]netip.Prefix]
+}
+
+func NewProxies() *Proxies {
+	p := &Proxies{}
+	p.trusted.Store(&[]netip.Prefix{})
+
+	return p
+}
+
+// Set replaces the list, keeping the previous one if an entry is malformed.
+// Entries are CIDRs or single addresses.
+func (p *Proxies) Set(trusted []string) error {
+	prefixes := make([]netip.Prefix, 0, len(trusted))
+	for _, t := range trusted {
+		prefix, err := netip.ParsePrefix(t)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(t)
+			if addrErr != nil {
+				return err
+			}
+
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	p.trusted.Store(&prefixes)
+	return nil
+}
+
+func (p *Proxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range *p.trusted.Load() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve finds the client address of each request, see ClientIP
+func (p *Proxies) Resolve(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := p.client(r)
+		log.Annotate(r.Context(), "client", ip)
+
+		inner := r.WithContext(context.WithValue(r.Context(), clientKey{}, ip))
+		next.ServeHTTP(w, inner)
+
+		// AccessLog reads the route matched by the mux from its own request
+		r.Pattern = inner.Pattern
+	})
+}
+
+// client walks X-Forwarded-For from the right, past the trusted proxies,
+// as only the entries they appended can be believed
+func (p *Proxies) client(r *http.Request) string {
+	remote := remoteAddr(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !p.trusts(addr) {
+		return remote
+	}
+
+	if cf := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); cf != "" {
+		if addr, err := netip.ParseAddr(cf); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	// a chain of trusted proxies only leaves the first of them
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		client = hop.Unmap().String()
+		if !p.trusts(hop) {
+			break
+		}
+	}
+
+	return client
+}
+
+// ClientIP is the address of the client sending the request, its peer
+// unless that is a trusted proxy
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientKey{}).(string); ok {
+		return ip
+	}
+
+	return remoteAddr(r)
+}
+
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+
+	return host
+}
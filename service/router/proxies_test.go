@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxiesClient(t *testing.T) {
+	p := NewProxies()
+	if err := p.Set([]string{"10.0.0.0/8", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		cf     string
+		want   string
+	}{
+		{name: "direct client", remote: "203.0.113.9:1234", want: "203.0.113.9"},
+		{name: "untrusted peer forging headers", remote: "203.0.113.9:1234", xff: []string{"198.51.100.1"}, cf: "198.51.100.2", want: "203.0.113.9"},
+		{name: "trusted peer without headers", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "trusted peer", remote: "10.0.0.1:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged first hop", remote: "10.0.0.1:1234", xff: []string{"1.1.1.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1:1234", xff: []string{"198.51.100.1, 10.0.0.2"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.0.0.1:1234", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "repeated headers", remote: "10.0.0.1:1234", xff: []string{"1.1.1.1", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "malformed last hop", remote: "10.0.0.1:1234", xff: []string{"198.51.100.1, junk"}, want: "10.0.0.1"},
+		{name: "malformed hop past the client", remote: "10.0.0.1:1234", xff: []string{"junk, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "mapped hop", remote: "10.0.0.1:1234", xff: []string{"::ffff:198.51.100.1"}, want: "198.51.100.1"},
+		{name: "cloudflare", remote: "10.0.0.1:1234", xff: []string{"198.51.100.1"}, cf: "198.51.100.7", want: "198.51.100.7"},
+		{name: "malformed cloudflare", remote: "10.0.0.1:1234", xff: []string{"198.51.100.1"}, cf: "junk", want: "198.51.100.1"},
+		{name: "mapped trusted peer", remote: "[::ffff:10.0.0.1]:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "ipv6 trusted peer", remote: "[::1]:1234", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "peer without port", remote: "10.0.0.1", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if tt.cf != "" {
+				r.Header.Set("CF-Connecting-IP", tt.cf)
+			}
+
+			if got := p.client(r); got != tt.want {
+				t.Errorf("client() = %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxiesSet(t *testing.T) {
+	p := NewProxies()
+	if err := p.Set([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Set([]string{"192.168.0.0/16", "not a proxy"}); err == nil {
+		t.Fatal("Set accepted a malformed entry")
+	}
+
+	// the previous list stays in place
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	if got := p.client(r); got != "198.51.100.1" {
+		t.Errorf("client() = %q after a rejected Set, expected %q", got, "198.51.100.1")
+	}
+}
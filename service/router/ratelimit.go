@@ -0,0 +1,75 @@
+package router
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"service/log"
+	"service/metrics"
+
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/time/rate"
+)
+
+var rateLimited = metrics.NewCounter("rate_limited_total", "Requests refused by a rate limit policy.", "policy")
+
+// Limit is a token bucket holding Burst requests, refilled at Rate per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter keeps a bucket per policy and client, forgotten once idle
+type Limiter struct {
+	buckets *cache.Cache
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: cache.New(15*time.Minute, 30*time.Minute)}
+}
+
+// Allow takes a request from the bucket of key under the policy, describing
+// the bucket in the RateLimit headers. An empty bucket answers 429 with
+// Retry-After and false.
+func (l *Limiter) Allow(w http.ResponseWriter, r *http.Request, policy string, key string, limit Limit) bool {
+	id := policy + ":" + key
+
+	bucket := rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+	if err := l.buckets.Add(id, bucket, cache.DefaultExpiration); err != nil {
+		if val, found := l.buckets.Get(id); found {
+			bucket = val.(*rate.Limiter)
+		}
+	}
+
+	// reloads apply to buckets already handed out
+	if bucket.Limit() != rate.Limit(limit.Rate) || bucket.Burst() != limit.Burst {
+		bucket.SetLimit(rate.Limit(limit.Rate))
+		bucket.SetBurst(limit.Burst)
+	}
+
+	l.buckets.Set(id, bucket, cache.DefaultExpiration)
+
+	allowed := bucket.Allow()
+	tokens := max(bucket.Tokens(), 0)
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	header.Set("RateLimit-Reset", seconds((float64(limit.Burst)-tokens)/limit.Rate))
+
+	if !allowed {
+		header.Set("Retry-After", seconds((1-tokens)/limit.Rate))
+		rateLimited.Inc(policy)
+		log.Ctx(r.Context()).Warn("Rate limited %s under the %s policy", key, policy)
+		Error(w, r, http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+	}
+
+	return allowed
+}
+
+// seconds rounds up, as headers carry whole seconds
+func seconds(s float64) string {
+	return strconv.Itoa(int(math.Ceil(max(s, 0))))
+}
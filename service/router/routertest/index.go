@@ -0,0 +1,132 @@
+// Package routertest calls registered routes over HTTP and checks their
+// responses, for the handler packages' tests
+package routertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"service/router"
+)
+
+// Server serves a handler for the length of a test
+type Server struct {
+	*httptest.Server
+	client *http.Client
+}
+
+// NewServer serves h until the test ends. Redirects are returned, not followed.
+func NewServer(t *testing.T, h http.Handler) *Server {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	return &Server{Server: ts, client: client}
+}
+
+// Do sends a request without a body, with the session cookie and headers when set
+func (s *Server) Do(t *testing.T, method string, path string, cookie *http.Cookie, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	return s.send(t, req)
+}
+
+// Get fetches path, with the session cookie when set
+func (s *Server) Get(t *testing.T, path string, cookie *http.Cookie) (*http.Response, []byte) {
+	t.Helper()
+
+	return s.Do(t, http.MethodGet, path, cookie, nil)
+}
+
+// Post sends body as JSON, or as is when it already is raw bytes
+func (s *Server) Post(t *testing.T, path string, body any) (*http.Response, []byte) {
+	t.Helper()
+
+	b, ok := body.([]byte)
+	if !ok {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL+path, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.send(t, req)
+}
+
+func (s *Server) send(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, body
+}
+
+// ExpectError fails the test unless the response is the given error
+func ExpectError(t *testing.T, resp *http.Response, body []byte, status int, code router.Code) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("got %d %s, expected %d", resp.StatusCode, body, status)
+	}
+
+	var e router.ErrorBody
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+
+	if e.Code != code {
+		t.Errorf("got code %q, expected %q", e.Code, code)
+	}
+}
+
+// Decode fails the test unless the response is a 200 carrying a T
+func Decode[T any](t *testing.T, resp *http.Response, body []byte) T {
+	t.Helper()
+
+	var v T
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %s, expected 200", resp.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+
+	return v
+}
@@ -0,0 +1,176 @@
+package router
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPI schemas derived from Go types the way encoding/json writes them.
+// Named structs become shared components referenced by name.
+type schemas struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	codeType      = reflect.TypeOf(Code(""))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: make(map[string]any),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+func (s *schemas) of(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == codeType:
+		return map[string]any{"type": "string", "enum": codes}
+	case t.Implements(marshalerType):
+		return marshaled(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer"}
+
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "minimum": 0}
+
+	case reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+
+	case reflect.String:
+		return map[string]any{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+
+		// nil slices are written as null
+		return map[string]any{"type": "array", "items": s.of(t.Elem()), "nullable": true}
+
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+
+		return s.ref(t)
+	}
+
+	return map[string]any{}
+}
+
+// ref registers a named struct as a component and points to it
+func (s *schemas) ref(t reflect.Type) map[string]any {
+	name, found := s.names[t]
+	if !found {
+		name = t.Name()
+		for _, taken := range s.names {
+			if taken == name {
+				name = strings.ReplaceAll(t.String(), ".", "_")
+			}
+		}
+
+		s.names[t] = name
+		s.components[name] = s.object(t)
+	}
+
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (s *schemas) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	s.fields(t, properties, &required)
+
+	out := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if len(required) > 0 {
+		out["required"] = required
+	}
+
+	return out
+}
+
+// fields collects the JSON properties of a struct, flattening embedded structs
+func (s *schemas) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.fields(f.Type, properties, required)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = s.of(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// marshaled infers the JSON type written by a custom marshaler from its zero value
+func marshaled(t reflect.Type) map[string]any {
+	b, err := reflect.Zero(t).Interface().(json.Marshaler).MarshalJSON()
+	if err != nil || len(b) == 0 {
+		return map[string]any{}
+	}
+
+	switch b[0] {
+	case '"':
+		return map[string]any{"type": "string"}
+	case 't', 'f':
+		return map[string]any{"type": "boolean"}
+	case '[':
+		return map[string]any{"type": "array"}
+	case '{':
+		return map[string]any{"type": "object"}
+	case 'n':
+		return map[string]any{}
+	default:
+		return map[string]any{"type": "number"}
+	}
+}
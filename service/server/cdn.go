@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"service/access"
+	"service/log"
+	"service/router"
+	"service/utils"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Image URLs carry ?v=<unix> and change whenever the image does
+const immutable = "public, max-age=31536000, immutable"
+
+// What the CDN learned about a file, valid while its size and modification
+// time stay the same
+type cdnEntry struct {
+	modified    time.Time
+	size        int64
+	etag        string
+	contentType string
+}
+
+// cdn serves the ad images below dir and their variants, keeping up to
+// variantCache bytes of those. Paths are resolved inside dir so that neither
+// .. nor symlinks reach other files, and pending images are only served to
+// whom auth lets see them.
+func cdn(dir string, variantCache int64, auth *access.Handler) http.HandlerFunc {
+	entries := cache.New(time.Hour, 2*time.Hour)
+	made := newVariants(variantCache)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/cdn/")
+		if !fs.ValidPath(name) || hidden(name) {
+			log.Ctx(r.Context()).Warn("Rejected CDN path %q", r.URL.Path)
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such image", router.Details{"resource": "image"})
+			return
+		}
+
+		// answered like missing images, not to tell which are under review
+		if !auth.CanViewImage(r, name) {
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such image", router.Details{"resource": "image"})
+			return
+		}
+
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to open the ad storage: %s", err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to open the ad storage")
+			return
+		}
+		defer root.Close()
+
+		f, err := root.Open(name)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Ctx(r.Context()).Warn("Failed to open CDN file %s: %s", name, err.Error())
+			}
+
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such image", router.Details{"resource": "image"})
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such image", router.Details{"resource": "image"})
+			return
+		}
+
+		entry, err := describe(entries, name, f, info)
+		if err != nil {
+			log.Ctx(r.Context()).Error("Failed to read CDN file %s: %s", name, err.Error())
+			router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to read the image")
+			return
+		}
+
+		// uploads are only ever served as images, whatever their extension
+		if !strings.HasPrefix(entry.contentType, "image/") {
+			log.Ctx(r.Context()).Warn("Refused to serve %s, detected as %s", name, entry.contentType)
+			router.ErrorDetails(w, r, http.StatusNotFound, router.CodeNotFound, "No such image", router.Details{"resource": "image"})
+			return
+		}
+
+		spec, asked, bad := parseVariant(name, r.URL.Query())
+		if bad != nil {
+			router.ErrorDetails(w, r, http.StatusBadRequest, router.CodeInvalidParameter, bad.message, router.Details{"parameter": bad.parameter, "allowed": bad.allowed})
+			return
+		}
+
+		if asked {
+			val, err := made.get(name, info.ModTime(), f, spec)
+			if err != nil {
+				log.Ctx(r.Context()).Error("Failed to make a variant of %s: %s", name, err.Error())
+				router.Error(w, r, http.StatusInternalServerError, router.CodeInternal, "Failed to resize the image")
+				return
+			}
+
+			serveVariant(w, r, name, val, info.ModTime())
+			return
+		}
+
+		header := w.Header()
+		header.Set("Content-Type", entry.contentType)
+		header.Set("ETag", entry.etag)
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Cache-Control", cacheControl(r, name))
+
+		// conditional and range requests are answered from the headers above
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	}
+}
+
+func cacheControl(r *http.Request, name string) string {
+	// shared caches must not hand pending images to others
+	if strings.HasPrefix(name, utils.PendingFolder+"/") {
+		return "private, no-cache"
+	}
+
+	if r.URL.Query().Get("v") != "" {
+		return immutable
+	}
+
+	// unversioned URLs may change in place, revalidating costs a 304
+	return "no-cache"
+}
+
+// describe hashes and sniffs a file once per version of it
+func describe(entries *cache.Cache, name string, f io.ReadSeeker, info fs.FileInfo) (*cdnEntry, error) {
+	if val, found := entries.Get(name); found {
+		entry := val.(*cdnEntry)
+		if entry.modified.Equal(info.ModTime()) && entry.size == info.Size() {
+			return entry, nil
+		}
+	}
+
+	h := sha256.New()
+	head := make([]byte, 512)
+
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	h.Write(head[:n])
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	entry := &cdnEntry{
+		modified:    info.ModTime(),
+		size:        info.Size(),
+		etag:        `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`,
+		contentType: http.DetectContentType(head[:n]),
+	}
+
+	entries.Set(name, entry, cache.DefaultExpiration)
+	return entry, nil
+}
+
+// hidden names are temporary files of the storage, such as readiness probes
+func hidden(name string) bool {
+	for part := range strings.SplitSeq(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+
+	return false
+}
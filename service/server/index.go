@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"service/access"
+	"service/ads"
+	"service/api"
+	"service/config"
+	"service/database"
+	"service/discord"
+	"service/health"
+	"service/jobs"
+	"service/log"
+	"service/metrics"
+	"service/proxy"
+	"service/router"
+	"service/stats"
+	"service/utils"
+)
+
+// Every route of the site wired onto a single mux
+type Server struct {
+	Auth    *access.Handler
+	Jobs    *jobs.Scheduler // Started by the caller once the server listens
+	Handler http.Handler    // Every route behind the client IP resolution and rate limits
+}
+
+// New registers the SPA, CDN and API handlers backed by the given repositories
+func New(store *config.Store, repos *database.Repositories) *Server {
+	cfg := store.Current()
+	webhooks := discord.New(store, repos.Users)
+
+	origins := router.NewOrigins()
+	store.Subscribe(func(cfg *config.Config) {
+		if err := origins.Set(cfg.CORS.AllowedOrigins); err != nil {
+			log.Error("Failed to apply CORS origins: %s", err.Error())
+		}
+	})
+
+	proxies := router.NewProxies()
+	store.Subscribe(func(cfg *config.Config) {
+		if err := proxies.Set(cfg.Proxies.Trusted); err != nil {
+			log.Error("Failed to apply trusted proxies: %s", err.Error())
+		}
+	})
+
+	auth := access.New(store, repos, origins)
+
+	mux := http.NewServeMux()
+	rt := router.New(mux, router.Recover, origins.CORS)
+
+	// SPA fallback
+	log.Debug("Setting up SPA fallback for client-side routing")
+	staticDir := "../dist"
+	fs := http.FileServer(http.Dir(staticDir))
+
+	rt.With(securityHeaders(store)).Get("/", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Received request for host %s", access.FullURL(r))
+
+		requestedPath := strings.TrimPrefix(filepath.Clean(r.URL.Path), "/")
+		fullPath := filepath.Join(staticDir, requestedPath)
+		if requestedPath == "" || requestedPath == "." {
+			http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
+			return
+		}
+
+		info, err := os.Stat(fullPath)
+		if err == nil && !info.IsDir() {
+			fs.ServeHTTP(w, r)
+			return
+		}
+
+		log.Ctx(r.Context()).Debug("Serving index.html for SPA route: %s", r.URL.Path)
+		http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
+	})
+
+	log.Debug("Starting image handler...")
+	rt.Open().Get("/cdn/", cdn(utils.StorageDir, cfg.CDN.VariantCache, auth))
+
+	log.Debug("Starting handlers...")
+	rt.Get("/api", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Info("Server pinged!")
+		w.Header().Set("Content-Type", "text/plain")
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "pong!")
+	})
+
+	// Every handler below is published under /v1 and aliased at its old path
+	v1 := rt.Version("/v1")
+	auth.Register(v1)
+	ads.New(store, repos, auth, webhooks).Register(v1.With(router.ReadOnly(utils.Degraded)))
+	api.New(store, repos, auth).Register(v1.Open()) // called by the mod and Ko-fi
+	stats.New(store, repos, auth).Register(v1)
+	proxy.Register(v1, store)
+
+	scheduler := jobs.New()
+	scheduler.Add("ad_expiry", jobs.MustParse(cfg.Jobs.AdExpiry), func(ctx context.Context) error {
+		if err := repos.Ads.DeleteExpired(ctx); err != nil {
+			return fmt.Errorf("failed to delete expired ad records: %w", err)
+		}
+
+		log.Ctx(ctx).Info("Expired ad records cleanup complete")
+		return nil
+	})
+	scheduler.Add("session_cleanup", jobs.MustParse(cfg.Jobs.SessionCleanup), auth.CleanupExpiredSessions)
+	scheduler.Register(v1.With(router.JSON, auth.RequireAdmin))
+
+	rt.Open().Get("/v1/openapi.json", rt.OpenAPI(router.Info{
+		Title:       "GD Ads API",
+		Version:     "1",
+		Description: "Every operation is also served without the /v1 prefix, the path given in x-legacy-path.",
+	}))
+
+	metrics.NewGaugeFunc("ads_pending", "Ads waiting for review.", func(ctx context.Context) (float64, error) {
+		pending, err := repos.Ads.ListPending(ctx)
+		return float64(len(pending)), err
+	})
+
+	metrics.NewGaugeFunc("reports_open", "Player reports waiting for staff.", func(ctx context.Context) (float64, error) {
+		reports, err := repos.Reports.List(ctx)
+		return float64(len(reports)), err
+	})
+
+	rt.Get("/metrics", scrape(store))
+
+	health.Register("database", func(ctx context.Context) error {
+		if err := utils.Ping(ctx); err != nil {
+			return fmt.Errorf("%w, serving reads from memory: %w", health.ErrDegraded, err)
+		}
+
+		return nil
+	})
+	health.Register("storage", writable(utils.StorageDir))
+	health.Register("discord", webhooks.Ready)
+
+	rt.Get("/healthz", health.Live)
+	rt.Get("/readyz", health.Readiness)
+
+	// Keep unknown API paths away from the SPA fallback
+	rt.Get("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		router.Error(w, r, http.StatusNotFound, router.CodeNotFound, "No such endpoint")
+	})
+
+	handler := proxies.Resolve(rateLimit(store, policies(auth))(mux))
+
+	return &Server{Auth: auth, Jobs: scheduler, Handler: handler}
+}
+
+// scrape serves the metrics once enabled, to scrapers sending the token
+func scrape(store *config.Store) http.HandlerFunc {
+	handler := metrics.Handler()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := store.Current().Metrics
+		if !m.Enabled {
+			router.Error(w, r, http.StatusNotFound, router.CodeNotFound, "Metrics are disabled")
+			return
+		}
+
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if m.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.Token)) != 1 {
+			log.Ctx(r.Context()).Warn("Rejected metrics scrape without a valid token")
+			router.Error(w, r, http.StatusUnauthorized, router.CodeUnauthorized, "Invalid metrics token")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// securityHeaders hardens the dashboard pages against framing, sniffing and
+// injected content
+func securityHeaders(store *config.Store) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := store.Current()
+			h := cfg.Headers
+			header := w.Header()
+
+			// browsers ignore it over plain HTTP, where it would only pin a
+			// deployment that has no certificate yet
+			if h.HSTS.Duration > 0 && (r.TLS != nil || cfg.TLS.Cert != "") {
+				header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(h.HSTS.Seconds())))
+			}
+
+			if h.CSP != "" {
+				header.Set("Content-Security-Policy", h.CSP)
+			}
+
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writable checks that files can be created in dir
+func writable(dir string) health.Check {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
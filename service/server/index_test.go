@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"service/config"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		cert string
+		tls  bool
+		hsts bool
+	}{
+		{name: "plain HTTP"},
+		{name: "native TLS", cert: "cert.pem", tls: true, hsts: true},
+		{name: "TLS configured", cert: "cert.pem", hsts: true},
+		{name: "TLS connection", tls: true, hsts: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.TLS.Cert = tt.cert
+
+			r := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			w := httptest.NewRecorder()
+			securityHeaders(config.NewStore("", cfg))(http.NotFoundHandler()).ServeHTTP(w, r)
+
+			header := w.Header()
+			if got := header.Get("Strict-Transport-Security") != ""; got != tt.hsts {
+				t.Errorf("sent HSTS %v, expected %v", got, tt.hsts)
+			}
+
+			if header.Get("Content-Security-Policy") != cfg.Headers.CSP || header.Get("X-Frame-Options") != "DENY" {
+				t.Errorf("got headers %v", header)
+			}
+		})
+	}
+}
+
+// Inline scripts of the page run only when the default policy lists their hash
+func TestInlineScriptsAllowed(t *testing.T) {
+	b, err := os.ReadFile("../../index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csp := config.Default().Headers.CSP
+	inline := regexp.MustCompile(`(?s)<script>(.*?)</script>`).FindAllSubmatch(b, -1)
+	if len(inline) == 0 {
+		t.Fatal("index.html has no inline script")
+	}
+
+	for _, m := range inline {
+		sum := sha256.Sum256(m[1])
+		hash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+
+		if !strings.Contains(csp, hash) {
+			t.Errorf("default CSP does not allow the inline script hashed %s", hash)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"service/access"
+	"service/config"
+	"service/router"
+)
+
+// Largest mod event body read to find its Argon account
+const maxEventBody = 4 << 10
+
+// A rate limit policy and the routes under it
+type policy struct {
+	name  string
+	paths []string // Without the /v1 prefix, those ending in a slash cover their subtree
+	limit func(config.RateLimits) config.RateLimit
+
+	// Who the request counts against, on top of the default policy of its
+	// client IP. Policies without one count per IP instead of the default.
+	key func(r *http.Request) string
+}
+
+func policies(auth *access.Handler) []policy {
+	return []policy{
+		{
+			name:  "events",
+			paths: []string{"/api/view", "/api/click"},
+			limit: func(l config.RateLimits) config.RateLimit { return l.Events },
+			key: func(r *http.Request) string {
+				// the account is not validated yet, so a client naming the
+				// accounts of others only spends its own budget
+				if account := argonAccount(r); account != "" {
+					return "ip:" + router.ClientIP(r) + "/" + account
+				}
+
+				return ""
+			},
+		},
+		{
+			name:  "submit",
+			paths: []string{"/ads/submit"},
+			limit: func(l config.RateLimits) config.RateLimit { return l.Submit },
+			key: func(r *http.Request) string {
+				if id, err := auth.GetSessionUserID(r); err == nil && id != "" {
+					return "user:" + id
+				}
+
+				return ""
+			},
+		},
+		{
+			name:  "cdn",
+			paths: []string{"/cdn/"},
+			limit: func(l config.RateLimits) config.RateLimit { return l.CDN },
+		},
+	}
+}
+
+// rateLimit applies the policy of each route, see config.RateLimits
+func rateLimit(store *config.Store, policies []policy) router.Middleware {
+	limiter := router.NewLimiter()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limits := store.Current().RateLimit
+			ip := "ip:" + router.ClientIP(r)
+
+			p := match(policies, r.URL.Path)
+			if p == nil || p.key != nil {
+				if !limiter.Allow(w, r, "default", ip, limitOf(limits.Default)) {
+					return
+				}
+			}
+
+			if p != nil {
+				key := ip
+				if p.key != nil {
+					key = cmp.Or(p.key(r), ip)
+				}
+
+				if !limiter.Allow(w, r, p.name, key, limitOf(p.limit(limits))) {
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func match(policies []policy, path string) *policy {
+	path = strings.TrimPrefix(path, "/v1")
+	for i, p := range policies {
+		for _, route := range p.paths {
+			if path == route || (strings.HasSuffix(route, "/") && strings.HasPrefix(path, route)) {
+				return &policies[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func limitOf(l config.RateLimit) router.Limit {
+	return router.Limit{Rate: l.Rate, Burst: l.Burst}
+}
+
+// argonAccount reads the account of a mod event, leaving the body for the
+// handler
+func argonAccount(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxEventBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var body struct {
+		AccountID int `json:"account_id"`
+	}
+
+	if err := json.Unmarshal(b, &body); err != nil || body.AccountID == 0 {
+		return ""
+	}
+
+	return "argon:" + strconv.Itoa(body.AccountID)
+}
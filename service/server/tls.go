@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"service/log"
+)
+
+// Certificate serves a key pair from disk, loading it again when either
+// file changes so renewals need no restart
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	cert     atomic.Pointer[tls.Certificate] // Read on every handshake
+	modified [2]time.Time                    // Of the files the current pair was read from
+	failed   [2]time.Time                    // Of the files that last failed to load
+}
+
+// LoadCertificate reads the pair, failing when it is unusable
+func LoadCertificate(certFile string, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+
+	modified, err := modTimes(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.load(modified); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// TLSConfig serves the certificate over TLS 1.2 and above
+func (c *Certificate) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.cert.Load(), nil
+		},
+	}
+}
+
+// Watch checks the files every so often until ctx is done
+func (c *Certificate) Watch(ctx context.Context, every time.Duration) {
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.check()
+			}
+		}
+	}()
+}
+
+// check loads the pair again when either file changed. A failed reload keeps
+// the previous pair until either file changes again, as a renewal caught
+// halfway soon does.
+func (c *Certificate) check() {
+	modified, err := modTimes(c.certFile, c.keyFile)
+	if err != nil || modified == c.modified || modified == c.failed {
+		return
+	}
+
+	if err := c.load(modified); err != nil {
+		c.failed = modified
+		log.Error("Failed to reload the TLS certificate, serving the previous one: %s", err.Error())
+		return
+	}
+
+	log.Print("Reloaded the TLS certificate from %s", c.certFile)
+}
+
+func (c *Certificate) load(modified [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	c.cert.Store(&cert)
+	c.modified = modified
+	return nil
+}
+
+func modTimes(certFile string, keyFile string) ([2]time.Time, error) {
+	var out [2]time.Time
+	for i, name := range []string{certFile, keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return out, err
+		}
+
+		out[i] = info.ModTime()
+	}
+
+	return out, nil
+}
+
+// Redirect sends plain HTTP requests to the same URL over HTTPS on port
+func Redirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 keeps the method and body of anything but a plain page load
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
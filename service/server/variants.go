@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"service/metrics"
+	"service/utils"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // uploads are WebP
+)
+
+// Largest side of a source image the CDN decodes to make variants
+const maxVariantSource = 4096
+
+// Formats variants are encoded to, by their ?fmt= name
+var variantFormats = map[string]string{"png": "image/png", "jpeg": "image/jpeg"}
+
+// A resized or re-encoded image, as asked for with ?w=, ?h= and ?fmt=
+type variantSpec struct {
+	size   utils.Size // Zero keeps the size of the source
+	format string
+}
+
+type variant struct {
+	data        []byte
+	etag        string
+	contentType string
+}
+
+// A query parameter the CDN can not make a variant of
+type variantError struct {
+	parameter string
+	message   string
+	allowed   []string
+}
+
+// parseVariant reads the variant asked for of the image at name, false when
+// the query asks for none. Sizes are limited to those of the ad type the image
+// is stored under, a width or height alone picking the size it belongs to.
+func parseVariant(name string, query url.Values) (variantSpec, bool, *variantError) {
+	w, h, format := query.Get("w"), query.Get("h"), query.Get("fmt")
+	if w == "" && h == "" && format == "" {
+		return variantSpec{}, false, nil
+	}
+
+	var spec variantSpec
+	if format != "" {
+		if _, found := variantFormats[format]; !found {
+			return spec, true, &variantError{parameter: "fmt", message: "Unsupported image format", allowed: []string{"png", "jpeg"}}
+		}
+
+		spec.format = format
+	}
+
+	if w == "" && h == "" {
+		return spec, true, nil
+	}
+
+	adType, _, _ := strings.Cut(strings.TrimPrefix(name, utils.PendingFolder+"/"), "/")
+	sizes := utils.AdType(adType).Sizes()
+
+	allowed := make([]string, 0, len(sizes))
+	for _, s := range sizes {
+		allowed = append(allowed, s.String())
+	}
+
+	width, errW := dimension(w)
+	height, errH := dimension(h)
+	if errW != nil || errH != nil {
+		parameter := "w"
+		if errW == nil {
+			parameter = "h"
+		}
+
+		return spec, true, &variantError{parameter: parameter, message: "Invalid image size", allowed: allowed}
+	}
+
+	i := slices.IndexFunc(sizes, func(s utils.Size) bool {
+		return (width == 0 || s.Width == width) && (height == 0 || s.Height == height)
+	})
+	if i < 0 {
+		parameter := "w"
+		if width == 0 {
+			parameter = "h"
+		}
+
+		return spec, true, &variantError{parameter: parameter, message: "Image size not offered for this ad type", allowed: allowed}
+	}
+
+	spec.size = sizes[i]
+	return spec, true, nil
+}
+
+// dimension parses a side in pixels, 0 when absent
+func dimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid dimension %q", s)
+	}
+
+	return n, nil
+}
+
+// Variants made from the ad images, the least recently served dropped first
+// once they hold more than limit bytes. Entries are keyed by the modification
+// time of their source, so replacing an image never serves its old variants.
+type variants struct {
+	limit   int64
+	workers chan struct{} // Bounds the images decoded at once
+
+	mu       sync.Mutex
+	size     int64
+	order    *list.List // Of *variantItem, most recent first
+	items    map[string]*list.Element
+	inflight map[string]*variantCall
+}
+
+type variantItem struct {
+	key string
+	val *variant
+}
+
+// Callers asking for a variant being made wait for it instead of making it again
+type variantCall struct {
+	done chan struct{}
+	val  *variant
+	err  error
+}
+
+func newVariants(limit int64) *variants {
+	return &variants{
+		limit:    limit,
+		workers:  make(chan struct{}, runtime.NumCPU()),
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*variantCall),
+	}
+}
+
+// get returns the variant of the source image, making it on a miss
+func (v *variants) get(name string, modified time.Time, source io.ReadSeeker, spec variantSpec) (*variant, error) {
+	key := fmt.Sprintf("%s@%d/%s.%s", name, modified.UnixNano(), spec.size, spec.format)
+
+	v.mu.Lock()
+	if el, found := v.items[key]; found {
+		v.order.MoveToFront(el)
+		v.mu.Unlock()
+
+		metrics.Cache("cdn_variants", true)
+		return el.Value.(*variantItem).val, nil
+	}
+
+	if call, found := v.inflight[key]; found {
+		v.mu.Unlock()
+
+		<-call.done
+		return call.val, call.err
+	}
+
+	call := &variantCall{done: make(chan struct{})}
+	v.inflight[key] = call
+	v.mu.Unlock()
+
+	metrics.Cache("cdn_variants", false)
+
+	v.workers <- struct{}{}
+	call.val, call.err = render(source, spec)
+	<-v.workers
+
+	v.mu.Lock()
+	delete(v.inflight, key)
+	if call.err == nil {
+		v.add(key, call.val)
+	}
+	v.mu.Unlock()
+
+	close(call.done)
+	return call.val, call.err
+}
+
+// add caches a variant, evicting the oldest ones past the limit. Variants
+// larger than the whole cache are only served.
+func (v *variants) add(key string, val *variant) {
+	size := int64(len(val.data))
+	if size > v.limit {
+		return
+	}
+
+	v.items[key] = v.order.PushFront(&variantItem{key: key, val: val})
+	v.size += size
+
+	for v.size > v.limit {
+		oldest := v.order.Back()
+		item := oldest.Value.(*variantItem)
+
+		v.order.Remove(oldest)
+		delete(v.items, item.key)
+		v.size -= int64(len(item.val.data))
+	}
+}
+
+// render decodes the source, scales it and encodes it to the format asked
+// for, keeping the source format when it can be encoded and PNG otherwise
+func render(source io.ReadSeeker, spec variantSpec) (*variant, error) {
+	cfg, format, err := image.DecodeConfig(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image header: %w", err)
+	}
+
+	if cfg.Width > maxVariantSource || cfg.Height > maxVariantSource {
+		return nil, fmt.Errorf("image of %dx%d is too large to resize", cfg.Width, cfg.Height)
+	}
+
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the image: %w", err)
+	}
+
+	if spec.format == "" {
+		spec.format = "png"
+		if _, found := variantFormats[format]; found {
+			spec.format = format
+		}
+	}
+
+	img := src
+	if spec.size != (utils.Size{}) && spec.size != (utils.Size{Width: cfg.Width, Height: cfg.Height}) {
+		scaled := image.NewRGBA(image.Rect(0, 0, spec.size.Width, spec.size.Height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Over, nil)
+		img = scaled
+	}
+
+	var buf bytes.Buffer
+	switch spec.format {
+	case "jpeg":
+		// JPEG has no transparency, which would otherwise turn black
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		err = jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 85})
+	default:
+		err = png.Encode(&buf, img)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the image: %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return &variant{
+		data:        buf.Bytes(),
+		etag:        `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`,
+		contentType: variantFormats[spec.format],
+	}, nil
+}
+
+// serveVariant answers with a variant of the image, see parseVariant
+func serveVariant(w http.ResponseWriter, r *http.Request, name string, val *variant, modified time.Time) {
+	header := w.Header()
+	header.Set("Content-Type", val.contentType)
+	header.Set("ETag", val.etag)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", cacheControl(r, name))
+
+	http.ServeContent(w, r, "", modified, bytes.NewReader(val.data))
+}
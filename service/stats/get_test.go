@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"service/access"
+	"service/config"
+	"service/database/memory"
+	"service/router"
+	"service/router/routertest"
+	"service/utils"
+)
+
+// newTestServer serves the stats routes backed by an in-memory store
+func newTestServer(t *testing.T, cfg *config.Config) (*routertest.Server, *memory.Store) {
+	t.Helper()
+
+	mem := memory.New(cfg.Limits)
+	store := config.NewStore("", cfg)
+	repos := mem.Repositories()
+
+	mux := http.NewServeMux()
+	New(store, repos, access.New(store, repos, router.NewOrigins())).Register(router.New(mux))
+
+	return routertest.NewServer(t, mux), mem
+}
+
+func TestStats(t *testing.T) {
+	var geodeCalls atomic.Int32
+	geode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		geodeCalls.Add(1)
+		if r.URL.Path != "/v1/mods/arcticwoof.player_advertisements" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"error":"","payload":{"download_count":1234}}`)
+	}))
+	defer geode.Close()
+
+	cfg := config.Default()
+	cfg.Endpoints.Geode = geode.URL
+
+	ts, mem := newTestServer(t, cfg)
+
+	owner := mem.AddUser(utils.User{ID: "1", Username: "owner", TotalViews: 40, TotalClicks: 4})
+	mem.AddUser(utils.User{ID: "2", Username: "banned", TotalViews: 1000, TotalClicks: 100, Banned: true})
+	mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1})
+	mem.AddAd(utils.Ad{UserID: owner.ID, Type: 2})
+	mem.AddAd(utils.Ad{UserID: owner.ID, Type: 1, Pending: true})
+
+	t.Run("ping", func(t *testing.T) {
+		resp, body := ts.Get(t, "/stats", nil)
+		if resp.StatusCode != http.StatusOK || string(body) != "pong!" {
+			t.Errorf("got %d %q, expected 200 pong!", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("totals need a session", func(t *testing.T) {
+		resp, body := ts.Get(t, "/stats/get", nil)
+		routertest.ExpectError(t, resp, body, http.StatusUnauthorized, router.CodeUnauthorized)
+	})
+
+	t.Run("totals of the session", func(t *testing.T) {
+		resp, body := ts.Get(t, "/stats/get", mem.Login(owner))
+		if stats := routertest.Decode[utils.Stats](t, resp, body); stats != (utils.Stats{Views: 40, Clicks: 4}) {
+			t.Errorf("got %+v, expected 40 views and 4 clicks", stats)
+		}
+	})
+
+	t.Run("global totals skip banned users and pending ads", func(t *testing.T) {
+		resp, body := ts.Get(t, "/stats/global", nil)
+		if stats := routertest.Decode[utils.GlobalStats](t, resp, body); stats != (utils.GlobalStats{TotalViews: 40, TotalClicks: 4, AdCount: 2}) {
+			t.Errorf("got %+v", stats)
+		}
+	})
+
+	t.Run("downloads are cached", func(t *testing.T) {
+		for range 2 {
+			resp, body := ts.Get(t, "/stats/downloads", nil)
+			if resp.StatusCode != http.StatusOK || string(body) != "1234" {
+				t.Fatalf("got %d %q, expected 200 1234", resp.StatusCode, body)
+			}
+		}
+
+		if n := geodeCalls.Load(); n != 1 {
+			t.Errorf("asked Geode %d times, expected once", n)
+		}
+	})
+}
+
+func TestDownloadsUpstreamFailure(t *testing.T) {
+	geode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer geode.Close()
+
+	cfg := config.Default()
+	cfg.Endpoints.Geode = geode.URL
+
+	ts, _ := newTestServer(t, cfg)
+
+	resp, body := ts.Get(t, "/stats/downloads", nil)
+	routertest.ExpectError(t, resp, body, http.StatusInternalServerError, router.CodeUpstreamFailed)
+}
@@ -3,21 +3,49 @@ package stats
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"service/access"
+	"service/config"
+	"service/database"
 	"service/log"
+	"service/router"
+
+	"github.com/patrickmn/go-cache"
 )
 
-func init() {
-	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("Statistics API service pinged")
+// Statistics endpoints for the dashboard
+type Handler struct {
+	store     *config.Store // Read on each request, as settings reload
+	ads       database.AdRepository
+	users     database.UserRepository
+	auth      *access.Handler
+	downloads *cache.Cache
+}
+
+func New(store *config.Store, repos *database.Repositories, auth *access.Handler) *Handler {
+	return &Handler{
+		store:     store,
+		ads:       repos.Ads,
+		users:     repos.Users,
+		auth:      auth,
+		downloads: cache.New(5*time.Minute, 10*time.Minute),
+	}
+}
+
+func (h *Handler) Register(rt *router.Router) {
+	rt.Doc(router.Operation{
+		Summary: "Check the stats service",
+		Text:    "pong!",
+	}).Get("/stats", func(w http.ResponseWriter, r *http.Request) {
+		log.Ctx(r.Context()).Debug("Statistics API service pinged")
 		header := w.Header()
 
-		header.Set("Access-Control-Allow-Origin", "*")
-		header.Set("Access-Control-Allow-Methods", "GET")
-		header.Set("Access-Control-Allow-Headers", "Content-Type")
 		header.Set("Content-Type", "text/plain")
 
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "pong!")
 	})
+
+	h.registerGet(rt)
 }
@@ -1,6 +1,25 @@
 package utils
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// Discord account attached to a login session
+type DiscordUser struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Discriminator string `json:"discriminator"`
+	Avatar        string `json:"avatar"`
+}
+
+// HashSessionID derives the key a session is stored under from the cookie
+// carrying it, so that a leaked table does not hand out logins
+func HashSessionID(raw string) string {
+	h := sha256.Sum256([]byte(raw))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
 
 type ArgonUser struct {
 	Account      int       `json:"account_id"`    // Player account ID
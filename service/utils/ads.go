@@ -13,6 +13,32 @@ const (
 	AdTypeSkyscraper AdType = "skyscraper" // Vertical ads
 )
 
+// Pixel dimensions of an ad image
+type Size struct {
+	Width  int
+	Height int
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// Sizes are the dimensions an ad of the type is uploaded at, then the smaller
+// ones the CDN resizes it to
+func (t AdType) Sizes() []Size {
+	switch t {
+	case AdTypeBanner:
+		return []Size{{1456, 180}, {728, 90}, {364, 45}}
+	case AdTypeSquare:
+		return []Size{{1456, 1456}, {728, 728}, {364, 364}, {182, 182}}
+	case AdTypeSkyscraper:
+		return []Size{{180, 1456}, {90, 728}, {45, 364}}
+
+	default:
+		return nil
+	}
+}
+
 type AdEvent string // Table to save stats to
 
 const (
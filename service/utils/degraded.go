@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"service/log"
+	"service/metrics"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Longest wait between two connection attempts at startup
+const maxBackoff = 30 * time.Second
+
+// Set while the database is unreachable, see Monitor
+var degraded atomic.Bool
+
+func init() {
+	metrics.NewGaugeFunc("database_degraded", "1 while the database is unreachable and the service runs degraded.", func(context.Context) (float64, error) {
+		if degraded.Load() {
+			return 1, nil
+		}
+
+		return 0, nil
+	})
+}
+
+// Degraded reports whether the database is unreachable. Handlers then serve
+// reads from memory, spool events and refuse other changes.
+func Degraded() bool {
+	return degraded.Load()
+}
+
+// IsConnectionError reports whether err means the database could not be
+// reached, rather than that it refused the query
+func IsConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// Monitor pings the database every interval until ctx is done, entering
+// degraded mode when a ping fails and leaving it once one succeeds again.
+// recovered runs after each recovery, with the mode already left so that
+// new writes go to the database while earlier ones are replayed.
+func Monitor(ctx context.Context, every time.Duration, recovered func(ctx context.Context)) {
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			pingCtx, cancel := WithTimeout(ctx)
+			err := Ping(pingCtx)
+			cancel()
+
+			switch {
+			case err != nil && !degraded.Swap(true):
+				log.Error("Database unavailable, switching to degraded mode: %s", err.Error())
+
+			case err == nil && degraded.Swap(false):
+				log.Done("Database reachable again, leaving degraded mode")
+				recovered(ctx)
+			}
+		}
+	}()
+}
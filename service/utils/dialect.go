@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type SQLDialect string // Flavour of SQL spoken by the connected database
+
+const (
+	DialectMySQL  SQLDialect = "mysql"  // MariaDB or MySQL server
+	DialectSQLite SQLDialect = "sqlite" // Local file database
+)
+
+func SQLDialectFromString(s string) (SQLDialect, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "mysql", "mariadb":
+		return DialectMySQL, nil
+	case "sqlite", "sqlite3":
+		return DialectSQLite, nil
+
+	default:
+		return "", fmt.Errorf("unsupported database driver %s", s)
+	}
+}
+
+// Ago returns an expression for the current time minus d
+func (d SQLDialect) Ago(dur time.Duration) string {
+	seconds := int64(dur.Seconds())
+
+	switch d {
+	case DialectSQLite:
+		return fmt.Sprintf("datetime('now', '-%d seconds')", seconds)
+
+	default:
+		return fmt.Sprintf("NOW() - INTERVAL %d SECOND", seconds)
+	}
+}
+
+// OnConflict returns the upsert clause to append to an INSERT, updating with the given assignments when key already exists
+func (d SQLDialect) OnConflict(key string, assignments ...string) string {
+	switch d {
+	case DialectSQLite:
+		return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", key, strings.Join(assignments, ", "))
+
+	default:
+		return fmt.Sprintf("ON DUPLICATE KEY UPDATE %s", strings.Join(assignments, ", "))
+	}
+}
+
+// Excluded references the value a conflicting INSERT attempted to write to column
+func (d SQLDialect) Excluded(column string) string {
+	switch d {
+	case DialectSQLite:
+		return fmt.Sprintf("excluded.%s", column)
+
+	default:
+		return fmt.Sprintf("VALUES(%s)", column)
+	}
+}
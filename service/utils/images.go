@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ad images are stored below StorageDir as <type>/<user>-<id>.webp and served
+// at the same path under /cdn/
+var StorageDir = filepath.Join("..", "ad_storage")
+
+// Folder of the images of ads waiting for review, which the CDN only serves to
+// their owner and staff. Approving an ad moves its image out of it.
+const PendingFolder = "pending"
+
+// ImageName is the path of the ad image below StorageDir and /cdn/
+func ImageName(ad *Ad) (string, error) {
+	t, err := AdTypeFromInt(ad.Type)
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Join(string(t), fmt.Sprintf("%s-%d.webp", ad.UserID, ad.AdID))
+	if ad.Pending {
+		name = path.Join(PendingFolder, name)
+	}
+
+	return name, nil
+}
+
+// ImagePath is the file of the ad image
+func ImagePath(ad *Ad) (string, error) {
+	name, err := ImageName(ad)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(StorageDir, filepath.FromSlash(name)), nil
+}
+
+// PublishedImageURL is the public URL an image URL of a pending ad moves to
+// once approved, with a new version so that nothing cached before is reused
+func PublishedImageURL(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/cdn/"+PendingFolder+"/") {
+		return imageURL
+	}
+
+	u.Path = "/cdn/" + strings.TrimPrefix(u.Path, "/cdn/"+PendingFolder+"/")
+
+	query := u.Query()
+	query.Set("v", strconv.FormatInt(time.Now().Unix(), 10))
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// PendingImageURL is the URL under the pending folder of a public image URL,
+// for ads submitted before their images were kept apart
+func PendingImageURL(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/cdn/") || strings.HasPrefix(u.Path, "/cdn/"+PendingFolder+"/") {
+		return imageURL
+	}
+
+	u.Path = "/cdn/" + PendingFolder + "/" + strings.TrimPrefix(u.Path, "/cdn/")
+
+	query := u.Query()
+	query.Set("v", strconv.FormatInt(time.Now().Unix(), 10))
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
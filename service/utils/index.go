@@ -1,95 +1,280 @@
 package utils
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"fmt"
-	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"service/config"
 	"service/log"
+	"service/metrics"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
-// Concurrent database connection
-var data *sql.DB
+// The open database, swapped whole by Connect while handlers and the
+// monitor read it
+type connection struct {
+	db      *sql.DB
+	dialect SQLDialect    // Picked with DB_DRIVER
+	timeout time.Duration // Upper bound of a single data-access call, set with DB_QUERY_TIMEOUT
+}
+
+var conn atomic.Pointer[connection]
+
+// current is the open connection, or the defaults before Connect
+func current() *connection {
+	if c := conn.Load(); c != nil {
+		return c
+	}
+
+	return &connection{dialect: DialectMySQL, timeout: 5 * time.Second}
+}
 
-// safely prepare the sql statement
-func PrepareStmt(db *sql.DB, sql string) (*sql.Stmt, error) {
-	if db != nil {
-		log.Debug("Preparing connection for statement %s", sql)
-		return db.Prepare(sql)
-	} else {
+// Time spent in each kind of statement, named by statementName
+var queryDuration = metrics.NewHistogram("db_query_duration_seconds", "Time spent executing SQL statements.", metrics.QueryBuckets, "statement")
+
+// Prepared statements shared by every caller, keyed by their query. They
+// belong to statementsDb and are retired when another connection asks.
+var statements = make(map[string]*Stmt)
+var statementsDb *sql.DB
+var statementsMu sync.Mutex
+
+// PrepareStmt returns the shared prepared statement for a query, preparing it
+// on first use. Statements stay open until CloseStatements or a new
+// connection retires them, callers must not close them.
+func PrepareStmt(ctx context.Context, db *sql.DB, query string) (*Stmt, error) {
+	if db == nil {
 		return nil, fmt.Errorf("database connection non-existent")
 	}
+
+	statementsMu.Lock()
+	if statementsDb != db {
+		go retire(statements)
+		statements = make(map[string]*Stmt)
+		statementsDb = db
+	}
+
+	stmt, found := statements[query]
+	statementsMu.Unlock()
+
+	if found {
+		return stmt, nil
+	}
+
+	// a slow prepare only holds up the callers of this query
+	log.Ctx(ctx).Debug("Preparing connection for statement %s", query)
+	prepared, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = &Stmt{Stmt: prepared, db: db, query: query, name: statementName(query)}
+
+	statementsMu.Lock()
+	defer statementsMu.Unlock()
+
+	switch existing, found := statements[query]; {
+	case statementsDb != db:
+		// swapped meanwhile, the caller still gets an answer from its connection
+		stmt.close()
+	case found:
+		stmt.close()
+		return existing, nil
+	default:
+		statements[query] = stmt
+	}
+
+	return stmt, nil
 }
 
-func Db() *sql.DB {
-	return data
+// Stmt is a shared prepared statement timing its executions
+type Stmt struct {
+	*sql.Stmt
+	db    *sql.DB
+	query string
+	name  string
+
+	mu     sync.RWMutex // Read by calls, written by close once they are done
+	closed bool
 }
 
-// initializeSchema reads and executes the schema.sql file to create tables if they don't exist
-func initializeSchema() error {
-	schemaPath := filepath.Join("database", "schema.sql")
-	log.Debug("Reading database schema from %s", schemaPath)
+func (s *Stmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	defer queryDuration.Since(time.Now(), s.name)
 
-	schemaSQL, err := os.ReadFile(schemaPath)
-	if err != nil {
-		return fmt.Errorf("failed to read schema file: %w", err)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return s.db.ExecContext(ctx, s.query, args...)
 	}
 
-	// Split the SQL file into individual statements
-	statements := strings.Split(string(schemaSQL), ";")
+	return s.Stmt.ExecContext(ctx, args...)
+}
+
+// QueryContext times the query up to its first row, not reading the rows.
+// The rows keep the statement open after a close until they are.
+func (s *Stmt) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+	defer queryDuration.Since(time.Now(), s.name)
 
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" || strings.HasPrefix(stmt, "--") {
-			continue
-		}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		log.Debug("Executing schema statement: %.50s...", stmt)
-		_, err := data.Exec(stmt)
-		if err != nil {
-			return fmt.Errorf("failed to execute schema statement: %w", err)
-		}
+	if s.closed {
+		return s.db.QueryContext(ctx, s.query, args...)
 	}
 
-	log.Done("Database schema initialized successfully")
-	return nil
+	return s.Stmt.QueryContext(ctx, args...)
 }
 
-func init() {
-	var err error
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...any) *sql.Row {
+	defer queryDuration.Since(time.Now(), s.name)
 
-	uri := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASS"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_NAME"),
-	)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	log.Info("Connecting to database with URI: %s", uri)
-	data, err = sql.Open("mysql", uri)
-	if err != nil {
-		log.Error("Failed to establish MariaDB connection: %s", err.Error())
+	if s.closed {
+		return s.db.QueryRowContext(ctx, s.query, args...)
+	}
+
+	return s.Stmt.QueryRowContext(ctx, args...)
+}
+
+// close releases the statement once the calls using it return. Callers still
+// holding it afterwards run their query without preparing it.
+func (s *Stmt) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
 		return
 	}
 
-	err = data.Ping()
+	s.closed = true
+	if err := s.Stmt.Close(); err != nil {
+		log.Error("Failed to close statement %s: %s", s.query, err.Error())
+	}
+}
+
+var statementTable = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+(\w+)`)
+
+// statementName labels a query by its verb and first table, such as
+// "select advertisements", keeping the number of series small
+func statementName(query string) string {
+	verb, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	name := strings.ToLower(verb)
+
+	if m := statementTable.FindStringSubmatch(query); m != nil {
+		name += " " + strings.ToLower(m[1])
+	}
+
+	return name
+}
+
+// CloseStatements releases every prepared statement in the registry
+func CloseStatements() {
+	statementsMu.Lock()
+	old := statements
+	statements = make(map[string]*Stmt)
+	statementsMu.Unlock()
+
+	retire(old)
+}
+
+// retire closes statements of a registry no longer handed out
+func retire(stmts map[string]*Stmt) {
+	for _, stmt := range stmts {
+		stmt.close()
+	}
+}
+
+// WithTimeout bounds a data-access call by the configured query timeout
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, current().timeout)
+}
+
+func Db() *sql.DB {
+	return current().db
+}
+
+// Ping checks that the database answers, failing when Connect did not
+// manage to open it
+func Ping(ctx context.Context) error {
+	db := current().db
+	if db == nil {
+		return fmt.Errorf("database connection non-existent")
+	}
+
+	return db.PingContext(ctx)
+}
+
+// Dialect of the active database connection
+func DbDialect() SQLDialect {
+	return current().dialect
+}
+
+// connection driver name and data source for the configured dialect
+func dataSource(d SQLDialect, cfg config.Database) (string, string) {
+	switch d {
+	case DialectSQLite:
+		return "sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate", cfg.Path)
+
+	default:
+		return "mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", cfg.User, cfg.Pass, cfg.Host, cfg.Name)
+	}
+}
+
+// Connect opens the configured database
+func Connect(cfg config.Database) error {
+	dialect, err := SQLDialectFromString(cfg.Driver)
 	if err != nil {
-		log.Error("Failed to ping database: %s", err.Error())
-		return
-	} else if data == nil {
-		log.Error("Database connection is nil")
-		return
+		return err
 	}
 
-	log.Print("MariaDB connection established.")
+	driver, uri := dataSource(dialect, cfg)
 
-	// Initialize database schema (create tables if they don't exist)
-	if err := initializeSchema(); err != nil {
-		log.Error("Failed to initialize database schema: %s", err.Error())
-		return
+	log.Info("Connecting to %s database at %s", dialect, cmp.Or(cfg.Host, cfg.Path))
+	data, err := sql.Open(driver, uri)
+	if err != nil {
+		return fmt.Errorf("failed to establish %s connection: %w", dialect, err)
+	}
+
+	// kept even when the pings below fail, the monitor retrying it
+	conn.Store(&connection{db: data, dialect: dialect, timeout: cfg.QueryTimeout.Duration})
+
+	data.SetMaxOpenConns(cfg.MaxOpenConns)
+	data.SetMaxIdleConns(cfg.MaxIdleConns)
+	data.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+	data.SetConnMaxIdleTime(cfg.ConnMaxIdleTime.Duration)
+
+	// the database may still be starting, as with a fresh container
+	backoff := cfg.ConnectBackoff.Duration
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := WithTimeout(context.Background())
+		err = data.PingContext(ctx)
+		cancel()
+
+		if err == nil {
+			break
+		}
+
+		if attempt >= cfg.ConnectAttempts {
+			degraded.Store(true)
+			return fmt.Errorf("failed to ping database after %d attempts: %w", attempt, err)
+		}
+
+		log.Warn("Database ping %d of %d failed, retrying in %s: %s", attempt, cfg.ConnectAttempts, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
 	}
+
+	log.Print("%s connection established.", dialect)
+	return nil
 }
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE counters (id INTEGER PRIMARY KEY, n INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO counters (id, n) VALUES (1, 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPrepareStmtShared(t *testing.T) {
+	t.Cleanup(CloseStatements)
+	db := openSQLite(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	got := make([]*Stmt, 8)
+	for i := range got {
+		wg.Go(func() {
+			stmt, err := PrepareStmt(ctx, db, "UPDATE counters SET n = n + 1 WHERE id = 1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			got[i] = stmt
+		})
+	}
+	wg.Wait()
+
+	for _, stmt := range got[1:] {
+		if stmt != got[0] {
+			t.Fatal("concurrent callers got different statements for the same query")
+		}
+	}
+}
+
+func TestPrepareStmtSwap(t *testing.T) {
+	t.Cleanup(CloseStatements)
+	old, fresh := openSQLite(t), openSQLite(t)
+	ctx := context.Background()
+
+	const query = "UPDATE counters SET n = n + 1 WHERE id = 1"
+	held, err := PrepareStmt(ctx, old, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another caller moves the registry to a new connection, retiring held
+	if _, err := PrepareStmt(ctx, fresh, query); err != nil {
+		t.Fatal(err)
+	}
+
+	CloseStatements()
+
+	// the holder still reaches its own connection
+	if _, err := held.ExecContext(ctx); err != nil {
+		t.Fatalf("retired statement failed: %v", err)
+	}
+
+	var n int
+	if err := old.QueryRow("SELECT n FROM counters WHERE id = 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("old connection counted %d updates, expected 1", n)
+	}
+}
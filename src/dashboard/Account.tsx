@@ -4,6 +4,7 @@ import { useEffect, useState } from "react";
 import square02 from "../assets/square02.png";
 import { copyText } from "../page/Login";
 import "../misc/Log.mjs"
+import { readApiError } from "../utils/apiError";
 
 import ContentCopyIcon from "@mui/icons-material/ContentCopyOutlined";
 import DoneIcon from "@mui/icons-material/DoneOutlined";
@@ -116,9 +117,9 @@ export default function Account() {
                 setPendingCount(Array.isArray(data) ? data.length : 0);
                 setShowingPending(true);
             } else {
-                const txt = await res.text();
-                console.warn("Pending ads endpoint:", res.status, txt);
-                alert("Failed to fetch pending ads: " + txt);
+                const error = await readApiError(res);
+                console.warn("Pending ads endpoint:", res.status, error);
+                alert("Failed to fetch pending ads: " + error.message);
             };
         } catch (err) {
             console.error(err);
@@ -181,8 +182,8 @@ export default function Account() {
                 setReportedCount(Array.isArray(data) ? data.length : 0);
                 setShowingReported(true);
             } else {
-                const txt = await res.text();
-                alert("Failed to fetch reported ads: " + txt);
+                const error = await readApiError(res);
+                alert("Failed to fetch reported ads: " + error.message);
             };
         } catch (err) {
             console.error(err);
@@ -7,6 +7,7 @@ import SearchIcon from "@mui/icons-material/SearchOutlined";
 import SyncIcon from "@mui/icons-material/SyncOutlined";
 import CheckCircleIcon from "@mui/icons-material/CheckCircleOutlined";
 import RulesButton from "../popup/Rules";
+import { readApiError } from "../utils/apiError";
 
 export default function Create() {
     const [selectedSize, setSelectedSize] = useState<
@@ -256,9 +257,25 @@ export default function Create() {
                 const r = await resp.json();
                 console.debug(`Ad of ID ${r["ad_id"]} stored at ${r["image_url"]}`);
             } else {
-                const txt = await resp.text();
-                console.error("Upload failed:", txt);
-                alert("Failed to submit advertisement.");
+                const error = await readApiError(resp);
+                console.error("Upload failed:", error);
+
+                switch (error.code) {
+                    case "AD_LIMIT_REACHED":
+                        alert(`You have reached the maximum number of active advertisements (${error.details?.limit}).`);
+                        break;
+                    case "IMAGE_TOO_LARGE":
+                        alert(`The image is too large, the limit is ${error.details?.max_bytes} bytes.`);
+                        break;
+                    case "SUBMISSIONS_DISABLED":
+                        alert("Submissions are currently disabled, please try again later.");
+                        break;
+                    case "BANNED":
+                        alert("Your account is banned from submitting advertisements.");
+                        break;
+                    default:
+                        alert("Failed to submit advertisement.");
+                }
             }
         } catch (err) {
             console.error(err);
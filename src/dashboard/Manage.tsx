@@ -3,6 +3,7 @@ import "./Dashboard.css";
 import square02 from "../assets/square02.png";
 import { useEffect, useState } from "react";
 import { SiKofi } from "react-icons/si";
+import { readApiError } from "../utils/apiError";
 
 type Ad = {
     id: number;
@@ -94,8 +95,8 @@ function Manage() {
             });
 
             if (!res.ok) {
-                const text = await res.text();
-                alert(`Failed to boost ad: ${text}`);
+                const error = await readApiError(res);
+                alert(`Failed to boost ad: ${error.message}`);
                 return;
             }
 
@@ -0,0 +1,30 @@
+/**
+ * Error envelope returned by every failing API endpoint
+ */
+export type ApiError = {
+    code: string;
+    message: string;
+    details?: Record<string, unknown>;
+    request_id?: string;
+};
+
+/**
+ * Reads the error envelope of a failed response, falling back to the raw body
+ * for responses that did not come from the API handlers
+ * @param res - The failed response
+ * @returns The parsed error
+ */
+export async function readApiError(res: Response): Promise<ApiError> {
+    const text = await res.text();
+
+    try {
+        const body = JSON.parse(text);
+        if (body && typeof body.code === "string") {
+            return body as ApiError;
+        }
+    } catch {
+        // not JSON
+    }
+
+    return { code: "UNKNOWN", message: text || res.statusText };
+}
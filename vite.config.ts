@@ -23,6 +23,7 @@ export default defineConfig({
       '/ads': 'http://localhost:3000',
       '/proxy': 'http://localhost:3000',
       '/account': 'http://localhost:3000',
+      '/v1': 'http://localhost:3000',
     },
   },
   "build": {